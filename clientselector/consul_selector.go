@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"../src"
@@ -31,6 +32,12 @@ type ConsulClientSelector struct {
 	len                int
 	HashServiceAndArgs HashServiceAndArgs
 	Client             *src.Client
+	//Logger logs the errors of Consul, the Logger of Client if nil.
+	//The errors of NewConsulClientSelector go to src.DefaultLogger.
+	Logger src.Logger
+
+	//mu guards the weighted servers, which HandleFailedServer lowers while calls select them
+	mu sync.Mutex
 }
 
 // NewConsulClientSelector creates a ConsulClientSelector
@@ -56,26 +63,51 @@ func (s *ConsulClientSelector) SetSelectMode(sm src.SelectMode) {
 	s.SelectMode = sm
 }
 
+//GetSelectMode returns the SelectMode servers are selected with
+func (s *ConsulClientSelector) GetSelectMode() src.SelectMode {
+	return s.SelectMode
+}
+
+func (s *ConsulClientSelector) logger() src.Logger {
+	return selectorLogger(s.Logger, s.Client)
+}
+
 func (s *ConsulClientSelector) AllClients(clientCodecFunc src.ClientCodecFunc) []*rpc.Client {
 	var clients []*rpc.Client
 
 	for _, sv := range s.Servers {
 		ss := strings.Split(sv.Address, "@")
 		c, err := src.NewDirectRPCClient(s.Client, clientCodecFunc, ss[0], ss[1], s.dailTimeout)
-		if err == nil {
-			clients = append(clients, c)
+		if err != nil {
+			s.logger().Warn("connecting to server", "server", sv.Address, "err", err)
+			continue
 		}
+		clients = append(clients, c)
 	}
 
 	return clients
 }
 
+//HandleFailedServer lowers the weight of the failed server for WeightedRoundRobin
+func (s *ConsulClientSelector) HandleFailedServer(network, address string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	failWeighted(s.WeightedServers, func(server interface{}) bool {
+		return server.(*api.AgentService).Address == network+"@"+address
+	})
+}
+
 func (s *ConsulClientSelector) start() {
 	if s.consulConfig == nil {
 		s.consulConfig = api.DefaultConfig()
 		s.consulConfig.Address = s.ConsulAddress
 	}
-	s.client, _ = api.NewClient(s.consulConfig)
+	client, err := api.NewClient(s.consulConfig)
+	if err != nil {
+		s.logger().Error("connecting to Consul", "address", s.consulConfig.Address, "err", err)
+		return
+	}
+	s.client = client
 
 	s.pullServers()
 
@@ -92,6 +124,8 @@ func (s *ConsulClientSelector) pullServers() {
 	ass, err := agent.Services()
 
 	if err != nil {
+		// the servers known so far are kept
+		s.logger().Warn("pulling servers from Consul", "service", s.ServiceName, "err", err)
 		return
 	}
 
@@ -102,29 +136,33 @@ func (s *ConsulClientSelector) pullServers() {
 		}
 	}
 	s.Servers = services
+	s.len = len(services)
 }
 
 func (s *ConsulClientSelector) createWeighted(ass map[string]*api.AgentService) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.WeightedServers = make([]*Weighted, len(s.Servers))
 
 	i := 0
 	for k, v := range ass {
 		if strings.HasPrefix(k, s.ServiceName) {
 			s.WeightedServers[i] = &Weighted{Server: v, Weight: 1, EffectiveWeight: 1}
-			i++
 			if len(v.Tags) > 0 {
 				if values, err := url.ParseQuery(v.Tags[0]); err == nil {
 					w := values.Get("weight")
 					if w != "" {
 						weight, err := strconv.Atoi(w)
 						if err != nil {
+							s.logger().Warn("parsing server weight", "server", v.Address, "weight", w, "err", err)
+						} else {
 							s.WeightedServers[i].Weight = weight
 							s.WeightedServers[i].EffectiveWeight = weight
 						}
 					}
 				}
 			}
-
+			i++
 		}
 	}
 
@@ -157,7 +195,10 @@ func (s *ConsulClientSelector) Select(clientCodecFunc src.ClientCodecFunc, optio
 		ss := strings.Split(server.Address, "@")
 		return src.NewDirectRPCClient(s.Client, clientCodecFunc, ss[0], ss[1], s.dailTimeout)
 	} else if s.SelectMode == src.WeightedRoundRobin {
+		s.mu.Lock()
 		server := nextWeighted(s.WeightedServers).Server.(*api.AgentService)
+		s.mu.Unlock()
+		s.logger().Debug("selected weighted server", "server", server.Address)
 		ss := strings.Split(server.Address, "@")
 		return src.NewDirectRPCClient(s.Client, clientCodecFunc, ss[0], ss[1], s.dailTimeout)
 	}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"math/rand"
 	"net/rpc"
+	"sync"
 	"time"
 
 	"../src"
@@ -26,6 +27,9 @@ type MultiClientSelector struct {
 	len                int
 	HashServiceAndArgs HashServiceAndArgs
 	Client             *src.Client
+
+	//mu guards the weighted servers, which HandleFailedServer lowers while calls select them
+	mu sync.Mutex
 }
 
 // NewMultiClientSelector creates a MultiClientSelector
@@ -56,6 +60,11 @@ func (s *MultiClientSelector) SetSelectMode(sm src.SelectMode) {
 	s.SelectMode = sm
 }
 
+//GetSelectMode returns the SelectMode servers are selected with
+func (s *MultiClientSelector) GetSelectMode() src.SelectMode {
+	return s.SelectMode
+}
+
 func (s *MultiClientSelector) AllClients(clientCodecFunc src.ClientCodecFunc) []*rpc.Client {
 	var clients []*rpc.Client
 
@@ -69,6 +78,16 @@ func (s *MultiClientSelector) AllClients(clientCodecFunc src.ClientCodecFunc) []
 	return clients
 }
 
+//HandleFailedServer lowers the weight of the failed server for WeightedRoundRobin
+func (s *MultiClientSelector) HandleFailedServer(network, address string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	failWeighted(s.WeightedServers, func(server interface{}) bool {
+		peer := server.(*ServerPeer)
+		return peer.Network == network && peer.Address == address
+	})
+}
+
 //Select returns a rpc client
 func (s *MultiClientSelector) Select(clientCodecFunc src.ClientCodecFunc, options ...interface{}) (*rpc.Client, error) {
 	if s.len == 0 {
@@ -92,7 +111,9 @@ func (s *MultiClientSelector) Select(clientCodecFunc src.ClientCodecFunc, option
 		peer := s.Servers[s.currentServer]
 		return src.NewDirectRPCClient(s.Client, clientCodecFunc, peer.Network, peer.Address, s.dailTimeout)
 	} else if s.SelectMode == src.WeightedRoundRobin {
+		s.mu.Lock()
 		best := nextWeighted(s.WeightedServers)
+		s.mu.Unlock()
 		peer := best.Server.(*ServerPeer)
 		return src.NewDirectRPCClient(s.Client, clientCodecFunc, peer.Network, peer.Address, s.dailTimeout)
 	}
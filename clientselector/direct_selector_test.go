@@ -0,0 +1,44 @@
+package clientselector
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"../src"
+)
+
+// TestMultiClientSelectorFailedServer lowers weights while calls select servers,
+// as heartbeats do; run it with -race.
+func TestMultiClientSelectorFailedServer(t *testing.T) {
+	servers := []*ServerPeer{
+		{Network: src.MemoryNetwork, Address: "selector-test-a", Weight: 3},
+		{Network: src.MemoryNetwork, Address: "selector-test-b", Weight: 1},
+	}
+	s := NewMultiClientSelector(servers, src.WeightedRoundRobin, time.Second)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			s.HandleFailedServer(src.MemoryNetwork, "selector-test-a")
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			// nothing listens at the servers, only the selection matters
+			s.Select(nil)
+		}
+	}()
+	wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, w := range s.WeightedServers {
+		if w.EffectiveWeight < 0 || w.EffectiveWeight > w.Weight {
+			t.Errorf("effective weight of %v is %d, out of [0, %d]", w.Server, w.EffectiveWeight, w.Weight)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/net/context"
@@ -31,6 +32,12 @@ type EtcdClientSelector struct {
 	len                int
 	HashServiceAndArgs HashServiceAndArgs
 	Client             *src.Client
+	//Logger logs the errors of etcd, the Logger of Client if nil.
+	//The errors of NewEtcdClientSelector go to src.DefaultLogger.
+	Logger src.Logger
+
+	//mu guards the weighted servers, which HandleFailedServer lowers while calls select them
+	mu sync.Mutex
 }
 
 // NewEtcdClientSelector creates a EtcdClientSelector
@@ -55,20 +62,40 @@ func (s *EtcdClientSelector) SetSelectMode(sm src.SelectMode) {
 	s.SelectMode = sm
 }
 
+//GetSelectMode returns the SelectMode servers are selected with
+func (s *EtcdClientSelector) GetSelectMode() src.SelectMode {
+	return s.SelectMode
+}
+
+func (s *EtcdClientSelector) logger() src.Logger {
+	return selectorLogger(s.Logger, s.Client)
+}
+
 func (s *EtcdClientSelector) AllClients(clientCodecFunc src.ClientCodecFunc) []*rpc.Client {
 	var clients []*rpc.Client
 
 	for _, sv := range s.Servers {
 		ss := strings.Split(sv, "@")
 		c, err := src.NewDirectRPCClient(s.Client, clientCodecFunc, ss[0], ss[1], s.dailTimeout)
-		if err == nil {
-			clients = append(clients, c)
+		if err != nil {
+			s.logger().Warn("connecting to server", "server", sv, "err", err)
+			continue
 		}
+		clients = append(clients, c)
 	}
 
 	return clients
 }
 
+//HandleFailedServer lowers the weight of the failed server for WeightedRoundRobin
+func (s *EtcdClientSelector) HandleFailedServer(network, address string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	failWeighted(s.WeightedServers, func(server interface{}) bool {
+		return server.(string) == network+"@"+address
+	})
+}
+
 func (s *EtcdClientSelector) start() {
 	cli, err := client.New(client.Config{
 		Endpoints:               s.EtcdServers,
@@ -77,10 +104,11 @@ func (s *EtcdClientSelector) start() {
 	})
 
 	if err != nil {
+		s.logger().Error("connecting to etcd", "servers", strings.Join(s.EtcdServers, ","), "err", err)
 		return
 	}
 	s.KeysAPI = client.NewKeysAPI(cli)
-	s.pullServers()
+	index := s.pullServers()
 
 	// s.ticker = time.NewTicker(s.sessionTimeout)
 	// go func() {
@@ -89,24 +117,27 @@ func (s *EtcdClientSelector) start() {
 	// 	}
 	// }()
 
-	go s.watch()
+	go s.watch(index)
 }
 
-func (s *EtcdClientSelector) watch() {
+// watch pulls the servers whenever they change after index, so that no change after the first pull is missed.
+func (s *EtcdClientSelector) watch(index uint64) {
 	watcher := s.KeysAPI.Watcher(s.BasePath, &client.WatcherOptions{
-		Recursive: true,
+		Recursive:  true,
+		AfterIndex: index,
 	})
 
 	for {
 		res, err := watcher.Next(context.Background())
 		if err != nil {
+			s.logger().Error("watching servers in etcd stopped", "path", s.BasePath, "err", err)
 			break
 		}
 
 		//services are changed, we pull service again instead of processing single node
 		if res.Action == "expire" {
 			s.pullServers()
-		} else if res.Action == "set" || res.Action == "update" {
+		} else if res.Action == "set" || res.Action == "update" || res.Action == "create" {
 			s.pullServers()
 		} else if res.Action == "delete" {
 			s.pullServers()
@@ -114,13 +145,22 @@ func (s *EtcdClientSelector) watch() {
 	}
 }
 
-func (s *EtcdClientSelector) pullServers() {
+// pullServers returns the etcd index which the servers were pulled at, 0 if they were not.
+func (s *EtcdClientSelector) pullServers() uint64 {
 	resp, err := s.KeysAPI.Get(context.TODO(), s.BasePath, &client.GetOptions{
 		Recursive: true,
 		Sort:      true,
 	})
 
-	if err == nil && resp.Node != nil {
+	if err != nil {
+		// the servers known so far are kept
+		s.logger().Warn("pulling servers from etcd", "path", s.BasePath, "err", err)
+		if e, ok := err.(client.Error); ok {
+			return e.Index
+		}
+		return 0
+	}
+	if resp.Node != nil {
 		if len(resp.Node.Nodes) > 0 {
 			var servers []string
 			for _, n := range resp.Node.Nodes {
@@ -136,9 +176,12 @@ func (s *EtcdClientSelector) pullServers() {
 		}
 
 	}
+	return resp.Index
 }
 
 func (s *EtcdClientSelector) createWeighted(nodes client.Nodes) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.WeightedServers = make([]*Weighted, len(s.Servers))
 
 	var inactiveServers []int
@@ -155,6 +198,8 @@ func (s *EtcdClientSelector) createWeighted(nodes client.Nodes) {
 			if w != "" {
 				weight, err := strconv.Atoi(w)
 				if err != nil {
+					s.logger().Warn("parsing server weight", "server", n.Key, "weight", w, "err", err)
+				} else {
 					s.WeightedServers[i].Weight = weight
 					s.WeightedServers[i].EffectiveWeight = weight
 				}
@@ -165,6 +210,7 @@ func (s *EtcdClientSelector) createWeighted(nodes client.Nodes) {
 	s.removeInactiveServers(inactiveServers)
 }
 
+// removeInactiveServers removes the servers at the indexes of inactiveServers. The caller holds mu.
 func (s *EtcdClientSelector) removeInactiveServers(inactiveServers []int) {
 	i := len(inactiveServers) - 1
 	for ; i >= 0; i-- {
@@ -200,7 +246,10 @@ func (s *EtcdClientSelector) Select(clientCodecFunc src.ClientCodecFunc, options
 		ss := strings.Split(server, "@") //
 		return src.NewDirectRPCClient(s.Client, clientCodecFunc, ss[0], ss[1], s.dailTimeout)
 	} else if s.SelectMode == src.WeightedRoundRobin {
+		s.mu.Lock()
 		server := nextWeighted(s.WeightedServers).Server.(string)
+		s.mu.Unlock()
+		s.logger().Debug("selected weighted server", "server", server)
 		ss := strings.Split(server, "@")
 		return src.NewDirectRPCClient(s.Client, clientCodecFunc, ss[0], ss[1], s.dailTimeout)
 	}
@@ -0,0 +1,348 @@
+package clientselector
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/rpc"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/client/v3"
+	"../src"
+)
+
+// EtcdV3ClientSelector is used to select a rpc server from etcd with the v3 API,
+// for clusters which have the v2 API disabled.
+// The servers are the keys under BasePath, like EtcdClientSelector, and are kept up to date
+// by a prefix watch which adds and removes them one by one. A broken watch is resumed
+// from the last revision seen, so that no change is missed.
+type EtcdV3ClientSelector struct {
+	EtcdServers []string
+	KV          clientv3.KV
+	Watcher     clientv3.Watcher
+	//etcd is the client which KV and Watcher belong to, closed by Close
+	etcd               *clientv3.Client
+	sessionTimeout     time.Duration
+	BasePath           string //should endwith serviceName
+	Servers            []string
+	WeightedServers    []*Weighted
+	SelectMode         src.SelectMode
+	dailTimeout        time.Duration
+	rnd                *rand.Rand
+	currentServer      int
+	len                int
+	HashServiceAndArgs HashServiceAndArgs
+	Client             *src.Client
+	//Logger logs the errors of etcd, the Logger of Client if nil.
+	//The errors of NewEtcdV3ClientSelector go to src.DefaultLogger.
+	Logger src.Logger
+
+	//mu guards the servers, which the watch changes while calls select them
+	mu sync.RWMutex
+	//nodes are the active servers by name
+	nodes map[string]*Weighted
+	//revision is the etcd revision the servers are up to date with
+	revision int64
+	cancel   context.CancelFunc
+}
+
+// NewEtcdV3ClientSelector creates a EtcdV3ClientSelector.
+// sessionTimeout is the timeout of dialing etcd, and the delay before a broken watch is resumed.
+func NewEtcdV3ClientSelector(etcdServers []string, basePath string, sessionTimeout time.Duration, sm src.SelectMode, dailTimeout time.Duration) *EtcdV3ClientSelector {
+	selector := &EtcdV3ClientSelector{
+		EtcdServers:    etcdServers,
+		BasePath:       strings.TrimSuffix(basePath, "/"),
+		sessionTimeout: sessionTimeout,
+		SelectMode:     sm,
+		dailTimeout:    dailTimeout,
+		rnd:            rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   etcdServers,
+		DialTimeout: sessionTimeout,
+	})
+	if err != nil {
+		selector.logger().Error("connecting to etcd", "servers", strings.Join(etcdServers, ","), "err", err)
+		return selector
+	}
+	selector.etcd = cli
+	selector.KV = cli
+	selector.Watcher = cli
+
+	selector.start()
+	return selector
+}
+
+func (s *EtcdV3ClientSelector) SetClient(c *src.Client) {
+	s.Client = c
+}
+
+func (s *EtcdV3ClientSelector) SetSelectMode(sm src.SelectMode) {
+	s.SelectMode = sm
+}
+
+//GetSelectMode returns the SelectMode servers are selected with
+func (s *EtcdV3ClientSelector) GetSelectMode() src.SelectMode {
+	return s.SelectMode
+}
+
+func (s *EtcdV3ClientSelector) logger() src.Logger {
+	return selectorLogger(s.Logger, s.Client)
+}
+
+// Close stops watching etcd, and closes the etcd client which NewEtcdV3ClientSelector created.
+func (s *EtcdV3ClientSelector) Close() error {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	if s.etcd != nil {
+		return s.etcd.Close()
+	}
+	return nil
+}
+
+func (s *EtcdV3ClientSelector) AllClients(clientCodecFunc src.ClientCodecFunc) []*rpc.Client {
+	var clients []*rpc.Client
+
+	s.mu.RLock()
+	servers := s.Servers
+	s.mu.RUnlock()
+
+	for _, sv := range servers {
+		ss := strings.Split(sv, "@")
+		c, err := src.NewDirectRPCClient(s.Client, clientCodecFunc, ss[0], ss[1], s.dailTimeout)
+		if err != nil {
+			s.logger().Warn("connecting to server", "server", sv, "err", err)
+			continue
+		}
+		clients = append(clients, c)
+	}
+
+	return clients
+}
+
+//HandleFailedServer lowers the weight of the failed server for WeightedRoundRobin
+func (s *EtcdV3ClientSelector) HandleFailedServer(network, address string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	failWeighted(s.WeightedServers, func(server interface{}) bool {
+		return server.(string) == network+"@"+address
+	})
+}
+
+func (s *EtcdV3ClientSelector) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.pullServers(ctx)
+	go s.watch(ctx)
+}
+
+func (s *EtcdV3ClientSelector) prefix() string {
+	return s.BasePath + "/"
+}
+
+// pullServers replaces the servers by those under BasePath, at the revision of the pull.
+// The servers known already keep their weights.
+func (s *EtcdV3ClientSelector) pullServers(ctx context.Context) error {
+	resp, err := s.KV.Get(ctx, s.prefix(), clientv3.WithPrefix())
+	if err != nil {
+		// the servers known so far are kept
+		s.logger().Warn("pulling servers from etcd", "path", s.BasePath, "err", err)
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.nodes
+	s.nodes = make(map[string]*Weighted)
+	for _, kv := range resp.Kvs {
+		if n := old[s.serverOf(kv)]; n != nil {
+			s.nodes[n.Server.(string)] = n
+		}
+		s.put(kv)
+	}
+	s.revision = resp.Header.Revision
+	s.update()
+	return nil
+}
+
+// watch applies the changes under BasePath until ctx is done.
+// The servers are watched once they have been pulled, since a watch from revision 1 would
+// replay the whole history. A broken watch is resumed after the revision of the last change
+// applied; if that revision has been compacted, the servers are pulled again and watched from there.
+func (s *EtcdV3ClientSelector) watch(ctx context.Context) {
+	for {
+		s.mu.RLock()
+		rev := s.revision + 1
+		s.mu.RUnlock()
+		if rev == 1 {
+			if s.pullServers(ctx) != nil {
+				if !s.waitRetry(ctx) {
+					return
+				}
+				continue
+			}
+			s.mu.RLock()
+			rev = s.revision + 1
+			s.mu.RUnlock()
+		}
+
+		wctx, wcancel := context.WithCancel(ctx)
+		wch := s.Watcher.Watch(clientv3.WithRequireLeader(wctx), s.prefix(), clientv3.WithPrefix(), clientv3.WithRev(rev))
+		pulled := false
+		for resp := range wch {
+			if resp.CompactRevision != 0 {
+				s.logger().Warn("servers in etcd compacted, pulling them again", "path", s.BasePath, "revision", rev, "compact_revision", resp.CompactRevision)
+				pulled = s.pullServers(ctx) == nil
+				break
+			}
+			if err := resp.Err(); err != nil {
+				s.logger().Warn("watching servers in etcd", "path", s.BasePath, "err", err)
+				break
+			}
+			s.apply(resp.Events, resp.Header.Revision)
+		}
+		wcancel()
+
+		if !pulled && !s.waitRetry(ctx) {
+			return
+		}
+	}
+}
+
+// waitRetry waits for the retry delay, and returns false if ctx is done first.
+func (s *EtcdV3ClientSelector) waitRetry(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(s.retryDelay()):
+		return true
+	}
+}
+
+func (s *EtcdV3ClientSelector) retryDelay() time.Duration {
+	if s.sessionTimeout > 0 {
+		return s.sessionTimeout
+	}
+	return time.Second
+}
+
+// apply adds, updates and removes the servers of events.
+func (s *EtcdV3ClientSelector) apply(events []*clientv3.Event, revision int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ev := range events {
+		switch ev.Type {
+		case mvccpb.PUT:
+			s.put(ev.Kv)
+		case mvccpb.DELETE:
+			delete(s.nodes, s.serverOf(ev.Kv))
+		}
+		if ev.Kv.ModRevision > s.revision {
+			s.revision = ev.Kv.ModRevision
+		}
+	}
+	if revision > s.revision {
+		s.revision = revision
+	}
+	s.update()
+}
+
+// serverOf returns the server of a key under BasePath, empty for keys below a server.
+func (s *EtcdV3ClientSelector) serverOf(kv *mvccpb.KeyValue) string {
+	server := strings.TrimPrefix(string(kv.Key), s.prefix())
+	if strings.Contains(server, "/") {
+		return ""
+	}
+	return server
+}
+
+// put adds or updates the server of kv, whose value is its metadata. Inactive servers are removed.
+// The weight of a server which is known already is kept until it changes. The caller holds mu.
+func (s *EtcdV3ClientSelector) put(kv *mvccpb.KeyValue) {
+	server := s.serverOf(kv)
+	if server == "" {
+		return
+	}
+
+	weight := 1
+	if v, err := url.ParseQuery(string(kv.Value)); err == nil {
+		if state := v.Get("state"); state != "" && state != "active" {
+			delete(s.nodes, server)
+			return
+		}
+		if w := v.Get("weight"); w != "" {
+			if weight, err = strconv.Atoi(w); err != nil {
+				s.logger().Warn("parsing server weight", "server", server, "weight", w, "err", err)
+				weight = 1
+			}
+		}
+	}
+
+	if n := s.nodes[server]; n != nil && n.Weight == weight {
+		return
+	}
+	s.nodes[server] = &Weighted{Server: server, Weight: weight, EffectiveWeight: weight}
+}
+
+// update rebuilds Servers and WeightedServers from the nodes, sorted by server. The caller holds mu.
+func (s *EtcdV3ClientSelector) update() {
+	servers := make([]string, 0, len(s.nodes))
+	for server := range s.nodes {
+		servers = append(servers, server)
+	}
+	sort.Strings(servers)
+
+	weighted := make([]*Weighted, len(servers))
+	for i, server := range servers {
+		weighted[i] = s.nodes[server]
+	}
+
+	s.Servers = servers
+	s.WeightedServers = weighted
+	s.len = len(servers)
+	if s.len > 0 {
+		s.currentServer = s.currentServer % s.len
+	}
+}
+
+//Select returns a rpc client
+func (s *EtcdV3ClientSelector) Select(clientCodecFunc src.ClientCodecFunc, options ...interface{}) (*rpc.Client, error) {
+	s.mu.Lock()
+	if s.len == 0 {
+		s.mu.Unlock()
+		return nil, errors.New("No available service")
+	}
+
+	var server string
+	switch s.SelectMode {
+	case src.RandomSelect:
+		s.currentServer = s.rnd.Intn(s.len)
+		server = s.Servers[s.currentServer]
+	case src.RoundRobin:
+		s.currentServer = (s.currentServer + 1) % s.len
+		server = s.Servers[s.currentServer]
+	case src.ConsistentHash:
+		if s.HashServiceAndArgs == nil {
+			s.HashServiceAndArgs = JumpConsistentHash
+		}
+		s.currentServer = s.HashServiceAndArgs(s.len, options)
+		server = s.Servers[s.currentServer]
+	case src.WeightedRoundRobin:
+		server = nextWeighted(s.WeightedServers).Server.(string)
+		s.logger().Debug("selected weighted server", "server", server)
+	default:
+		s.mu.Unlock()
+		return nil, errors.New("not supported SelectMode: " + s.SelectMode.String())
+	}
+	s.mu.Unlock()
+
+	ss := strings.Split(server, "@") //tcp@ip , tcp4@ip or tcp6@ip
+	return src.NewDirectRPCClient(s.Client, clientCodecFunc, ss[0], ss[1], s.dailTimeout)
+}
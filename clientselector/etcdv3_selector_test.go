@@ -0,0 +1,135 @@
+package clientselector
+
+import (
+	"context"
+	"math/rand"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"../rpctest"
+	"../src"
+)
+
+// startEtcdV3Selector starts a selector of /rpct/Arith in etcd, as NewEtcdV3ClientSelector
+// does with the client it creates.
+func startEtcdV3Selector(etcd *rpctest.EtcdV3Server) *EtcdV3ClientSelector {
+	s := &EtcdV3ClientSelector{
+		BasePath:       "/rpct/Arith",
+		KV:             etcd,
+		Watcher:        etcd,
+		sessionTimeout: 10 * time.Millisecond,
+		SelectMode:     src.WeightedRoundRobin,
+		rnd:            rand.New(rand.NewSource(1)),
+	}
+	s.start()
+	return s
+}
+
+func (s *EtcdV3ClientSelector) weightOf(server string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if n := s.nodes[server]; n != nil {
+		return n.Weight
+	}
+	return 0
+}
+
+func waitServers(t *testing.T, s *EtcdV3ClientSelector, want ...string) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		s.mu.RLock()
+		got := append([]string{}, s.Servers...)
+		s.mu.RUnlock()
+		if len(got) == len(want) && (len(want) == 0 || reflect.DeepEqual(got, want)) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("servers %v, want %v", got, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestEtcdV3SelectorWatch(t *testing.T) {
+	etcd := rpctest.NewEtcdV3Server()
+	defer etcd.Close()
+	etcd.AddNode("/rpct/Arith", "tcp@a:1", url.Values{"weight": {"2"}})
+	etcd.AddNode("/rpct/Arith", "tcp@b:1", nil)
+	etcd.AddNode("/rpct/Other", "tcp@x:1", nil)
+
+	s := startEtcdV3Selector(etcd)
+	defer s.Close()
+	waitServers(t, s, "tcp@a:1", "tcp@b:1")
+	if w := s.weightOf("tcp@a:1"); w != 2 {
+		t.Fatalf("weight of tcp@a:1 is %d", w)
+	}
+
+	etcd.AddNode("/rpct/Arith", "tcp@c:1", nil)
+	etcd.RemoveNode("/rpct/Arith", "tcp@b:1")
+	waitServers(t, s, "tcp@a:1", "tcp@c:1")
+
+	etcd.SetWeight("/rpct/Arith", "tcp@a:1", 5)
+	etcd.RemoveNode("/rpct/Arith", "tcp@c:1")
+	etcd.AddNode("/rpct/Arith", "tcp@c:1", url.Values{"state": {"inactive"}})
+	waitServers(t, s, "tcp@a:1")
+	if w := s.weightOf("tcp@a:1"); w != 5 {
+		t.Fatalf("weight of tcp@a:1 is %d after it changed to 5", w)
+	}
+}
+
+func TestEtcdV3SelectorResume(t *testing.T) {
+	etcd := rpctest.NewEtcdV3Server()
+	defer etcd.Close()
+	etcd.AddNode("/rpct/Arith", "tcp@a:1", nil)
+
+	s := startEtcdV3Selector(etcd)
+	defer s.Close()
+	waitServers(t, s, "tcp@a:1")
+	gets := etcd.Gets()
+
+	// the changes made while the watch is broken are caught up with from its revision
+	etcd.BreakWatches()
+	etcd.AddNode("/rpct/Arith", "tcp@b:1", nil)
+	etcd.RemoveNode("/rpct/Arith", "tcp@a:1")
+	waitServers(t, s, "tcp@b:1")
+	if etcd.Gets() != gets {
+		t.Fatal("servers pulled again although the watch could be resumed")
+	}
+
+	// unless the revision has been compacted, when the servers are pulled again
+	etcd.BreakWatches()
+	etcd.AddNode("/rpct/Arith", "tcp@c:1", nil)
+	etcd.Compact(context.Background(), etcd.Revision())
+	waitServers(t, s, "tcp@b:1", "tcp@c:1")
+	if etcd.Gets() != gets+1 {
+		t.Fatalf("servers pulled %d times after compaction", etcd.Gets()-gets)
+	}
+	etcd.AddNode("/rpct/Arith", "tcp@d:1", nil)
+	waitServers(t, s, "tcp@b:1", "tcp@c:1", "tcp@d:1")
+}
+
+func TestEtcdV3SelectorFirstPullFails(t *testing.T) {
+	etcd := rpctest.NewEtcdV3Server()
+	defer etcd.Close()
+	etcd.AddNode("/rpct/Arith", "tcp@a:1", nil)
+	etcd.RemoveNode("/rpct/Arith", "tcp@a:1")
+	etcd.AddNode("/rpct/Arith", "tcp@b:1", nil)
+	etcd.SetAvailable(false)
+
+	s := startEtcdV3Selector(etcd)
+	defer s.Close()
+	// the history is not replayed by a watch from revision 1 while the pull fails
+	time.Sleep(50 * time.Millisecond)
+	waitServers(t, s)
+	if etcd.Gets() < 2 {
+		t.Fatal("failed pull not retried")
+	}
+
+	etcd.SetAvailable(true)
+	waitServers(t, s, "tcp@b:1")
+	etcd.AddNode("/rpct/Arith", "tcp@c:1", nil)
+	waitServers(t, s, "tcp@b:1", "tcp@c:1")
+}
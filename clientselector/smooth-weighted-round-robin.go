@@ -1,7 +1,5 @@
 package clientselector
 
-import "fmt"
-
 // Weighted is a wrapped server with  weight
 type Weighted struct {
 	Server          interface{}
@@ -17,6 +15,17 @@ func (w *Weighted) fail() {
 	}
 }
 
+// failWeighted lowers the effective weight of the servers which match,
+// so that they are selected less often until they recover.
+// The caller holds the lock which guards servers, as for nextWeighted.
+func failWeighted(servers []*Weighted, match func(server interface{}) bool) {
+	for _, w := range servers {
+		if w != nil && match(w.Server) {
+			w.fail()
+		}
+	}
+}
+
 //https://github.com/phusion/nginx/commit/27e94984486058d73157038f7950a0a36ecc6e35
 func nextWeighted(servers []*Weighted) (best *Weighted) {
 	total := 0
@@ -47,7 +56,5 @@ func nextWeighted(servers []*Weighted) (best *Weighted) {
 
 	best.CurrentWeight -= total
 
-	fmt.Printf("selected weighted: %+v\n", best)
-
 	return best
 }
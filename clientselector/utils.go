@@ -3,6 +3,8 @@ package clientselector
 import (
 	"fmt"
 	"hash/fnv"
+
+	"../src"
 )
 
 // Hash consistently chooses a hash bucket number in the range [0, numBuckets) for the given key. numBuckets must be >= 1.
@@ -45,3 +47,11 @@ func JumpConsistentHash(len int, options ...interface{}) int {
 func toString(obj interface{}) string {
 	return fmt.Sprintf("%v", obj)
 }
+
+// selectorLogger returns l, or the Logger of the client c if l is nil.
+func selectorLogger(l src.Logger, c *src.Client) src.Logger {
+	if l == nil && c != nil {
+		l = c.Logger
+	}
+	return src.LoggerOr(l)
+}
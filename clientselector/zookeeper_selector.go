@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/samuel/go-zookeeper/zk"
@@ -28,6 +29,12 @@ type ZooKeeperClientSelector struct {
 	len                int
 	HashServiceAndArgs HashServiceAndArgs
 	Client             *src.Client
+	//Logger logs the errors of ZooKeeper, the Logger of Client if nil.
+	//The errors of NewZooKeeperClientSelector go to src.DefaultLogger.
+	Logger src.Logger
+
+	//mu guards the weighted servers, which HandleFailedServer lowers while calls select them
+	mu sync.Mutex
 }
 
 // NewZooKeeperClientSelector creates a ZooKeeperClientSelector
@@ -54,52 +61,85 @@ func (s *ZooKeeperClientSelector) SetSelectMode(sm src.SelectMode) {
 	s.SelectMode = sm
 }
 
+//GetSelectMode returns the SelectMode servers are selected with
+func (s *ZooKeeperClientSelector) GetSelectMode() src.SelectMode {
+	return s.SelectMode
+}
+
+func (s *ZooKeeperClientSelector) logger() src.Logger {
+	return selectorLogger(s.Logger, s.Client)
+}
+
 func (s *ZooKeeperClientSelector) AllClients(clientCodecFunc src.ClientCodecFunc) []*rpc.Client {
 	var clients []*rpc.Client
 
 	for _, sv := range s.Servers {
 		ss := strings.Split(sv, "@")
 		c, err := src.NewDirectRPCClient(s.Client, clientCodecFunc, ss[0], ss[1], s.dailTimeout)
-		if err == nil {
-			clients = append(clients, c)
+		if err != nil {
+			s.logger().Warn("connecting to server", "server", sv, "err", err)
+			continue
 		}
+		clients = append(clients, c)
 	}
 
 	return clients
 }
 
+//HandleFailedServer lowers the weight of the failed server for WeightedRoundRobin
+func (s *ZooKeeperClientSelector) HandleFailedServer(network, address string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	failWeighted(s.WeightedServers, func(server interface{}) bool {
+		return server.(string) == network+"@"+address
+	})
+}
+
 func (s *ZooKeeperClientSelector) start() {
 	c, _, err := zk.Connect(s.ZKServers, s.sessionTimeout)
 	if err != nil {
-		panic(err)
+		s.logger().Error("connecting to ZooKeeper", "servers", strings.Join(s.ZKServers, ","), "err", err)
+		return
 	}
 
 	s.zkConn = c
-	exist, _, _ := c.Exists(s.BasePath)
-	if !exist {
-		mkdirs(c, s.BasePath)
+	exist, _, err := c.Exists(s.BasePath)
+	if err != nil {
+		s.logger().Warn("checking ZooKeeper path", "path", s.BasePath, "err", err)
+	} else if !exist {
+		if err := mkdirs(c, s.BasePath); err != nil {
+			s.logger().Warn("creating ZooKeeper path", "path", s.BasePath, "err", err)
+		}
 	}
 
-	servers, _, _ := s.zkConn.Children(s.BasePath)
+	servers, _, err := s.zkConn.Children(s.BasePath)
+	if err != nil {
+		s.logger().Warn("pulling servers from ZooKeeper", "path", s.BasePath, "err", err)
+	}
 	s.Servers = servers
 
 	s.createWeighted()
 
 	s.len = len(s.Servers)
-	s.currentServer = s.currentServer % s.len
+	if s.len > 0 {
+		s.currentServer = s.currentServer % s.len
+	}
 
 	go s.watchPath()
 }
 
+// createWeighted reads the metadata of the servers, and replaces the weighted servers under mu.
 func (s *ZooKeeperClientSelector) createWeighted() {
-	s.WeightedServers = make([]*Weighted, len(s.Servers))
+	weighted := make([]*Weighted, len(s.Servers))
 
 	var inactiveServers []int
 
 	for i, ss := range s.Servers {
 		bytes, _, err := s.zkConn.Get(s.BasePath + "/" + ss)
-		s.WeightedServers[i] = &Weighted{Server: ss, Weight: 1, EffectiveWeight: 1}
-		if err == nil {
+		weighted[i] = &Weighted{Server: ss, Weight: 1, EffectiveWeight: 1}
+		if err != nil {
+			s.logger().Warn("reading server metadata from ZooKeeper", "server", ss, "err", err)
+		} else {
 			metadata := string(bytes)
 			if v, err := url.ParseQuery(metadata); err == nil {
 				w := v.Get("weight")
@@ -111,8 +151,10 @@ func (s *ZooKeeperClientSelector) createWeighted() {
 				if w != "" {
 					weight, err := strconv.Atoi(w)
 					if err != nil {
-						s.WeightedServers[i].Weight = weight
-						s.WeightedServers[i].EffectiveWeight = weight
+						s.logger().Warn("parsing server weight", "server", ss, "weight", w, "err", err)
+					} else {
+						weighted[i].Weight = weight
+						weighted[i].EffectiveWeight = weight
 					}
 				}
 			}
@@ -120,18 +162,30 @@ func (s *ZooKeeperClientSelector) createWeighted() {
 
 	}
 
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.WeightedServers = weighted
 	s.removeInactiveServers(inactiveServers)
 }
 
 func (s *ZooKeeperClientSelector) watchPath() {
-	servers, _, ch, _ := s.zkConn.ChildrenW(s.BasePath)
+	servers, _, ch, err := s.zkConn.ChildrenW(s.BasePath)
+	if err != nil {
+		// the servers known so far are kept
+		s.logger().Warn("watching servers in ZooKeeper", "path", s.BasePath, "err", err)
+		time.Sleep(s.sessionTimeout)
+		s.watchPath()
+		return
+	}
 	s.Servers = servers
 	s.len = len(servers)
 	if s.SelectMode == src.WeightedRoundRobin {
 		s.createWeighted()
 	}
 
-	s.currentServer = s.currentServer % s.len
+	if s.len > 0 {
+		s.currentServer = s.currentServer % s.len
+	}
 	// e := <-ch
 	// if e.Type == zk.EventNodeChildrenChanged {
 
@@ -140,6 +194,7 @@ func (s *ZooKeeperClientSelector) watchPath() {
 	s.watchPath()
 }
 
+// removeInactiveServers removes the servers at the indexes of inactiveServers. The caller holds mu.
 func (s *ZooKeeperClientSelector) removeInactiveServers(inactiveServers []int) {
 	i := len(inactiveServers) - 1
 
@@ -176,7 +231,10 @@ func (s *ZooKeeperClientSelector) Select(clientCodecFunc src.ClientCodecFunc, op
 		ss := strings.Split(server, "@") //
 		return src.NewDirectRPCClient(s.Client, clientCodecFunc, ss[0], ss[1], s.dailTimeout)
 	} else if s.SelectMode == src.WeightedRoundRobin {
+		s.mu.Lock()
 		server := nextWeighted(s.WeightedServers).Server.(string)
+		s.mu.Unlock()
+		s.logger().Debug("selected weighted server", "server", server)
 		ss := strings.Split(server, "@")
 		return src.NewDirectRPCClient(s.Client, clientCodecFunc, ss[0], ss[1], s.dailTimeout)
 	}
@@ -198,6 +256,9 @@ func mkdirs(conn *zk.Conn, path string) (err error) {
 
 	//check whether this path exists
 	exist, _, err := conn.Exists(path)
+	if err != nil {
+		return err
+	}
 	if exist {
 		return nil
 	}
@@ -213,7 +274,10 @@ func mkdirs(conn *zk.Conn, path string) (err error) {
 	createdPath := ""
 	for _, p := range paths {
 		createdPath = createdPath + "/" + p
-		exist, _, _ = conn.Exists(createdPath)
+		exist, _, err = conn.Exists(createdPath)
+		if err != nil {
+			return err
+		}
 		if !exist {
 			_, err = conn.Create(createdPath, []byte(""), flags, acl)
 			if err != nil {
@@ -0,0 +1,379 @@
+// Command rpct lists the services of rpct servers and calls their methods with args given as JSON.
+//
+// Usage:
+//	rpct [flags] list [Service]
+//	rpct [flags] describe [Service]
+//	rpct [flags] call Service.Method [args]
+//
+// list prints the services of a server, or the methods of one of them, and describe prints
+// their descriptors as JSON, see src.ReflectionServiceName; they need servers which called
+// Server.EnableReflection. call reads args as JSON from the command line, or from stdin if
+// they are "-" or missing, and prints the reply as JSON.
+//
+// A server is reached at -addr, or discovered with -registry, like
+//	rpct -addr 127.0.0.1:8972 call Arith.Mul '{"A":7,"B":8}'
+//	rpct -network http -addr 127.0.0.1:8972 list
+//	rpct -tls -cacert ca.pem -cert client.pem -key client-key.pem -addr 10.0.0.1:8972 list Arith
+//	rpct -registry etcd -registry-addr http://127.0.0.1:2379 -base-path /rpct/Arith call Arith.Mul '{"A":1,"B":2}'
+//	rpct -registry etcdv3 -registry-addr 127.0.0.1:2379 -base-path /rpct/Arith list Arith
+//	rpct -registry consul -registry-addr 127.0.0.1:8500 -service Arith list
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/rpc/jsonrpc"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"../../clientselector"
+	"../../src"
+	msgpackrpc "github.com/hashicorp/net-rpc-msgpackrpc"
+)
+
+// metadataFlag collects the key=value pairs of the repeated -md flag.
+type metadataFlag src.Metadata
+
+func (f metadataFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for k, v := range f {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f metadataFlag) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return errors.New("metadata must be key=value")
+	}
+	f[s[:i]] = s[i+1:]
+	return nil
+}
+
+var (
+	network = flag.String("network", "tcp", "network of -addr: tcp, tcp4, tcp6, unix, or http for servers served with ServeByHTTP")
+	addr    = flag.String("addr", "", "address of the server")
+	codec   = flag.String("codec", "msgpack", "codec of the server: msgpack or jsonrpc")
+	timeout = flag.Duration("timeout", 10*time.Second, "timeout of dialing and of the call")
+
+	useTLS     = flag.Bool("tls", false, "connect with TLS")
+	caCert     = flag.String("cacert", "", "PEM file of the CAs which verify the server, the system ones if empty")
+	certFile   = flag.String("cert", "", "PEM file of the client certificate, for mutual TLS")
+	keyFile    = flag.String("key", "", "PEM file of the key of -cert")
+	serverName = flag.String("servername", "", "name the certificate of the server is verified against, the host of -addr if empty")
+	insecure   = flag.Bool("insecure", false, "do not verify the certificate of the server")
+
+	auth = flag.String("auth", "", "authorization sent with every call, see AuthorizationClientPlugin; a JWT is sent as \"Bearer <token>\"")
+	tag  = flag.String("tag", "", "authorization tag sent with every call")
+
+	registry     = flag.String("registry", "", "discover servers with etcd, etcdv3, zookeeper or consul instead of -addr")
+	registryAddr = flag.String("registry-addr", "", "comma separated addresses of the registry")
+	basePath     = flag.String("base-path", "", "path of the service in etcd or ZooKeeper, like /rpct/Arith")
+	service      = flag.String("service", "", "name of the service in Consul")
+	selectMode   = flag.String("select", "RandomSelect", "SelectMode of discovered servers")
+
+	md = metadataFlag{}
+)
+
+func main() {
+	flag.Var(md, "md", "key=value metadata sent with the call, may be repeated")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] list [Service] | describe [Service] | call Service.Method [args]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(flag.Arg(0), flag.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "rpct:", err)
+		os.Exit(1)
+	}
+}
+
+func run(cmd string, args []string) error {
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	switch cmd {
+	case "list":
+		return list(client, args)
+	case "describe":
+		return describe(client, args)
+	case "call":
+		if len(args) < 1 {
+			return errors.New("call needs Service.Method")
+		}
+		return call(client, args[0], args[1:])
+	}
+	return errors.New("unknown command " + cmd)
+}
+
+func newClient() (*src.Client, error) {
+	selector, err := newSelector()
+	if err != nil {
+		return nil, err
+	}
+	client := src.NewClient(selector)
+
+	switch *codec {
+	case "msgpack":
+		client.ClientCodecFunc = msgpackrpc.NewClientCodec
+	case "jsonrpc":
+		client.ClientCodecFunc = jsonrpc.NewClientCodec
+	default:
+		return nil, errors.New("unknown codec " + *codec)
+	}
+
+	if *useTLS {
+		if client.TLSConfig, err = newTLSConfig(); err != nil {
+			return nil, err
+		}
+	}
+	if *auth != "" || *tag != "" {
+		if err := client.Auth(*auth, *tag); err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+func newSelector() (src.ClientSelector, error) {
+	if *registry == "" {
+		if *addr == "" {
+			return nil, errors.New("-addr or -registry is required")
+		}
+		return &src.DirectClientSelector{Network: *network, Address: *addr, DialTimeout: *timeout}, nil
+	}
+
+	sm := src.SelectMode(-1)
+	for m := src.RandomSelect; m <= src.ConsistentHash; m++ {
+		if strings.EqualFold(m.String(), *selectMode) {
+			sm = m
+		}
+	}
+	if sm < 0 {
+		return nil, errors.New("unknown SelectMode " + *selectMode)
+	}
+	if *registryAddr == "" {
+		return nil, errors.New("-registry-addr is required with -registry")
+	}
+	addrs := strings.Split(*registryAddr, ",")
+
+	switch *registry {
+	case "etcd":
+		if *basePath == "" {
+			return nil, errors.New("-base-path is required with etcd")
+		}
+		return clientselector.NewEtcdClientSelector(addrs, *basePath, *timeout, sm, *timeout), nil
+	case "etcdv3":
+		if *basePath == "" {
+			return nil, errors.New("-base-path is required with etcdv3")
+		}
+		return clientselector.NewEtcdV3ClientSelector(addrs, *basePath, *timeout, sm, *timeout), nil
+	case "zookeeper":
+		if *basePath == "" {
+			return nil, errors.New("-base-path is required with zookeeper")
+		}
+		return clientselector.NewZooKeeperClientSelector(addrs, *basePath, *timeout, sm, *timeout), nil
+	case "consul":
+		if *service == "" {
+			return nil, errors.New("-service is required with consul")
+		}
+		return clientselector.NewConsulClientSelector(addrs[0], *service, *timeout, sm, *timeout), nil
+	}
+	return nil, errors.New("unknown registry " + *registry)
+}
+
+func newTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{}
+	if *certFile != "" {
+		var err error
+		if config, err = src.NewMTLSClientConfig(*certFile, *keyFile, *caCert); err != nil {
+			return nil, err
+		}
+	} else if *caCert != "" {
+		pem, err := ioutil.ReadFile(*caCert)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates in " + *caCert)
+		}
+	}
+	config.ServerName = *serverName
+	config.InsecureSkipVerify = *insecure
+	return config, nil
+}
+
+// callContext returns the context of a call, with its timeout and metadata.
+func callContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	if len(md) > 0 {
+		ctx = src.NewOutgoingContext(ctx, src.Metadata(md))
+	}
+	return ctx, cancel
+}
+
+func describeServices(client *src.Client, name string) (*src.ServiceDescriptors, error) {
+	ctx, cancel := callContext()
+	defer cancel()
+	var reply src.ServiceDescriptors
+	err := client.CallContext(ctx, src.ReflectionServiceName+".Describe", &src.ReflectionArgs{Service: name}, &reply)
+	return &reply, err
+}
+
+func list(client *src.Client, args []string) error {
+	if len(args) == 0 {
+		ctx, cancel := callContext()
+		defer cancel()
+		var reply src.ServiceList
+		if err := client.CallContext(ctx, src.ReflectionServiceName+".ListServices", &src.ReflectionArgs{}, &reply); err != nil {
+			return err
+		}
+		for _, name := range reply.Services {
+			fmt.Println(name)
+		}
+		return nil
+	}
+
+	reply, err := describeServices(client, args[0])
+	if err != nil {
+		return err
+	}
+	for _, svc := range reply.Services {
+		for _, m := range svc.Methods {
+			arg, result := m.ArgType, m.ReplyType
+			if m.Stream == "client" || m.Stream == "bidi" {
+				arg = "stream"
+			}
+			if m.Stream == "server" || m.Stream == "bidi" {
+				result = "stream"
+			}
+			fmt.Printf("%s.%s(%s) %s\n", svc.Name, m.Name, arg, result)
+		}
+	}
+	return nil
+}
+
+func describe(client *src.Client, args []string) error {
+	name := ""
+	if len(args) > 0 {
+		name = args[0]
+	}
+	reply, err := describeServices(client, name)
+	if err != nil {
+		return err
+	}
+	return printJSON(reply.Services)
+}
+
+func call(client *src.Client, serviceMethod string, args []string) error {
+	var data []byte
+	var err error
+	if len(args) == 0 || args[0] == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data = []byte(args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	callArgs, err := parseArgs(data)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := callContext()
+	defer cancel()
+	var reply interface{}
+	if err := client.CallContext(ctx, serviceMethod, callArgs, &reply); err != nil {
+		return err
+	}
+	return printJSON(jsonValue(reply))
+}
+
+// parseArgs decodes JSON args, keeping integers integers so that servers can decode them into int fields.
+func parseArgs(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		if err == io.EOF {
+			return map[string]interface{}{}, nil
+		}
+		return nil, errors.New("args are not JSON: " + err.Error())
+	}
+	return numbers(v), nil
+}
+
+func numbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = numbers(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = numbers(e)
+		}
+	}
+	return v
+}
+
+// jsonValue turns what msgpack decodes, like maps with interface{} keys and strings as bytes, into values JSON can encode.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(jsonValue(k))] = jsonValue(e)
+		}
+		return m
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = jsonValue(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = jsonValue(e)
+		}
+	case []byte:
+		return string(v)
+	}
+	return v
+}
+
+func printJSON(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
@@ -0,0 +1,412 @@
+// Command rpctgen generates typed clients, registration helpers and mocks of services.
+//
+// For a service type like
+//	type Arith int
+//	func (t *Arith) Mul(args *Args, reply *Reply) error
+// the directive
+//	//go:generate rpctgen -type Arith
+// writes arith_rpct.go next to it, which declares
+//	ArithService, the interface of the methods of Arith
+//	RegisterArith(s *src.Server, svc ArithService, metadata ...string)
+//	ArithCaller, the interface of the typed calls, like Mul(ctx context.Context, args *Args) (*Reply, error)
+//	ArithClient, which makes the calls with a *src.Client
+//	ArithMock, which makes them with a func per method and records them, for tests
+//
+// Methods which take a context.Context first are supported, streaming methods are skipped.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	typeNames = flag.String("type", "", "comma separated names of the service types")
+	output    = flag.String("output", "", "output file, <type>_rpct.go in the directory of the package if empty")
+	srcPath   = flag.String("src", "", "import path of the rpct src package, the one the package imports if empty")
+)
+
+// method is a service method.
+type method struct {
+	Name        string
+	WithContext bool
+	ArgType     string
+	ReplyType   string // without its pointer
+}
+
+// service is a service type and its methods.
+type service struct {
+	Name    string
+	Methods []method
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s -type T[,T...] [-output file] [-src import path] [dir]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *typeNames == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+
+	if err := generate(dir, strings.Split(*typeNames, ",")); err != nil {
+		fmt.Fprintln(os.Stderr, "rpctgen:", err)
+		os.Exit(1)
+	}
+}
+
+func generate(dir string, names []string) error {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go") && !strings.HasSuffix(fi.Name(), "_rpct.go")
+	}, 0)
+	if err != nil {
+		return err
+	}
+	if len(pkgs) != 1 {
+		return fmt.Errorf("%d packages in %s", len(pkgs), dir)
+	}
+	var pkg *ast.Package
+	for _, p := range pkgs {
+		pkg = p
+	}
+
+	g := &generator{fset: fset, imports: make(map[string]string)}
+	for _, name := range names {
+		svc, err := g.service(pkg, strings.TrimSpace(name))
+		if err != nil {
+			return err
+		}
+		g.services = append(g.services, svc)
+	}
+
+	if *srcPath != "" {
+		g.imports["src"] = *srcPath
+	} else if g.imports["src"] == "" {
+		return errors.New("cannot find the import of the src package, set -src")
+	}
+
+	code, err := g.generate(pkg.Name)
+	if err != nil {
+		return err
+	}
+	out := *output
+	if out == "" {
+		out = filepath.Join(dir, strings.ToLower(names[0])+"_rpct.go")
+	}
+	return ioutil.WriteFile(out, code, 0644)
+}
+
+type generator struct {
+	fset     *token.FileSet
+	services []*service
+	//imports are the paths of the packages the generated code refers to, by name
+	imports map[string]string
+}
+
+// service finds the methods of the type name in pkg.
+func (g *generator) service(pkg *ast.Package, name string) (*service, error) {
+	svc := &service{Name: name}
+	found := false
+	files := make([]string, 0, len(pkg.Files))
+	for path := range pkg.Files {
+		files = append(files, path)
+	}
+	sort.Strings(files)
+
+	for _, path := range files {
+		file := pkg.Files[path]
+		for _, imp := range file.Imports {
+			p, _ := strconv.Unquote(imp.Path.Value)
+			n := filepath.Base(p)
+			if imp.Name != nil {
+				n = imp.Name.Name
+			}
+			if n == "src" && g.imports["src"] == "" {
+				g.imports["src"] = p
+			}
+		}
+
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					if ts, ok := spec.(*ast.TypeSpec); ok && ts.Name.Name == name {
+						found = true
+					}
+				}
+			case *ast.FuncDecl:
+				if decl.Recv == nil || len(decl.Recv.List) != 1 || receiverName(decl.Recv.List[0].Type) != name {
+					continue
+				}
+				if m, ok := g.method(file, decl); ok {
+					svc.Methods = append(svc.Methods, m)
+				}
+			}
+		}
+	}
+
+	if !found {
+		return nil, errors.New("no type " + name + " in package " + pkg.Name)
+	}
+	if len(svc.Methods) == 0 {
+		return nil, errors.New("type " + name + " has no methods which can be called")
+	}
+	sort.Slice(svc.Methods, func(i, j int) bool { return svc.Methods[i].Name < svc.Methods[j].Name })
+	return svc, nil
+}
+
+func receiverName(expr ast.Expr) string {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if id, ok := expr.(*ast.Ident); ok {
+		return id.Name
+	}
+	return ""
+}
+
+// method returns the method of decl if it looks like
+//	func (t *T) M([ctx context.Context,] args A, reply *R) error
+func (g *generator) method(file *ast.File, decl *ast.FuncDecl) (method, bool) {
+	m := method{Name: decl.Name.Name}
+	if !decl.Name.IsExported() {
+		return m, false
+	}
+	results := decl.Type.Results
+	if results == nil || len(results.List) != 1 || len(results.List[0].Names) > 1 {
+		return m, false
+	}
+	if id, ok := results.List[0].Type.(*ast.Ident); !ok || id.Name != "error" {
+		return m, false
+	}
+
+	// params grouped like (args, reply *T) are split
+	var params []ast.Expr
+	for _, field := range decl.Type.Params.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			params = append(params, field.Type)
+		}
+	}
+	if len(params) == 3 && g.expr(params[0]) == "context.Context" {
+		m.WithContext = true
+		params = params[1:]
+	}
+	if len(params) != 2 {
+		return m, false
+	}
+	reply, ok := params[1].(*ast.StarExpr)
+	if !ok || isStream(params[0]) || isStream(params[1]) {
+		return m, false
+	}
+
+	m.ArgType = g.expr(params[0])
+	m.ReplyType = g.expr(reply.X)
+	g.addImports(file, params[0])
+	g.addImports(file, reply.X)
+	return m, true
+}
+
+func isStream(expr ast.Expr) bool {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		if sel, ok := star.X.(*ast.SelectorExpr); ok && sel.Sel.Name == "Stream" {
+			return true
+		}
+	}
+	return false
+}
+
+// expr returns the source of expr.
+func (g *generator) expr(expr ast.Expr) string {
+	var b bytes.Buffer
+	format.Node(&b, g.fset, expr)
+	return b.String()
+}
+
+// addImports records the imports of file which expr refers to.
+func (g *generator) addImports(file *ast.File, expr ast.Expr) {
+	ast.Inspect(expr, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		id, ok := sel.X.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		for _, imp := range file.Imports {
+			p, _ := strconv.Unquote(imp.Path.Value)
+			name := filepath.Base(p)
+			if imp.Name != nil {
+				name = imp.Name.Name
+			}
+			if name == id.Name {
+				g.imports[name] = p
+			}
+		}
+		return false
+	})
+}
+
+func (g *generator) generate(pkgName string) ([]byte, error) {
+	var b bytes.Buffer
+	p := func(format string, args ...interface{}) { fmt.Fprintf(&b, format+"\n", args...) }
+
+	names := make([]string, 0, len(g.services))
+	for _, svc := range g.services {
+		names = append(names, svc.Name)
+	}
+	p("// Code generated by rpctgen -type %s; DO NOT EDIT.", strings.Join(names, ","))
+	p("")
+	p("package %s", pkgName)
+	p("")
+	p("import (")
+	var std, others []string
+	for name, path := range g.imports {
+		if path == "context" || path == "sync" {
+			continue
+		}
+		imp := strconv.Quote(path)
+		if filepath.Base(path) != name {
+			imp = name + " " + imp
+		}
+		if first := strings.Split(path, "/")[0]; strings.Contains(first, ".") {
+			others = append(others, imp)
+		} else {
+			std = append(std, imp)
+		}
+	}
+	std = append(std, strconv.Quote("context"), strconv.Quote("sync"))
+	sort.Strings(std)
+	sort.Strings(others)
+	for _, imp := range std {
+		p("\t%s", imp)
+	}
+	p("")
+	for _, imp := range others {
+		p("\t%s", imp)
+	}
+	p(")")
+
+	for _, svc := range g.services {
+		g.generateService(p, svc)
+	}
+
+	code, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %v\n%s", err, b.Bytes())
+	}
+	return code, nil
+}
+
+func (g *generator) generateService(p func(string, ...interface{}), svc *service) {
+	n := svc.Name
+
+	p("")
+	p("// %sServiceName is the name which Register%s publishes %s under.", n, n, n)
+	p("const %sServiceName = %q", n, n)
+
+	p("")
+	p("// %sService is the interface of the methods of %s.", n, n)
+	p("type %sService interface {", n)
+	for _, m := range svc.Methods {
+		ctx := ""
+		if m.WithContext {
+			ctx = "ctx context.Context, "
+		}
+		p("\t%s(%sargs %s, reply *%s) error", m.Name, ctx, m.ArgType, m.ReplyType)
+	}
+	p("}")
+
+	p("")
+	p("// Register%s publishes svc in s as %sServiceName.", n, n)
+	p("func Register%s(s *src.Server, svc %sService, metadata ...string) {", n, n)
+	p("\ts.RegisterName(%sServiceName, svc, metadata...)", n)
+	p("}")
+
+	p("")
+	p("// %sCaller calls the methods of %s. It is implemented by %sClient and %sMock.", n, n, n, n)
+	p("type %sCaller interface {", n)
+	for _, m := range svc.Methods {
+		p("\t%s(ctx context.Context, args %s) (*%s, error)", m.Name, m.ArgType, m.ReplyType)
+	}
+	p("}")
+
+	p("")
+	p("// %sClient calls %s with a *src.Client.", n, n)
+	p("type %sClient struct {", n)
+	p("\tClient *src.Client")
+	p("\t//ServiceName is the name %s is published under, %sServiceName by default", n, n)
+	p("\tServiceName string")
+	p("}")
+	p("")
+	p("// New%sClient returns the %sClient of c.", n, n)
+	p("func New%sClient(c *src.Client) *%sClient {", n, n)
+	p("\treturn &%sClient{Client: c, ServiceName: %sServiceName}", n, n)
+	p("}")
+	for _, m := range svc.Methods {
+		p("")
+		p("// %s calls %s.%s.", m.Name, n, m.Name)
+		p("func (c *%sClient) %s(ctx context.Context, args %s) (*%s, error) {", n, m.Name, m.ArgType, m.ReplyType)
+		p("\treply := new(%s)", m.ReplyType)
+		p("\tif err := c.Client.CallContext(ctx, c.ServiceName+%q, args, reply); err != nil {", "."+m.Name)
+		p("\t\treturn nil, err")
+		p("\t}")
+		p("\treturn reply, nil")
+		p("}")
+	}
+
+	p("")
+	p("// %sMock implements %sCaller for tests. Its methods call the func of the same name,", n, n)
+	p("// or return a zero reply if it is nil, and record the args they are called with.")
+	p("type %sMock struct {", n)
+	for _, m := range svc.Methods {
+		p("\t%sFunc func(ctx context.Context, args %s) (*%s, error)", m.Name, m.ArgType, m.ReplyType)
+	}
+	p("")
+	p("\tmu sync.Mutex")
+	for _, m := range svc.Methods {
+		p("\t%sCalls []%s", m.Name, m.ArgType)
+	}
+	p("}")
+	for _, m := range svc.Methods {
+		p("")
+		p("// %s records args and calls %sFunc.", m.Name, m.Name)
+		p("func (m *%sMock) %s(ctx context.Context, args %s) (*%s, error) {", n, m.Name, m.ArgType, m.ReplyType)
+		p("\tm.mu.Lock()")
+		p("\tm.%sCalls = append(m.%sCalls, args)", m.Name, m.Name)
+		p("\tm.mu.Unlock()")
+		p("\tif m.%sFunc == nil {", m.Name)
+		p("\t\treturn new(%s), nil", m.ReplyType)
+		p("\t}")
+		p("\treturn m.%sFunc(ctx, args)", m.Name)
+		p("}")
+	}
+	p("")
+	p("var (")
+	p("\t_ %sCaller = (*%sClient)(nil)", n, n)
+	p("\t_ %sCaller = (*%sMock)(nil)", n, n)
+	p(")")
+}
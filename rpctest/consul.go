@@ -0,0 +1,450 @@
+package rpctest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConsulServer is a fake of the agent of Consul, which ConsulClientSelector uses.
+// It registers and deregisters services, updates their TTL checks, and answers
+// the services of the agent and the health of a service, blocking on an index like Consul.
+type ConsulServer struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	services map[string]*consulService
+	checks   map[string]*consulCheck
+	index    uint64
+	//changed is closed, and replaced, whenever a service or a check changes
+	changed chan struct{}
+	closed  bool
+}
+
+type consulService struct {
+	ID      string
+	Service string
+	Tags    []string
+	Address string
+	Port    int
+	Meta    map[string]string
+}
+
+type consulCheck struct {
+	Node        string
+	CheckID     string
+	Name        string
+	Status      string
+	Output      string
+	ServiceID   string
+	ServiceName string
+}
+
+type consulServiceEntry struct {
+	Node    consulNode
+	Service *consulService
+	Checks  []*consulCheck
+}
+
+type consulNode struct {
+	Node    string
+	Address string
+}
+
+// consulRegistration is what the agent registers services from.
+type consulRegistration struct {
+	ID      string
+	Name    string
+	Tags    []string
+	Address string
+	Port    int
+	Meta    map[string]string
+	Check   *consulCheckRegistration
+	Checks  []*consulCheckRegistration
+}
+
+type consulCheckRegistration struct {
+	CheckID string
+	Name    string
+	Status  string
+	TTL     string
+}
+
+const (
+	consulNodeName      = "rpctest"
+	consulPassing       = "passing"
+	consulWarning       = "warning"
+	consulCritical      = "critical"
+	consulMaxWait       = 10 * time.Minute
+	consulDefaultWait   = 5 * time.Minute
+	consulServicePrefix = "service:"
+)
+
+// NewConsulServer starts a ConsulServer. It panics if it fails to listen, like httptest.NewServer.
+func NewConsulServer() *ConsulServer {
+	c := &ConsulServer{
+		services: make(map[string]*consulService),
+		checks:   make(map[string]*consulCheck),
+		index:    1,
+		changed:  make(chan struct{}),
+	}
+	c.server = httptest.NewServer(http.HandlerFunc(c.serveHTTP))
+	return c
+}
+
+// Address returns the address of c, for the Consul client.
+func (c *ConsulServer) Address() string {
+	return c.server.Listener.Addr().String()
+}
+
+// Close stops c, ending the queries which are blocking.
+func (c *ConsulServer) Close() error {
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.changed)
+	}
+	c.mu.Unlock()
+	c.server.Close()
+	return nil
+}
+
+// nodeID is the ID which service is registered under for server.
+// ConsulClientSelector finds the servers of a service by the prefix of their IDs.
+func nodeID(service, server string) string {
+	return service + "-" + server
+}
+
+// AddNode registers service with the address server, and the encoded metadata as its tag.
+func (c *ConsulServer) AddNode(service, server string, metadata url.Values) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := nodeID(service, server)
+	if c.services[id] != nil {
+		return ErrNodeExists
+	}
+	c.register(&consulRegistration{ID: id, Name: service, Address: server, Tags: []string{metadata.Encode()}})
+	return nil
+}
+
+// RemoveNode deregisters server from service.
+func (c *ConsulServer) RemoveNode(service, server string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.deregister(nodeID(service, server)) {
+		return ErrNoNode
+	}
+	return nil
+}
+
+// SetWeight updates the weight in the first tag of server.
+func (c *ConsulServer) SetWeight(service, server string, weight int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.services[nodeID(service, server)]
+	if s == nil {
+		return ErrNoNode
+	}
+	tags := append([]string(nil), s.Tags...)
+	if len(tags) == 0 {
+		tags = []string{""}
+	}
+	tags[0] = withWeight(tags[0], weight)
+	s.Tags = tags
+	c.notify()
+	return nil
+}
+
+// SetStatus sets the status of the checks of server to passing, warning or critical.
+func (c *ConsulServer) SetStatus(service, server, status string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := nodeID(service, server)
+	if c.services[id] == nil {
+		return ErrNoNode
+	}
+	checkID := consulServicePrefix + id
+	if c.checks[checkID] == nil {
+		c.checks[checkID] = &consulCheck{Node: consulNodeName, CheckID: checkID, Name: "Service '" + service + "' check", ServiceID: id, ServiceName: service}
+	}
+	for _, check := range c.checks {
+		if check.ServiceID == id {
+			check.Status = status
+		}
+	}
+	c.notify()
+	return nil
+}
+
+// notify wakes up the blocking queries. The caller holds mu.
+func (c *ConsulServer) notify() {
+	c.index++
+	if !c.closed {
+		close(c.changed)
+		c.changed = make(chan struct{})
+	}
+}
+
+// register registers a service and its checks. The caller holds mu.
+func (c *ConsulServer) register(r *consulRegistration) {
+	if r.ID == "" {
+		r.ID = r.Name
+	}
+	c.deregisterChecks(r.ID)
+	c.services[r.ID] = &consulService{ID: r.ID, Service: r.Name, Tags: r.Tags, Address: r.Address, Port: r.Port, Meta: r.Meta}
+
+	checks := r.Checks
+	if r.Check != nil {
+		checks = append([]*consulCheckRegistration{r.Check}, checks...)
+	}
+	for i, cr := range checks {
+		id := cr.CheckID
+		if id == "" {
+			id = consulServicePrefix + r.ID
+			if len(checks) > 1 {
+				id += ":" + strconv.Itoa(i+1)
+			}
+		}
+		status := cr.Status
+		if status == "" {
+			status = consulCritical
+		}
+		name := cr.Name
+		if name == "" {
+			name = "Service '" + r.Name + "' check"
+		}
+		c.checks[id] = &consulCheck{Node: consulNodeName, CheckID: id, Name: name, Status: status, ServiceID: r.ID, ServiceName: r.Name}
+	}
+	c.notify()
+}
+
+// deregister deregisters a service and its checks. The caller holds mu.
+func (c *ConsulServer) deregister(id string) bool {
+	if c.services[id] == nil {
+		return false
+	}
+	delete(c.services, id)
+	c.deregisterChecks(id)
+	c.notify()
+	return true
+}
+
+func (c *ConsulServer) deregisterChecks(serviceID string) {
+	for id, check := range c.checks {
+		if check.ServiceID == serviceID {
+			delete(c.checks, id)
+		}
+	}
+}
+
+func (c *ConsulServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	switch {
+	case r.Method == "GET" && path == "/v1/agent/services":
+		c.query(w, r, func() interface{} {
+			services := make(map[string]*consulService, len(c.services))
+			for id, s := range c.services {
+				service := *s
+				services[id] = &service
+			}
+			return services
+		})
+
+	case r.Method == "GET" && strings.HasPrefix(path, "/v1/agent/service/"):
+		id := strings.TrimPrefix(path, "/v1/agent/service/")
+		c.mu.Lock()
+		var service *consulService
+		if s := c.services[id]; s != nil {
+			copied := *s
+			service = &copied
+		}
+		index := c.index
+		c.mu.Unlock()
+		if service == nil {
+			http.Error(w, "unknown service ID: "+id, http.StatusNotFound)
+			return
+		}
+		c.write(w, index, service)
+
+	case r.Method == "GET" && strings.HasPrefix(path, "/v1/health/service/"):
+		name := strings.TrimPrefix(path, "/v1/health/service/")
+		q := r.URL.Query()
+		_, passing := q["passing"]
+		tag := q.Get("tag")
+		c.query(w, r, func() interface{} {
+			return c.health(name, tag, passing)
+		})
+
+	case r.Method == "PUT" && path == "/v1/agent/service/register":
+		var reg consulRegistration
+		if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+			http.Error(w, "request decode failed: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if reg.Name == "" {
+			http.Error(w, "missing service name", http.StatusBadRequest)
+			return
+		}
+		c.mu.Lock()
+		c.register(&reg)
+		index := c.index
+		c.mu.Unlock()
+		c.write(w, index, nil)
+
+	case r.Method == "PUT" && strings.HasPrefix(path, "/v1/agent/service/deregister/"):
+		c.mu.Lock()
+		found := c.deregister(strings.TrimPrefix(path, "/v1/agent/service/deregister/"))
+		index := c.index
+		c.mu.Unlock()
+		if !found {
+			http.Error(w, "unknown service ID", http.StatusNotFound)
+			return
+		}
+		c.write(w, index, nil)
+
+	case r.Method == "PUT" && strings.HasPrefix(path, "/v1/agent/check/"):
+		c.updateCheck(w, r, strings.TrimPrefix(path, "/v1/agent/check/"))
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// updateCheck serves pass/<id>, warn/<id>, fail/<id> and update/<id>.
+func (c *ConsulServer) updateCheck(w http.ResponseWriter, r *http.Request, path string) {
+	i := strings.Index(path, "/")
+	if i < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	action, id := path[:i], path[i+1:]
+
+	status, output := "", r.URL.Query().Get("note")
+	switch action {
+	case "pass":
+		status = consulPassing
+	case "warn":
+		status = consulWarning
+	case "fail":
+		status = consulCritical
+	case "update":
+		var update struct{ Status, Output string }
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, "request decode failed: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		status, output = update.Status, update.Output
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if status != consulPassing && status != consulWarning && status != consulCritical {
+		http.Error(w, "invalid check status: "+status, http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	check := c.checks[id]
+	if check != nil {
+		check.Status, check.Output = status, output
+		c.notify()
+	}
+	index := c.index
+	c.mu.Unlock()
+	if check == nil {
+		http.Error(w, "unknown check ID: "+id, http.StatusNotFound)
+		return
+	}
+	c.write(w, index, nil)
+}
+
+// health returns the instances of the service name, those with tag if it is not empty,
+// and those whose checks all pass if passing. The caller holds mu.
+func (c *ConsulServer) health(name, tag string, passing bool) []*consulServiceEntry {
+	entries := []*consulServiceEntry{}
+	for id, s := range c.services {
+		if s.Service != name || (tag != "" && !hasTag(s.Tags, tag)) {
+			continue
+		}
+		service := *s
+		e := &consulServiceEntry{Node: consulNode{Node: consulNodeName, Address: "127.0.0.1"}, Service: &service}
+		healthy := true
+		for _, check := range c.checks {
+			if check.ServiceID == id {
+				checkCopy := *check
+				e.Checks = append(e.Checks, &checkCopy)
+				healthy = healthy && check.Status == consulPassing
+			}
+		}
+		sort.Slice(e.Checks, func(i, j int) bool { return e.Checks[i].CheckID < e.Checks[j].CheckID })
+		if !passing || healthy {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Service.ID < entries[j].Service.ID })
+	return entries
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// query answers with result, after the index is past the index of r if r is a blocking query.
+func (c *ConsulServer) query(w http.ResponseWriter, r *http.Request, result func() interface{}) {
+	q := r.URL.Query()
+	index, _ := strconv.ParseUint(q.Get("index"), 10, 64)
+	wait := consulDefaultWait
+	if s := q.Get("wait"); s != "" {
+		if d, err := time.ParseDuration(s); err == nil {
+			wait = d
+		}
+	}
+	if wait > consulMaxWait {
+		wait = consulMaxWait
+	}
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+
+	for {
+		c.mu.Lock()
+		if index == 0 || c.index > index || c.closed {
+			// the index is taken with the result, so that it is never newer than the result
+			v, index := result(), c.index
+			c.mu.Unlock()
+			c.write(w, index, v)
+			return
+		}
+		changed := c.changed
+		c.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-timeout.C:
+			index = 0
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// write writes v as the response, with index as X-Consul-Index.
+func (c *ConsulServer) write(w http.ResponseWriter, index uint64, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+	w.Header().Set("X-Consul-KnownLeader", "true")
+	w.Header().Set("X-Consul-LastContact", "0")
+	if v != nil {
+		json.NewEncoder(w).Encode(v)
+	}
+}
@@ -0,0 +1,426 @@
+package rpctest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EtcdServer is a fake of the keys API of etcd v2, which EtcdClientSelector uses.
+// It gets, sets and deletes keys, expires those set with a TTL, and watches them
+// from any index of its history.
+type EtcdServer struct {
+	server *httptest.Server
+
+	mu     sync.Mutex
+	nodes  map[string]*etcdNode
+	index  uint64
+	events []*etcdEvent
+	//changed is closed, and replaced, whenever an event happens
+	changed chan struct{}
+	closed  bool
+}
+
+type etcdNode struct {
+	Key           string      `json:"key"`
+	Dir           bool        `json:"dir,omitempty"`
+	Value         string      `json:"value,omitempty"`
+	Nodes         []*etcdNode `json:"nodes,omitempty"`
+	CreatedIndex  uint64      `json:"createdIndex"`
+	ModifiedIndex uint64      `json:"modifiedIndex"`
+	Expiration    *time.Time  `json:"expiration,omitempty"`
+	TTL           int64       `json:"ttl,omitempty"`
+
+	expire *time.Timer
+}
+
+type etcdEvent struct {
+	Action   string    `json:"action"`
+	Node     *etcdNode `json:"node"`
+	PrevNode *etcdNode `json:"prevNode,omitempty"`
+}
+
+// etcdError is an error of the keys API, with its HTTP status.
+type etcdError struct {
+	Code    int    `json:"errorCode"`
+	Message string `json:"message"`
+	Cause   string `json:"cause"`
+	Index   uint64 `json:"index"`
+
+	status int
+}
+
+const (
+	etcdKeyNotFound  = 100
+	etcdNotFile      = 102
+	etcdNodeExist    = 105
+	etcdDirNotEmpty  = 108
+	etcdInvalidField = 209
+)
+
+// NewEtcdServer starts an EtcdServer. It panics if it fails to listen, like httptest.NewServer.
+func NewEtcdServer() *EtcdServer {
+	e := &EtcdServer{
+		nodes:   map[string]*etcdNode{"/": {Key: "/", Dir: true}},
+		changed: make(chan struct{}),
+	}
+	e.server = httptest.NewServer(http.HandlerFunc(e.serveHTTP))
+	return e
+}
+
+// Endpoints returns the endpoints of e, for the etcd client.
+func (e *EtcdServer) Endpoints() []string {
+	return []string{e.server.URL}
+}
+
+// Close stops e, ending the watches which are waiting.
+func (e *EtcdServer) Close() error {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.changed)
+		for _, n := range e.nodes {
+			if n.expire != nil {
+				n.expire.Stop()
+			}
+		}
+	}
+	e.mu.Unlock()
+	e.server.Close()
+	return nil
+}
+
+// AddNode sets the key service/server to the encoded metadata.
+func (e *EtcdServer) AddNode(service, server string, metadata url.Values) error {
+	key := cleanEtcdKey(service + "/" + server)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.nodes[key] != nil {
+		return ErrNodeExists
+	}
+	_, err := e.set(key, metadata.Encode(), false, 0, "set")
+	return asRegistryError(err)
+}
+
+// RemoveNode deletes the key service/server.
+func (e *EtcdServer) RemoveNode(service, server string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := e.delete(cleanEtcdKey(service+"/"+server), false, false)
+	return asRegistryError(err)
+}
+
+// SetWeight updates the weight in the value of the key service/server.
+func (e *EtcdServer) SetWeight(service, server string, weight int) error {
+	key := cleanEtcdKey(service + "/" + server)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	n := e.nodes[key]
+	if n == nil || n.Dir {
+		return ErrNoNode
+	}
+	_, err := e.set(key, withWeight(n.Value, weight), false, 0, "set")
+	return asRegistryError(err)
+}
+
+func asRegistryError(err *etcdError) error {
+	switch {
+	case err == nil:
+		return nil
+	case err.Code == etcdKeyNotFound:
+		return ErrNoNode
+	case err.Code == etcdNodeExist:
+		return ErrNodeExists
+	}
+	return err
+}
+
+func (err *etcdError) Error() string {
+	return strconv.Itoa(err.Code) + ": " + err.Message + " (" + err.Cause + ")"
+}
+
+func (e *EtcdServer) newError(code int, message, cause string, status int) *etcdError {
+	return &etcdError{Code: code, Message: message, Cause: cause, Index: e.index, status: status}
+}
+
+func cleanEtcdKey(key string) string {
+	parts := strings.FieldsFunc(key, func(r rune) bool { return r == '/' })
+	return "/" + strings.Join(parts, "/")
+}
+
+func parentEtcdKey(key string) string {
+	i := strings.LastIndex(key, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return key[:i]
+}
+
+func (e *EtcdServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, "/v2/keys") {
+		http.NotFound(w, r)
+		return
+	}
+	key := cleanEtcdKey(strings.TrimPrefix(r.URL.Path, "/v2/keys"))
+	q := r.URL.Query()
+
+	if r.Method == "GET" && q.Get("wait") == "true" {
+		e.watch(w, r, key)
+		return
+	}
+
+	e.mu.Lock()
+	var ev *etcdEvent
+	var err *etcdError
+	status := http.StatusOK
+	switch r.Method {
+	case "GET":
+		ev, err = e.get(key, q.Get("recursive") == "true")
+	case "PUT":
+		r.ParseForm()
+		ev, err = e.put(key, r.Form)
+		if ev != nil && ev.PrevNode == nil {
+			status = http.StatusCreated
+		}
+	case "DELETE":
+		ev, err = e.delete(key, q.Get("dir") == "true", q.Get("recursive") == "true")
+	default:
+		err = e.newError(etcdInvalidField, "Invalid field", "method "+r.Method, http.StatusMethodNotAllowed)
+	}
+	index := e.index
+	e.mu.Unlock()
+
+	if err != nil {
+		writeEtcd(w, err.status, index, err)
+		return
+	}
+	writeEtcd(w, status, index, ev)
+}
+
+func writeEtcd(w http.ResponseWriter, status int, index uint64, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Etcd-Index", strconv.FormatUint(index, 10))
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (e *EtcdServer) hasChildren(key string) bool {
+	for k := range e.nodes {
+		if parentEtcdKey(k) == key && k != key {
+			return true
+		}
+	}
+	return false
+}
+
+// tree returns a copy of the node at key, with its children, and theirs if recursive.
+func (e *EtcdServer) tree(key string, recursive, children bool) *etcdNode {
+	n := *e.nodes[key]
+	n.expire = nil
+	if n.Expiration != nil {
+		n.TTL = int64(time.Until(*n.Expiration)/time.Second) + 1
+	}
+	if !n.Dir || !children {
+		return &n
+	}
+	n.Nodes = nil
+	for k := range e.nodes {
+		if k != key && parentEtcdKey(k) == key {
+			n.Nodes = append(n.Nodes, e.tree(k, recursive, recursive))
+		}
+	}
+	sort.Slice(n.Nodes, func(i, j int) bool { return n.Nodes[i].Key < n.Nodes[j].Key })
+	return &n
+}
+
+func (e *EtcdServer) get(key string, recursive bool) (*etcdEvent, *etcdError) {
+	if e.nodes[key] == nil {
+		return nil, e.newError(etcdKeyNotFound, "Key not found", key, http.StatusNotFound)
+	}
+	return &etcdEvent{Action: "get", Node: e.tree(key, recursive, true)}, nil
+}
+
+func (e *EtcdServer) put(key string, form url.Values) (*etcdEvent, *etcdError) {
+	dir := form.Get("dir") == "true"
+	var ttl time.Duration
+	if s := form.Get("ttl"); s != "" {
+		secs, err := strconv.Atoi(s)
+		if err != nil || secs < 0 {
+			return nil, e.newError(etcdInvalidField, "Invalid field", "invalid ttl", http.StatusBadRequest)
+		}
+		ttl = time.Duration(secs) * time.Second
+	}
+
+	n := e.nodes[key]
+	action := "set"
+	switch form.Get("prevExist") {
+	case "true":
+		if n == nil {
+			return nil, e.newError(etcdKeyNotFound, "Key not found", key, http.StatusNotFound)
+		}
+		action = "update"
+	case "false":
+		if n != nil {
+			return nil, e.newError(etcdNodeExist, "Key already exists", key, http.StatusPreconditionFailed)
+		}
+		action = "create"
+	}
+
+	value := form.Get("value")
+	if form.Get("refresh") == "true" {
+		if n == nil {
+			return nil, e.newError(etcdKeyNotFound, "Key not found", key, http.StatusNotFound)
+		}
+		value, dir = n.Value, n.Dir
+	}
+	return e.set(key, value, dir, ttl, action)
+}
+
+// set sets the key to value, or makes it a dir, creating the dirs above it. The caller holds mu.
+func (e *EtcdServer) set(key, value string, dir bool, ttl time.Duration, action string) (*etcdEvent, *etcdError) {
+	if key == "/" {
+		return nil, e.newError(etcdNotFile, "Not a file", key, http.StatusForbidden)
+	}
+	prev := e.nodes[key]
+	if prev != nil && prev.Dir != dir {
+		return nil, e.newError(etcdNotFile, "Not a file", key, http.StatusForbidden)
+	}
+	for p := parentEtcdKey(key); ; p = parentEtcdKey(p) {
+		if n := e.nodes[p]; n != nil && !n.Dir {
+			return nil, e.newError(etcdNotFile, "Not a file", p, http.StatusForbidden)
+		}
+		if p == "/" {
+			break
+		}
+	}
+
+	e.index++
+	for p := parentEtcdKey(key); e.nodes[p] == nil; p = parentEtcdKey(p) {
+		e.nodes[p] = &etcdNode{Key: p, Dir: true, CreatedIndex: e.index, ModifiedIndex: e.index}
+	}
+
+	n := &etcdNode{Key: key, Dir: dir, Value: value, CreatedIndex: e.index, ModifiedIndex: e.index}
+	var prevNode *etcdNode
+	if prev != nil {
+		prevNode = e.tree(key, false, false)
+		n.CreatedIndex = prev.CreatedIndex
+		if prev.expire != nil {
+			prev.expire.Stop()
+		}
+	}
+	if ttl > 0 {
+		expiration := time.Now().Add(ttl)
+		n.Expiration = &expiration
+		created := n.CreatedIndex
+		n.expire = time.AfterFunc(ttl, func() { e.expire(key, created) })
+	}
+	e.nodes[key] = n
+	return e.notify(&etcdEvent{Action: action, Node: e.tree(key, false, false), PrevNode: prevNode}), nil
+}
+
+// delete deletes the key, which may be an empty dir if dir, or any dir with all below it if recursive.
+// The caller holds mu.
+func (e *EtcdServer) delete(key string, dir, recursive bool) (*etcdEvent, *etcdError) {
+	n := e.nodes[key]
+	if n == nil {
+		return nil, e.newError(etcdKeyNotFound, "Key not found", key, http.StatusNotFound)
+	}
+	if key == "/" || (n.Dir && !dir && !recursive) {
+		return nil, e.newError(etcdNotFile, "Not a file", key, http.StatusForbidden)
+	}
+	if n.Dir && !recursive && e.hasChildren(key) {
+		return nil, e.newError(etcdDirNotEmpty, "Directory not empty", key, http.StatusForbidden)
+	}
+	return e.remove(key, "delete"), nil
+}
+
+// remove removes the key and all below it. The caller holds mu.
+func (e *EtcdServer) remove(key, action string) *etcdEvent {
+	prevNode := e.tree(key, false, false)
+	for k, n := range e.nodes {
+		if k == key || strings.HasPrefix(k, key+"/") {
+			if n.expire != nil {
+				n.expire.Stop()
+			}
+			delete(e.nodes, k)
+		}
+	}
+
+	e.index++
+	node := &etcdNode{Key: key, Dir: prevNode.Dir, CreatedIndex: prevNode.CreatedIndex, ModifiedIndex: e.index}
+	return e.notify(&etcdEvent{Action: action, Node: node, PrevNode: prevNode})
+}
+
+func (e *EtcdServer) expire(key string, created uint64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if n := e.nodes[key]; n != nil && n.CreatedIndex == created && n.Expiration != nil && !e.closed {
+		e.remove(key, "expire")
+	}
+}
+
+// notify records ev in the history and wakes up the watches. The caller holds mu.
+func (e *EtcdServer) notify(ev *etcdEvent) *etcdEvent {
+	e.events = append(e.events, ev)
+	if !e.closed {
+		close(e.changed)
+		e.changed = make(chan struct{})
+	}
+	return ev
+}
+
+// watch answers with the first event on key, or below it if recursive, from waitIndex on.
+// Like etcd, it sends the headers at once and the event when it happens.
+func (e *EtcdServer) watch(w http.ResponseWriter, r *http.Request, key string) {
+	q := r.URL.Query()
+	recursive := q.Get("recursive") == "true"
+	waitIndex, _ := strconv.ParseUint(q.Get("waitIndex"), 10, 64)
+
+	e.mu.Lock()
+	if waitIndex == 0 {
+		waitIndex = e.index + 1
+	}
+	index := e.index
+	e.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Etcd-Index", strconv.FormatUint(index, 10))
+	w.WriteHeader(http.StatusOK)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	for {
+		e.mu.Lock()
+		var found *etcdEvent
+		for _, ev := range e.events {
+			k := ev.Node.Key
+			if ev.Node.ModifiedIndex >= waitIndex &&
+				(k == key || (recursive && (key == "/" || strings.HasPrefix(k, key+"/")))) {
+				found = ev
+				break
+			}
+		}
+		changed, closed := e.changed, e.closed
+		e.mu.Unlock()
+
+		if found != nil {
+			json.NewEncoder(w).Encode(found)
+			return
+		}
+		if closed {
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
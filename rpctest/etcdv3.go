@@ -0,0 +1,416 @@
+package rpctest
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// EtcdV3Server is a fake of etcd with the v3 API, which EtcdV3ClientSelector and
+// EtcdV3RegisterPlugin use. Unlike the other fakes it is not served on a port: it is the
+// KV, Watcher and Lease which the plugin, and the selector in its tests, take in place of
+// an etcd client.
+// It gets, puts and deletes keys, watches them from any revision which is not compacted,
+// and grants, keeps alive and revokes leases, deleting their keys when they expire.
+//
+// The methods of the interfaces which the selector and the plugin do not use panic.
+type EtcdV3Server struct {
+	clientv3.KV
+	clientv3.Watcher
+	clientv3.Lease
+
+	mu        sync.Mutex
+	revision  int64
+	compacted int64
+	kvs       map[string]*mvccpb.KeyValue
+	history   []*clientv3.Event
+	watches   map[*etcdV3Watch]bool
+	lastLease clientv3.LeaseID
+	//leases are the keep alive channels of the granted leases
+	leases      map[clientv3.LeaseID]map[chan *clientv3.LeaseKeepAliveResponse]bool
+	gets        int
+	unavailable bool
+}
+
+type etcdV3Watch struct {
+	key    string
+	prefix bool
+	ctx    context.Context
+	ch     chan clientv3.WatchResponse
+}
+
+// etcdV3TTL is the TTL of the leases, which never expire unless ExpireLease is called
+const etcdV3TTL = 10
+
+//ErrEtcdV3Unavailable is returned by the requests to an EtcdV3Server which is unavailable.
+var ErrEtcdV3Unavailable = errors.New("rpctest: etcd unavailable")
+
+// NewEtcdV3Server creates an EtcdV3Server with no keys.
+func NewEtcdV3Server() *EtcdV3Server {
+	return &EtcdV3Server{
+		revision: 1,
+		kvs:      make(map[string]*mvccpb.KeyValue),
+		watches:  make(map[*etcdV3Watch]bool),
+		leases:   make(map[clientv3.LeaseID]map[chan *clientv3.LeaseKeepAliveResponse]bool),
+	}
+}
+
+// Close ends the watches and the keep alives.
+func (e *EtcdV3Server) Close() error {
+	e.BreakWatches()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for id, chs := range e.leases {
+		for ch := range chs {
+			close(ch)
+		}
+		e.leases[id] = make(map[chan *clientv3.LeaseKeepAliveResponse]bool)
+	}
+	return nil
+}
+
+// AddNode puts the key service/server with the encoded metadata as its value.
+func (e *EtcdV3Server) AddNode(service, server string, metadata url.Values) error {
+	key := strings.TrimSuffix(service, "/") + "/" + server
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.kvs[key] != nil {
+		return ErrNodeExists
+	}
+	e.put(key, metadata.Encode(), 0)
+	return nil
+}
+
+// RemoveNode deletes the key service/server.
+func (e *EtcdV3Server) RemoveNode(service, server string) error {
+	key := strings.TrimSuffix(service, "/") + "/" + server
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.kvs[key] == nil {
+		return ErrNoNode
+	}
+	e.delete(key)
+	return nil
+}
+
+// SetWeight updates the weight in the value of the key service/server, keeping its lease.
+func (e *EtcdV3Server) SetWeight(service, server string, weight int) error {
+	key := strings.TrimSuffix(service, "/") + "/" + server
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	kv := e.kvs[key]
+	if kv == nil {
+		return ErrNoNode
+	}
+	e.put(key, withWeight(string(kv.Value), weight), clientv3.LeaseID(kv.Lease))
+	return nil
+}
+
+// SetAvailable makes the requests of the KV and the Lease fail with ErrEtcdV3Unavailable
+// until it is called with true, as if etcd could not be reached. The watches go on.
+func (e *EtcdV3Server) SetAvailable(available bool) {
+	e.mu.Lock()
+	e.unavailable = !available
+	e.mu.Unlock()
+}
+
+// Gets returns the number of the Get requests so far, including those which failed.
+func (e *EtcdV3Server) Gets() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.gets
+}
+
+// Revision returns the current revision.
+func (e *EtcdV3Server) Revision() int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.revision
+}
+
+// BreakWatches ends the watches, as a lost connection to etcd does.
+func (e *EtcdV3Server) BreakWatches() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for w := range e.watches {
+		close(w.ch)
+	}
+	e.watches = make(map[*etcdV3Watch]bool)
+}
+
+// ExpireLease expires the lease id as if it was not kept alive: its keys are deleted,
+// and its keep alive channels are closed.
+func (e *EtcdV3Server) ExpireLease(id clientv3.LeaseID) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.expire(id)
+}
+
+func (e *EtcdV3Server) header() *etcdserverpb.ResponseHeader {
+	return &etcdserverpb.ResponseHeader{Revision: e.revision}
+}
+
+// matches tells if key is op.KeyBytes, or under it for a prefix op.
+func matches(op clientv3.Op, key string) bool {
+	if op.IsOptsWithPrefix() {
+		return strings.HasPrefix(key, string(op.KeyBytes()))
+	}
+	return key == string(op.KeyBytes())
+}
+
+// leaseOf returns the lease of a put, which clientv3.Op has no getter for.
+func leaseOf(op clientv3.Op) clientv3.LeaseID {
+	return clientv3.LeaseID(reflect.ValueOf(op).FieldByName("leaseID").Int())
+}
+
+// Get gets the key, or the keys under it WithPrefix, sorted.
+func (e *EtcdV3Server) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	op := clientv3.OpGet(key, opts...)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.gets++
+	if e.unavailable {
+		return nil, ErrEtcdV3Unavailable
+	}
+
+	resp := &clientv3.GetResponse{Header: e.header()}
+	for k, kv := range e.kvs {
+		if matches(op, k) {
+			copied := *kv
+			resp.Kvs = append(resp.Kvs, &copied)
+		}
+	}
+	sort.Slice(resp.Kvs, func(i, j int) bool { return string(resp.Kvs[i].Key) < string(resp.Kvs[j].Key) })
+	resp.Count = int64(len(resp.Kvs))
+	return resp, nil
+}
+
+// Put puts the key, attached to the lease of WithLease if any.
+func (e *EtcdV3Server) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	lease := leaseOf(clientv3.OpPut(key, val, opts...))
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.unavailable {
+		return nil, ErrEtcdV3Unavailable
+	}
+	if _, ok := e.leases[lease]; lease != 0 && !ok {
+		return nil, rpctypes.ErrLeaseNotFound
+	}
+	e.put(key, val, lease)
+	return &clientv3.PutResponse{Header: e.header()}, nil
+}
+
+// Delete deletes the key, or the keys under it WithPrefix.
+func (e *EtcdV3Server) Delete(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.DeleteResponse, error) {
+	op := clientv3.OpDelete(key, opts...)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.unavailable {
+		return nil, ErrEtcdV3Unavailable
+	}
+
+	resp := &clientv3.DeleteResponse{}
+	for k := range e.kvs {
+		if matches(op, k) {
+			e.delete(k)
+			resp.Deleted++
+		}
+	}
+	resp.Header = e.header()
+	return resp, nil
+}
+
+// Compact forgets the changes up to rev, so that watches from rev or before fail.
+func (e *EtcdV3Server) Compact(ctx context.Context, rev int64, opts ...clientv3.CompactOption) (*clientv3.CompactResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if rev > e.revision {
+		return nil, rpctypes.ErrFutureRev
+	}
+	if rev <= e.compacted {
+		return nil, rpctypes.ErrCompacted
+	}
+	e.compacted = rev
+	history := e.history[:0]
+	for _, ev := range e.history {
+		if ev.Kv.ModRevision > rev {
+			history = append(history, ev)
+		}
+	}
+	e.history = history
+	return &clientv3.CompactResponse{Header: e.header()}, nil
+}
+
+// put puts key, sending the event to the watches. The caller holds mu.
+func (e *EtcdV3Server) put(key, val string, lease clientv3.LeaseID) {
+	e.revision++
+	kv := &mvccpb.KeyValue{Key: []byte(key), Value: []byte(val), ModRevision: e.revision, CreateRevision: e.revision, Version: 1, Lease: int64(lease)}
+	if old := e.kvs[key]; old != nil {
+		kv.CreateRevision, kv.Version = old.CreateRevision, old.Version+1
+	}
+	e.kvs[key] = kv
+	copied := *kv
+	e.emit(&clientv3.Event{Type: mvccpb.PUT, Kv: &copied})
+}
+
+// delete deletes key, sending the event to the watches. The caller holds mu.
+func (e *EtcdV3Server) delete(key string) {
+	e.revision++
+	delete(e.kvs, key)
+	e.emit(&clientv3.Event{Type: mvccpb.DELETE, Kv: &mvccpb.KeyValue{Key: []byte(key), ModRevision: e.revision}})
+}
+
+// emit records ev and sends it to the watches of its key. The caller holds mu.
+func (e *EtcdV3Server) emit(ev *clientv3.Event) {
+	e.history = append(e.history, ev)
+	for w := range e.watches {
+		if w.matches(string(ev.Kv.Key)) {
+			w.send(clientv3.WatchResponse{Header: *e.header(), Events: []*clientv3.Event{ev}})
+		}
+	}
+}
+
+func (w *etcdV3Watch) matches(key string) bool {
+	if w.prefix {
+		return strings.HasPrefix(key, w.key)
+	}
+	return key == w.key
+}
+
+func (w *etcdV3Watch) send(resp clientv3.WatchResponse) {
+	select {
+	case w.ch <- resp:
+	case <-w.ctx.Done():
+	}
+}
+
+// Watch watches the key, or the keys under it WithPrefix, from the revision of WithRev
+// if it is not zero, which fails with the compact revision if it has been compacted.
+func (e *EtcdV3Server) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	op := clientv3.OpGet(key, opts...)
+	w := &etcdV3Watch{key: key, prefix: op.IsOptsWithPrefix(), ctx: ctx, ch: make(chan clientv3.WatchResponse, 100)}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if rev := op.Rev(); rev != 0 && rev <= e.compacted {
+		w.ch <- clientv3.WatchResponse{Header: *e.header(), CompactRevision: e.compacted, Canceled: true}
+		close(w.ch)
+		return w.ch
+	}
+
+	var events []*clientv3.Event
+	for _, ev := range e.history {
+		if op.Rev() != 0 && ev.Kv.ModRevision >= op.Rev() && w.matches(string(ev.Kv.Key)) {
+			events = append(events, ev)
+		}
+	}
+	if len(events) > 0 {
+		w.ch <- clientv3.WatchResponse{Header: *e.header(), Events: events}
+	}
+	e.watches[w] = true
+
+	go func() {
+		<-ctx.Done()
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if e.watches[w] {
+			delete(e.watches, w)
+			close(w.ch)
+		}
+	}()
+	return w.ch
+}
+
+// Grant grants a lease, which lives until it is revoked or expired by ExpireLease.
+func (e *EtcdV3Server) Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.unavailable {
+		return nil, ErrEtcdV3Unavailable
+	}
+	e.lastLease++
+	e.leases[e.lastLease] = make(map[chan *clientv3.LeaseKeepAliveResponse]bool)
+	return &clientv3.LeaseGrantResponse{ResponseHeader: e.header(), ID: e.lastLease, TTL: ttl}, nil
+}
+
+// Revoke revokes the lease id, deleting its keys.
+func (e *EtcdV3Server) Revoke(ctx context.Context, id clientv3.LeaseID) (*clientv3.LeaseRevokeResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.unavailable {
+		return nil, ErrEtcdV3Unavailable
+	}
+	if _, ok := e.leases[id]; !ok {
+		return nil, rpctypes.ErrLeaseNotFound
+	}
+	e.expire(id)
+	return &clientv3.LeaseRevokeResponse{Header: e.header()}, nil
+}
+
+// KeepAlive keeps the lease id alive until ctx is done. The channel is closed when the
+// lease expires.
+func (e *EtcdV3Server) KeepAlive(ctx context.Context, id clientv3.LeaseID) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.unavailable {
+		return nil, ErrEtcdV3Unavailable
+	}
+	chs, ok := e.leases[id]
+	if !ok {
+		return nil, rpctypes.ErrLeaseNotFound
+	}
+
+	ch := make(chan *clientv3.LeaseKeepAliveResponse, 1)
+	ch <- &clientv3.LeaseKeepAliveResponse{ResponseHeader: e.header(), ID: id, TTL: etcdV3TTL}
+	chs[ch] = true
+
+	go func() {
+		<-ctx.Done()
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if e.leases[id][ch] {
+			delete(e.leases[id], ch)
+			close(ch)
+		}
+	}()
+	return ch, nil
+}
+
+// Leases returns the leases which are alive.
+func (e *EtcdV3Server) Leases(ctx context.Context) (*clientv3.LeaseLeasesResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	resp := &clientv3.LeaseLeasesResponse{ResponseHeader: e.header()}
+	for id := range e.leases {
+		resp.Leases = append(resp.Leases, clientv3.LeaseStatus{ID: id})
+	}
+	sort.Slice(resp.Leases, func(i, j int) bool { return resp.Leases[i].ID < resp.Leases[j].ID })
+	return resp, nil
+}
+
+// expire deletes the keys of the lease id and the lease. The caller holds mu.
+func (e *EtcdV3Server) expire(id clientv3.LeaseID) {
+	var keys []string
+	for k, kv := range e.kvs {
+		if clientv3.LeaseID(kv.Lease) == id {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		e.delete(k)
+	}
+	for ch := range e.leases[id] {
+		close(ch)
+	}
+	delete(e.leases, id)
+}
@@ -0,0 +1,52 @@
+// Package rpctest provides in-process fakes of the registries which the selectors of
+// clientselector discover servers from, so that discovery and rebalancing can be tested
+// without a live etcd, ZooKeeper or Consul.
+//
+// Each fake serves the subset of the API of its registry which the selectors and the
+// register plugins use, on a port of the loopback interface, and is a Registry whose
+// nodes tests add, remove and re-weight:
+//
+//	etcd := rpctest.NewEtcdServer()
+//	defer etcd.Close()
+//	etcd.AddNode("/rpct/Arith", "tcp@127.0.0.1:8972", url.Values{"weight": {"2"}})
+//	selector := clientselector.NewEtcdClientSelector(etcd.Endpoints(), "/rpct/Arith", time.Minute, src.WeightedRoundRobin, time.Second)
+//
+// EtcdV3Server is not served on a port: it implements the KV, Watcher and Lease of the
+// etcd v3 client, which EtcdV3RegisterPlugin takes in place of a client, as
+// EtcdV3ClientSelector does in its tests.
+package rpctest
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+)
+
+// Registry is a fake registry of the servers of services.
+// service is the base path of a service in etcd and ZooKeeper, like "/rpct/Arith", and its name in Consul.
+// server is "network@address", like "tcp@127.0.0.1:8972", and metadata is what selectors parse
+// the "weight" and the "state" of a server from.
+type Registry interface {
+	//AddNode registers server for service
+	AddNode(service, server string, metadata url.Values) error
+	//RemoveNode deregisters server from service
+	RemoveNode(service, server string) error
+	//SetWeight changes the weight in the metadata of server, keeping the rest of it
+	SetWeight(service, server string, weight int) error
+	//Close stops the registry
+	Close() error
+}
+
+var (
+	//ErrNodeExists is returned by AddNode for a server which is registered already.
+	ErrNodeExists = errors.New("rpctest: node already exists")
+	//ErrNoNode is returned for a server which is not registered.
+	ErrNoNode = errors.New("rpctest: node does not exist")
+)
+
+// withWeight returns metadata with its weight set to weight.
+func withWeight(metadata string, weight int) string {
+	v, _ := url.ParseQuery(metadata)
+	v.Set("weight", strconv.Itoa(weight))
+	return v.Encode()
+}
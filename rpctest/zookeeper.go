@@ -0,0 +1,663 @@
+package rpctest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ZooKeeperServer is a fake of ZooKeeper, which ZooKeeperClientSelector uses.
+// It speaks the ZooKeeper protocol as far as creating, deleting, reading and writing
+// znodes, listing their children, and watching them goes. Sessions end with their
+// connections, taking their ephemeral znodes with them.
+type ZooKeeperServer struct {
+	listener net.Listener
+
+	mu          sync.Mutex
+	nodes       map[string]*zkNode
+	zxid        int64
+	lastSession int64
+	sessions    map[*zkSession]bool
+	//dataWatches are set by exists on existing znodes and by getData
+	dataWatches map[string]map[*zkSession]bool
+	//existWatches are set by exists on missing znodes
+	existWatches map[string]map[*zkSession]bool
+	childWatches map[string]map[*zkSession]bool
+	wg           sync.WaitGroup
+}
+
+type zkNode struct {
+	data     []byte
+	children map[string]bool
+	czxid    int64
+	mzxid    int64
+	pzxid    int64
+	ctime    int64
+	mtime    int64
+	version  int32
+	cversion int32
+	owner    int64
+}
+
+type zkSession struct {
+	id   int64
+	conn net.Conn
+	//mu serializes the responses and the events written to conn
+	mu sync.Mutex
+}
+
+// zkEvent is a watch event to be sent to a session.
+type zkEvent struct {
+	session *zkSession
+	typ     int32
+	path    string
+}
+
+const (
+	zkOpCreate       = 1
+	zkOpDelete       = 2
+	zkOpExists       = 3
+	zkOpGetData      = 4
+	zkOpSetData      = 5
+	zkOpGetChildren  = 8
+	zkOpSync         = 9
+	zkOpPing         = 11
+	zkOpGetChildren2 = 12
+	zkOpClose        = -11
+	zkOpSetAuth      = 100
+	zkOpSetWatches   = 101
+
+	zkEventNodeCreated         = 1
+	zkEventNodeDeleted         = 2
+	zkEventNodeDataChanged     = 3
+	zkEventNodeChildrenChanged = 4
+	zkStateSyncConnected       = 3
+
+	zkFlagEphemeral = 1
+	zkFlagSequence  = 2
+
+	zkErrUnimplemented           = -6
+	zkErrBadArguments            = -8
+	zkErrNoNode                  = -101
+	zkErrBadVersion              = -103
+	zkErrNoChildrenForEphemerals = -108
+	zkErrNodeExists              = -110
+	zkErrNotEmpty                = -111
+
+	//zkWatchXid is the xid of watch events
+	zkWatchXid  = -1
+	zkMaxPacket = 1 << 20
+)
+
+// NewZooKeeperServer starts a ZooKeeperServer on a port of the loopback interface.
+func NewZooKeeperServer() (*ZooKeeperServer, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	z := &ZooKeeperServer{
+		listener:     ln,
+		nodes:        map[string]*zkNode{"/": {children: map[string]bool{}}},
+		sessions:     make(map[*zkSession]bool),
+		dataWatches:  make(map[string]map[*zkSession]bool),
+		existWatches: make(map[string]map[*zkSession]bool),
+		childWatches: make(map[string]map[*zkSession]bool),
+	}
+	z.wg.Add(1)
+	go z.serve()
+	return z, nil
+}
+
+// Servers returns the addresses of z, for zk.Connect.
+func (z *ZooKeeperServer) Servers() []string {
+	return []string{z.listener.Addr().String()}
+}
+
+// Close stops z and closes the connections of its sessions.
+func (z *ZooKeeperServer) Close() error {
+	err := z.listener.Close()
+	z.mu.Lock()
+	for s := range z.sessions {
+		s.conn.Close()
+	}
+	z.mu.Unlock()
+	z.wg.Wait()
+	return err
+}
+
+// AddNode creates the znode service/server, with the encoded metadata as its data,
+// and the znodes above it which are missing.
+func (z *ZooKeeperServer) AddNode(service, server string, metadata url.Values) error {
+	path := strings.TrimSuffix(service, "/") + "/" + server
+	z.mu.Lock()
+	var events []zkEvent
+	code := z.mkdirs(path[:strings.LastIndex(path, "/")], &events)
+	if code == 0 {
+		_, code = z.create(path, []byte(metadata.Encode()), 0, 0, &events)
+	}
+	z.mu.Unlock()
+	z.send(events)
+	return zkRegistryError(code)
+}
+
+// RemoveNode deletes the znode service/server.
+func (z *ZooKeeperServer) RemoveNode(service, server string) error {
+	z.mu.Lock()
+	var events []zkEvent
+	code := z.delete(strings.TrimSuffix(service, "/")+"/"+server, -1, &events)
+	z.mu.Unlock()
+	z.send(events)
+	return zkRegistryError(code)
+}
+
+// SetWeight updates the weight in the data of the znode service/server.
+// Unlike ZooKeeper, it also fires the children watches of service, since
+// ZooKeeperClientSelector only watches the children and would miss the new weight.
+func (z *ZooKeeperServer) SetWeight(service, server string, weight int) error {
+	path := strings.TrimSuffix(service, "/") + "/" + server
+	z.mu.Lock()
+	var events []zkEvent
+	code := int32(zkErrNoNode)
+	if n := z.nodes[path]; n != nil {
+		_, code = z.setData(path, []byte(withWeight(string(n.data), weight)), -1, &events)
+	}
+	if code == 0 {
+		trigger(z.childWatches, parentZKPath(path), zkEventNodeChildrenChanged, &events)
+	}
+	z.mu.Unlock()
+	z.send(events)
+	return zkRegistryError(code)
+}
+
+func zkRegistryError(code int32) error {
+	switch code {
+	case 0:
+		return nil
+	case zkErrNoNode:
+		return ErrNoNode
+	case zkErrNodeExists:
+		return ErrNodeExists
+	}
+	return fmt.Errorf("rpctest: zookeeper error %d", code)
+}
+
+func (z *ZooKeeperServer) serve() {
+	defer z.wg.Done()
+	for {
+		conn, err := z.listener.Accept()
+		if err != nil {
+			return
+		}
+		z.wg.Add(1)
+		go z.serveConn(conn)
+	}
+}
+
+func (z *ZooKeeperServer) serveConn(conn net.Conn) {
+	defer z.wg.Done()
+	defer conn.Close()
+
+	packet, err := readZKPacket(conn)
+	if err != nil {
+		return
+	}
+	r := &zkReader{buf: packet}
+	r.int32() // protocol version
+	r.int64() // last zxid seen
+	timeout := r.int32()
+	sessionID := r.int64()
+	if r.err != nil {
+		return
+	}
+
+	s := &zkSession{conn: conn}
+	w := &zkWriter{}
+	w.int32(0)
+	w.int32(timeout)
+	if sessionID != 0 {
+		// sessions end with their connections, so the session is expired
+		w.int64(0)
+		w.bytes(make([]byte, 16))
+		s.write(w.buf)
+		return
+	}
+
+	z.mu.Lock()
+	z.lastSession++
+	s.id = z.lastSession
+	z.sessions[s] = true
+	z.mu.Unlock()
+	defer z.endSession(s)
+
+	w.int64(s.id)
+	w.bytes(make([]byte, 16))
+	if s.write(w.buf) != nil {
+		return
+	}
+
+	for {
+		packet, err := readZKPacket(conn)
+		if err != nil {
+			return
+		}
+		r := &zkReader{buf: packet}
+		xid, op := r.int32(), r.int32()
+		if r.err != nil {
+			return
+		}
+
+		var events []zkEvent
+		z.mu.Lock()
+		body, code := z.handle(s, op, r, &events)
+		zxid := z.zxid
+		z.mu.Unlock()
+		z.send(events)
+
+		w := &zkWriter{}
+		w.int32(xid)
+		w.int64(zxid)
+		w.int32(code)
+		if code == 0 && body != nil {
+			w.buf = append(w.buf, body...)
+		}
+		if s.write(w.buf) != nil || op == zkOpClose {
+			return
+		}
+	}
+}
+
+// endSession forgets s and deletes its ephemeral znodes.
+func (z *ZooKeeperServer) endSession(s *zkSession) {
+	var events []zkEvent
+	z.mu.Lock()
+	delete(z.sessions, s)
+	for _, watches := range []map[string]map[*zkSession]bool{z.dataWatches, z.existWatches, z.childWatches} {
+		for _, sessions := range watches {
+			delete(sessions, s)
+		}
+	}
+	var ephemerals []string
+	for path, n := range z.nodes {
+		if n.owner == s.id {
+			ephemerals = append(ephemerals, path)
+		}
+	}
+	sort.Strings(ephemerals)
+	for _, path := range ephemerals {
+		z.delete(path, -1, &events)
+	}
+	z.mu.Unlock()
+	z.send(events)
+}
+
+// handle runs a request of s and returns the body of its response and its error code.
+// The caller holds mu and sends the events.
+func (z *ZooKeeperServer) handle(s *zkSession, op int32, r *zkReader, events *[]zkEvent) ([]byte, int32) {
+	w := &zkWriter{}
+	switch op {
+	case zkOpPing, zkOpClose, zkOpSetAuth:
+		return nil, 0
+
+	case zkOpSync:
+		w.string(r.string())
+
+	case zkOpCreate:
+		path, data := r.string(), r.bytes()
+		for i, n := 0, r.int32(); i < int(n) && r.err == nil; i++ {
+			r.int32()  // perms
+			r.string() // scheme
+			r.string() // id
+		}
+		flags := r.int32()
+		if r.err != nil {
+			return nil, zkErrBadArguments
+		}
+		created, code := z.create(path, data, flags, s.id, events)
+		if code != 0 {
+			return nil, code
+		}
+		w.string(created)
+
+	case zkOpDelete:
+		path, version := r.string(), r.int32()
+		if r.err != nil {
+			return nil, zkErrBadArguments
+		}
+		return nil, z.delete(path, version, events)
+
+	case zkOpSetData:
+		path, data, version := r.string(), r.bytes(), r.int32()
+		if r.err != nil {
+			return nil, zkErrBadArguments
+		}
+		n, code := z.setData(path, data, version, events)
+		if code != 0 {
+			return nil, code
+		}
+		z.writeStat(w, n)
+
+	case zkOpExists, zkOpGetData, zkOpGetChildren, zkOpGetChildren2:
+		path, watch := r.string(), r.bool()
+		if r.err != nil {
+			return nil, zkErrBadArguments
+		}
+		n := z.nodes[path]
+		if op == zkOpExists && n == nil && watch {
+			addZKWatch(z.existWatches, path, s)
+		}
+		if n == nil {
+			return nil, zkErrNoNode
+		}
+
+		switch op {
+		case zkOpExists:
+			if watch {
+				addZKWatch(z.dataWatches, path, s)
+			}
+			z.writeStat(w, n)
+		case zkOpGetData:
+			if watch {
+				addZKWatch(z.dataWatches, path, s)
+			}
+			w.bytes(n.data)
+			z.writeStat(w, n)
+		default:
+			if watch {
+				addZKWatch(z.childWatches, path, s)
+			}
+			children := make([]string, 0, len(n.children))
+			for child := range n.children {
+				children = append(children, child)
+			}
+			sort.Strings(children)
+			w.int32(int32(len(children)))
+			for _, child := range children {
+				w.string(child)
+			}
+			if op == zkOpGetChildren2 {
+				z.writeStat(w, n)
+			}
+		}
+
+	case zkOpSetWatches:
+		r.int64() // relative zxid
+		for _, watches := range []map[string]map[*zkSession]bool{z.dataWatches, z.existWatches, z.childWatches} {
+			for i, n := 0, r.int32(); i < int(n) && r.err == nil; i++ {
+				addZKWatch(watches, r.string(), s)
+			}
+		}
+
+	default:
+		return nil, zkErrUnimplemented
+	}
+	return w.buf, 0
+}
+
+func addZKWatch(watches map[string]map[*zkSession]bool, path string, s *zkSession) {
+	if watches[path] == nil {
+		watches[path] = make(map[*zkSession]bool)
+	}
+	watches[path][s] = true
+}
+
+// trigger fires the watches on path, which are set once. The caller holds mu.
+func trigger(watches map[string]map[*zkSession]bool, path string, typ int32, events *[]zkEvent) {
+	for s := range watches[path] {
+		*events = append(*events, zkEvent{session: s, typ: typ, path: path})
+	}
+	delete(watches, path)
+}
+
+func parentZKPath(path string) string {
+	i := strings.LastIndex(path, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return path[:i]
+}
+
+func validZKPath(path string) bool {
+	return path == "/" || (strings.HasPrefix(path, "/") && !strings.HasSuffix(path, "/") && !strings.Contains(path, "//"))
+}
+
+// mkdirs creates the persistent znodes of path which are missing. The caller holds mu.
+func (z *ZooKeeperServer) mkdirs(path string, events *[]zkEvent) int32 {
+	if path == "" || path == "/" || z.nodes[path] != nil {
+		return 0
+	}
+	if code := z.mkdirs(parentZKPath(path), events); code != 0 {
+		return code
+	}
+	_, code := z.create(path, nil, 0, 0, events)
+	return code
+}
+
+// create creates a znode and returns its path, which has a suffix if it is sequential.
+// The caller holds mu.
+func (z *ZooKeeperServer) create(path string, data []byte, flags int32, session int64, events *[]zkEvent) (string, int32) {
+	if !validZKPath(path) || path == "/" {
+		return "", zkErrBadArguments
+	}
+	parent := z.nodes[parentZKPath(path)]
+	if parent == nil {
+		return "", zkErrNoNode
+	}
+	if parent.owner != 0 {
+		return "", zkErrNoChildrenForEphemerals
+	}
+	if flags&zkFlagSequence != 0 {
+		path += fmt.Sprintf("%010d", parent.cversion)
+	}
+	if z.nodes[path] != nil {
+		return "", zkErrNodeExists
+	}
+
+	z.zxid++
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	n := &zkNode{data: data, children: make(map[string]bool), czxid: z.zxid, mzxid: z.zxid, pzxid: z.zxid, ctime: now, mtime: now}
+	if flags&zkFlagEphemeral != 0 {
+		n.owner = session
+	}
+	z.nodes[path] = n
+	parent.children[path[strings.LastIndex(path, "/")+1:]] = true
+	parent.cversion++
+	parent.pzxid = z.zxid
+
+	trigger(z.existWatches, path, zkEventNodeCreated, events)
+	trigger(z.childWatches, parentZKPath(path), zkEventNodeChildrenChanged, events)
+	return path, 0
+}
+
+// delete deletes a znode which has no children, if version is its version or -1.
+// The caller holds mu.
+func (z *ZooKeeperServer) delete(path string, version int32, events *[]zkEvent) int32 {
+	if path == "/" || !validZKPath(path) {
+		return zkErrBadArguments
+	}
+	n := z.nodes[path]
+	if n == nil {
+		return zkErrNoNode
+	}
+	if version != -1 && version != n.version {
+		return zkErrBadVersion
+	}
+	if len(n.children) > 0 {
+		return zkErrNotEmpty
+	}
+
+	z.zxid++
+	delete(z.nodes, path)
+	parent := z.nodes[parentZKPath(path)]
+	delete(parent.children, path[strings.LastIndex(path, "/")+1:])
+	parent.cversion++
+	parent.pzxid = z.zxid
+
+	trigger(z.dataWatches, path, zkEventNodeDeleted, events)
+	trigger(z.childWatches, path, zkEventNodeDeleted, events)
+	trigger(z.childWatches, parentZKPath(path), zkEventNodeChildrenChanged, events)
+	return 0
+}
+
+// setData sets the data of a znode, if version is its version or -1. The caller holds mu.
+func (z *ZooKeeperServer) setData(path string, data []byte, version int32, events *[]zkEvent) (*zkNode, int32) {
+	n := z.nodes[path]
+	if n == nil {
+		return nil, zkErrNoNode
+	}
+	if version != -1 && version != n.version {
+		return nil, zkErrBadVersion
+	}
+
+	z.zxid++
+	n.data = data
+	n.version++
+	n.mzxid = z.zxid
+	n.mtime = time.Now().UnixNano() / int64(time.Millisecond)
+
+	trigger(z.dataWatches, path, zkEventNodeDataChanged, events)
+	return n, 0
+}
+
+func (z *ZooKeeperServer) writeStat(w *zkWriter, n *zkNode) {
+	w.int64(n.czxid)
+	w.int64(n.mzxid)
+	w.int64(n.ctime)
+	w.int64(n.mtime)
+	w.int32(n.version)
+	w.int32(n.cversion)
+	w.int32(0) // aversion
+	w.int64(n.owner)
+	w.int32(int32(len(n.data)))
+	w.int32(int32(len(n.children)))
+	w.int64(n.pzxid)
+}
+
+// send sends the watch events to their sessions.
+func (z *ZooKeeperServer) send(events []zkEvent) {
+	for _, e := range events {
+		w := &zkWriter{}
+		w.int32(zkWatchXid)
+		w.int64(-1)
+		w.int32(0)
+		w.int32(e.typ)
+		w.int32(zkStateSyncConnected)
+		w.string(e.path)
+		e.session.write(w.buf)
+	}
+}
+
+// write writes a packet to the connection of s.
+func (s *zkSession) write(packet []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	buf := make([]byte, 4+len(packet))
+	binary.BigEndian.PutUint32(buf, uint32(len(packet)))
+	copy(buf[4:], packet)
+	_, err := s.conn.Write(buf)
+	return err
+}
+
+func readZKPacket(r io.Reader) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > zkMaxPacket {
+		return nil, errors.New("rpctest: zookeeper packet too large")
+	}
+	packet := make([]byte, n)
+	_, err := io.ReadFull(r, packet)
+	return packet, err
+}
+
+// zkWriter encodes the fields of a packet like jute, the serialization of ZooKeeper.
+type zkWriter struct {
+	buf []byte
+}
+
+func (w *zkWriter) int32(v int32) {
+	w.buf = append(w.buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (w *zkWriter) int64(v int64) {
+	w.int32(int32(v >> 32))
+	w.int32(int32(v))
+}
+
+func (w *zkWriter) string(s string) {
+	w.int32(int32(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *zkWriter) bytes(b []byte) {
+	if b == nil {
+		w.int32(-1)
+		return
+	}
+	w.int32(int32(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+// zkReader decodes the fields of a packet, keeping the first error.
+type zkReader struct {
+	buf []byte
+	err error
+}
+
+func (r *zkReader) next(n int) []byte {
+	if r.err != nil || n < 0 || n > len(r.buf) {
+		if r.err == nil {
+			r.err = io.ErrUnexpectedEOF
+		}
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *zkReader) int32() int32 {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func (r *zkReader) int64() int64 {
+	b := r.next(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+func (r *zkReader) bool() bool {
+	b := r.next(1)
+	return b != nil && b[0] != 0
+}
+
+func (r *zkReader) bytes() []byte {
+	n := r.int32()
+	if n < 0 {
+		return nil
+	}
+	return append([]byte(nil), r.next(int(n))...)
+}
+
+func (r *zkReader) string() string {
+	n := r.int32()
+	if n < 0 {
+		return ""
+	}
+	return string(r.next(int(n)))
+}
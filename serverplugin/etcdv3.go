@@ -0,0 +1,252 @@
+// Package serverplugin provides the server plugins which depend on a registry client,
+// and so are kept out of the rpc package.
+package serverplugin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/client/v3"
+	"../src"
+)
+
+// EtcdV3RegisterPlugin registers the services of a server in etcd with the v3 API,
+// where EtcdV3ClientSelector discovers them: a service is the key BasePath/name/ServiceAddress,
+// whose value is the metadata of the service, like "weight=2&state=active".
+//
+// The keys are attached to a lease which is kept alive while the server runs, so that they
+// are removed by etcd when the server stops without Close. A lease which is lost, because the
+// server was cut off etcd for longer than TTL, is granted again and the keys are put again.
+type EtcdV3RegisterPlugin struct {
+	//ServiceAddress is the server as selectors connect to it, like "tcp@127.0.0.1:8972"
+	ServiceAddress string
+	EtcdServers    []string
+	//BasePath is the path the services are registered under, like "/rpct"
+	BasePath string
+	//TTL is the time to live of the lease, 10 seconds if zero
+	TTL time.Duration
+	//DialTimeout is the timeout of dialing etcd and of each request, 5 seconds if zero
+	DialTimeout time.Duration
+	//Logger logs the errors of keeping the services registered, src.DefaultLogger if nil
+	Logger src.Logger
+	//KV and Lease are the etcd client, created by Start from EtcdServers unless set before
+	KV    clientv3.KV
+	Lease clientv3.Lease
+
+	//etcd is the client which Start created, closed by Close
+	etcd *clientv3.Client
+
+	mu sync.Mutex
+	//services are the values of the registered keys
+	services map[string]string
+	leaseID  clientv3.LeaseID
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// Start connects to etcd, grants the lease and registers the services registered so far.
+// It must be called before the server is started.
+func (plugin *EtcdV3RegisterPlugin) Start() error {
+	if plugin.KV == nil || plugin.Lease == nil {
+		cli, err := clientv3.New(clientv3.Config{
+			Endpoints:   plugin.EtcdServers,
+			DialTimeout: plugin.timeout(),
+		})
+		if err != nil {
+			return err
+		}
+		plugin.etcd = cli
+		plugin.KV = cli
+		plugin.Lease = cli
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	id, err := plugin.grant(ctx)
+	if err != nil {
+		cancel()
+		if plugin.etcd != nil {
+			// Close returns early as the plugin is not started, so the client is closed here
+			plugin.etcd.Close()
+			plugin.etcd, plugin.KV, plugin.Lease = nil, nil, nil
+		}
+		return err
+	}
+
+	plugin.mu.Lock()
+	plugin.cancel = cancel
+	plugin.done = make(chan struct{})
+	plugin.mu.Unlock()
+
+	go plugin.keepAlive(ctx, id)
+	return nil
+}
+
+func (plugin *EtcdV3RegisterPlugin) timeout() time.Duration {
+	if plugin.DialTimeout > 0 {
+		return plugin.DialTimeout
+	}
+	return 5 * time.Second
+}
+
+func (plugin *EtcdV3RegisterPlugin) ttl() int64 {
+	if ttl := int64(plugin.TTL / time.Second); ttl > 0 {
+		return ttl
+	}
+	return 10
+}
+
+func (plugin *EtcdV3RegisterPlugin) logger() src.Logger {
+	return src.LoggerOr(plugin.Logger)
+}
+
+// grant grants a new lease and puts the registered keys with it.
+func (plugin *EtcdV3RegisterPlugin) grant(ctx context.Context) (clientv3.LeaseID, error) {
+	gctx, cancel := context.WithTimeout(ctx, plugin.timeout())
+	resp, err := plugin.Lease.Grant(gctx, plugin.ttl())
+	cancel()
+	if err != nil {
+		return 0, err
+	}
+
+	plugin.mu.Lock()
+	defer plugin.mu.Unlock()
+	plugin.leaseID = resp.ID
+	for key, value := range plugin.services {
+		if err = plugin.put(ctx, key, value); err != nil {
+			// the keys put already expire with the lease
+			plugin.leaseID = 0
+			return 0, err
+		}
+	}
+	return resp.ID, nil
+}
+
+// put puts key with the lease. The caller holds mu.
+func (plugin *EtcdV3RegisterPlugin) put(ctx context.Context, key, value string) error {
+	pctx, cancel := context.WithTimeout(ctx, plugin.timeout())
+	defer cancel()
+	_, err := plugin.KV.Put(pctx, key, value, clientv3.WithLease(plugin.leaseID))
+	return err
+}
+
+// keepAlive keeps the lease id alive until ctx is done, granting a new one when it is lost.
+func (plugin *EtcdV3RegisterPlugin) keepAlive(ctx context.Context, id clientv3.LeaseID) {
+	defer close(plugin.done)
+
+	delay := time.Second
+	for {
+		ch, err := plugin.Lease.KeepAlive(ctx, id)
+		if err == nil {
+			delay = time.Second
+			for range ch {
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		plugin.logger().Warn("lease of services in etcd lost, granting a new one", "path", plugin.BasePath, "lease", int64(id), "err", err)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			if id, err = plugin.grant(ctx); err == nil {
+				break
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			plugin.logger().Warn("granting lease of services in etcd", "path", plugin.BasePath, "err", err)
+			if delay < time.Minute {
+				delay *= 2
+			}
+		}
+	}
+}
+
+func (plugin *EtcdV3RegisterPlugin) key(name string) string {
+	return strings.TrimSuffix(plugin.BasePath, "/") + "/" + name + "/" + plugin.ServiceAddress
+}
+
+// Register registers the service name with metadata, put in etcd as soon as the plugin is started.
+func (plugin *EtcdV3RegisterPlugin) Register(name string, rcvr interface{}, metadata ...string) error {
+	if name == "" {
+		return errors.New("service name can't be empty")
+	}
+
+	key, value := plugin.key(name), strings.Join(metadata, "&")
+
+	plugin.mu.Lock()
+	defer plugin.mu.Unlock()
+	if plugin.services == nil {
+		plugin.services = make(map[string]string)
+	}
+	plugin.services[key] = value
+	if plugin.leaseID == 0 {
+		return nil
+	}
+	return plugin.put(context.Background(), key, value)
+}
+
+// Unregister deletes the service name from etcd.
+func (plugin *EtcdV3RegisterPlugin) Unregister(name string) error {
+	key := plugin.key(name)
+
+	plugin.mu.Lock()
+	defer plugin.mu.Unlock()
+	delete(plugin.services, key)
+	if plugin.KV == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), plugin.timeout())
+	defer cancel()
+	_, err := plugin.KV.Delete(ctx, key)
+	return err
+}
+
+// Close stops keeping the lease alive and revokes it, which deletes the services from etcd,
+// and closes the etcd client which Start created.
+func (plugin *EtcdV3RegisterPlugin) Close() error {
+	plugin.mu.Lock()
+	cancel, done := plugin.cancel, plugin.done
+	plugin.cancel = nil
+	plugin.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	<-done
+
+	plugin.mu.Lock()
+	id := plugin.leaseID
+	plugin.leaseID = 0
+	plugin.mu.Unlock()
+
+	var err error
+	if id != 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), plugin.timeout())
+		_, err = plugin.Lease.Revoke(ctx, id)
+		cancel()
+	}
+	if plugin.etcd != nil {
+		if cerr := plugin.etcd.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Name return name of this plugin.
+func (plugin *EtcdV3RegisterPlugin) Name() string {
+	return "EtcdV3RegisterPlugin"
+}
+
+// Description return description of this plugin.
+func (plugin *EtcdV3RegisterPlugin) Description() string {
+	return "a register plugin which registers services in etcd with the v3 API, under a lease kept alive"
+}
@@ -0,0 +1,112 @@
+package serverplugin
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"../rpctest"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// registered returns the keys under /rpct/ with their values, and the leases they are attached to.
+func registered(t *testing.T, etcd *rpctest.EtcdV3Server) (map[string]string, map[clientv3.LeaseID]bool) {
+	resp, err := etcd.Get(context.Background(), "/rpct/", clientv3.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, leases := make(map[string]string), make(map[clientv3.LeaseID]bool)
+	for _, kv := range resp.Kvs {
+		keys[string(kv.Key)] = string(kv.Value)
+		leases[clientv3.LeaseID(kv.Lease)] = true
+	}
+	return keys, leases
+}
+
+func waitRegistered(t *testing.T, etcd *rpctest.EtcdV3Server, want map[string]string) map[clientv3.LeaseID]bool {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		keys, leases := registered(t, etcd)
+		if len(keys) == len(want) && (len(want) == 0 || reflect.DeepEqual(keys, want)) {
+			return leases
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("registered %v, want %v", keys, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestEtcdV3RegisterPlugin(t *testing.T) {
+	etcd := rpctest.NewEtcdV3Server()
+	defer etcd.Close()
+	plugin := &EtcdV3RegisterPlugin{ServiceAddress: "tcp@127.0.0.1:8972", BasePath: "/rpct/", KV: etcd, Lease: etcd}
+
+	if err := plugin.Register("Arith", nil, "weight=2", "state=active"); err != nil {
+		t.Fatal(err)
+	}
+	waitRegistered(t, etcd, nil)
+	if err := plugin.Start(); err != nil {
+		t.Fatal(err)
+	}
+	arith := map[string]string{"/rpct/Arith/tcp@127.0.0.1:8972": "weight=2&state=active"}
+	waitRegistered(t, etcd, arith)
+
+	if err := plugin.Register("Echo", nil); err != nil {
+		t.Fatal(err)
+	}
+	both := map[string]string{"/rpct/Arith/tcp@127.0.0.1:8972": "weight=2&state=active", "/rpct/Echo/tcp@127.0.0.1:8972": ""}
+	leases := waitRegistered(t, etcd, both)
+	if len(leases) != 1 || leases[0] {
+		t.Fatalf("services attached to leases %v, want one lease", leases)
+	}
+
+	// a lost lease is granted again, and the services are put with the new one
+	for lease := range leases {
+		etcd.ExpireLease(lease)
+	}
+	renewed := waitRegistered(t, etcd, both)
+	if reflect.DeepEqual(renewed, leases) || len(renewed) != 1 {
+		t.Fatalf("services attached to leases %v after %v expired", renewed, leases)
+	}
+
+	if err := plugin.Unregister("Echo"); err != nil {
+		t.Fatal(err)
+	}
+	waitRegistered(t, etcd, arith)
+
+	if err := plugin.Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitRegistered(t, etcd, nil)
+	if resp, _ := etcd.Leases(context.Background()); len(resp.Leases) != 0 {
+		t.Fatalf("leases %v not revoked by Close", resp.Leases)
+	}
+}
+
+func TestEtcdV3RegisterPluginStartFails(t *testing.T) {
+	etcd := rpctest.NewEtcdV3Server()
+	defer etcd.Close()
+	etcd.SetAvailable(false)
+	plugin := &EtcdV3RegisterPlugin{ServiceAddress: "tcp@127.0.0.1:8972", BasePath: "/rpct", KV: etcd, Lease: etcd}
+	plugin.Register("Arith", nil)
+
+	if err := plugin.Start(); err != rpctest.ErrEtcdV3Unavailable {
+		t.Fatalf("Start returned %v", err)
+	}
+	if plugin.KV == nil || plugin.Lease == nil {
+		t.Fatal("client which was set before Start dropped")
+	}
+	if err := plugin.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	etcd.SetAvailable(true)
+	if err := plugin.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer plugin.Close()
+	waitRegistered(t, etcd, map[string]string{"/rpct/Arith/tcp@127.0.0.1:8972": ""})
+}
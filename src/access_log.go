@@ -0,0 +1,299 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLogFormat is the format of the records of an AccessLogPlugin.
+type AccessLogFormat int
+
+const (
+	//AccessLogJSON writes a JSON object per line
+	AccessLogJSON AccessLogFormat = iota
+	//AccessLogLogfmt writes key=value pairs per line
+	AccessLogLogfmt
+)
+
+// redactedValue replaces the fields tagged `rpct:"redact"` in logged args and replies.
+const redactedValue = "[REDACTED]"
+
+// maxRedactDepth bounds how deep args are walked, which also stops cycles.
+const maxRedactDepth = 16
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// accessLogRecord is a record of AccessLogPlugin, in the order of its fields.
+type accessLogRecord struct {
+	Time          time.Time   `json:"time"`
+	Peer          string      `json:"peer"`
+	ServiceMethod string      `json:"method"`
+	Tag           string      `json:"tag,omitempty"`
+	RequestSize   int64       `json:"request_size"`
+	ResponseSize  int64       `json:"response_size"`
+	Duration      float64     `json:"duration_ms"`
+	Code          string      `json:"code"`
+	Error         string      `json:"error,omitempty"`
+	TraceID       string      `json:"trace_id,omitempty"`
+	Slow          bool        `json:"slow,omitempty"`
+	Args          interface{} `json:"args,omitempty"`
+	Reply         interface{} `json:"reply,omitempty"`
+}
+
+// AccessLogPlugin writes a record per call handled by a Server: the time, the address of the client,
+// the service method, the authorization tag, the sizes of the request and the response, the duration,
+// the ErrorCode of the error and the trace ID, if a TracingServerPlugin comes before it.
+// The sizes are the bytes the codec read from and wrote to the connection for the call. Codecs read
+// ahead, so requests which arrive together are counted in the first of them which is read.
+// It should be added first, so that the calls rejected by other plugins are timed too.
+type AccessLogPlugin struct {
+	Out    io.Writer
+	Format AccessLogFormat
+	//SampleRate is the share of calls which are logged, all of them if zero. Failed and slow calls are always logged
+	SampleRate float64
+	//SlowThreshold marks the calls which take longer as slow, if not zero
+	SlowThreshold time.Duration
+	//LogArgs adds the args and the reply to records, with the struct fields tagged `rpct:"redact"` redacted.
+	//Values which encode themselves to JSON, like time.Time, are logged as they encode, or as
+	//redactedValue whole if their type has fields tagged `rpct:"redact"`.
+	LogArgs bool
+
+	mu sync.Mutex // serializes writes to Out
+}
+
+// NewAccessLogPlugin creates an AccessLogPlugin which writes all calls to out in format.
+func NewAccessLogPlugin(out io.Writer, format AccessLogFormat) *AccessLogPlugin {
+	return &AccessLogPlugin{Out: out, Format: format}
+}
+
+type accessLogKey struct{ plugin *AccessLogPlugin }
+
+// PreCall records the start of a call.
+func (plugin *AccessLogPlugin) PreCall(ctx context.Context, info *CallInfo) (context.Context, error) {
+	return context.WithValue(ctx, accessLogKey{plugin}, time.Now()), nil
+}
+
+// PostCall writes the record of a call.
+func (plugin *AccessLogPlugin) PostCall(ctx context.Context, info *CallInfo, err error) {
+	now := time.Now()
+	var d time.Duration
+	if start, ok := ctx.Value(accessLogKey{plugin}).(time.Time); ok {
+		d = now.Sub(start)
+	}
+	slow := plugin.SlowThreshold > 0 && d > plugin.SlowThreshold
+	if err == nil && !slow && plugin.SampleRate > 0 && plugin.SampleRate < 1 && rand.Float64() >= plugin.SampleRate {
+		return
+	}
+
+	record := accessLogRecord{
+		Time:          now.UTC(),
+		Peer:          info.Peer,
+		ServiceMethod: info.ServiceMethod,
+		Duration:      float64(d) / float64(time.Millisecond),
+		Code:          ErrorCode(err),
+		Slow:          slow,
+	}
+	if size, ok := ctx.Value(wireSizeKey{}).(*wireSize); ok {
+		record.RequestSize, record.ResponseSize = size.request, size.response
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if a, ok := AuthorizationFromContext(ctx); ok {
+		record.Tag = a.Tag
+	}
+	if span, ok := SpanFromContext(ctx); ok {
+		record.TraceID = span.TraceID.String()
+	} else if md, ok := MetadataFromContext(ctx); ok {
+		if traceID, _, ok := parseTraceparent(md[traceparentKey]); ok {
+			record.TraceID = traceID.String()
+		}
+	}
+	if plugin.LogArgs {
+		record.Args = redact(reflect.ValueOf(info.Args), 0)
+		if err == nil {
+			record.Reply = redact(reflect.ValueOf(info.Reply), 0)
+		}
+	}
+
+	var line []byte
+	if plugin.Format == AccessLogLogfmt {
+		line = record.logfmt()
+	} else {
+		var merr error
+		if line, merr = json.Marshal(record); merr != nil {
+			return
+		}
+	}
+
+	plugin.mu.Lock()
+	plugin.Out.Write(append(line, '\n'))
+	plugin.mu.Unlock()
+}
+
+// redact returns v with the struct fields tagged `rpct:"redact"` replaced by redactedValue,
+// as values which encode to JSON like v does.
+func redact(v reflect.Value, depth int) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if depth > maxRedactDepth {
+		return "..."
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return redact(v.Elem(), depth+1)
+	case reflect.Struct:
+		if v.Type().Implements(jsonMarshalerType) || reflect.PtrTo(v.Type()).Implements(jsonMarshalerType) {
+			// like time.Time, which encodes itself, so its fields cannot be redacted one by one
+			if hasRedactedFields(v.Type(), 0) {
+				return redactedValue
+			}
+			return v.Interface()
+		}
+		t := v.Type()
+		fields := make(map[string]interface{}, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			name := f.Name
+			if tag := f.Tag.Get("json"); tag != "" {
+				if tag == "-" {
+					continue
+				}
+				if n := strings.Split(tag, ",")[0]; n != "" {
+					name = n
+				}
+			}
+			if f.Tag.Get("rpct") == "redact" {
+				fields[name] = redactedValue
+				continue
+			}
+			fields[name] = redact(v.Field(i), depth+1)
+		}
+		return fields
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && (v.IsNil() || v.Type().Elem().Kind() == reflect.Uint8) {
+			// []byte is encoded as base64, like encoding/json does
+			return v.Interface()
+		}
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = redact(v.Index(i), depth+1)
+		}
+		return items
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		entries := make(map[string]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			entries[mapKeyString(k)] = redact(v.MapIndex(k), depth+1)
+		}
+		return entries
+	}
+	return v.Interface()
+}
+
+// hasRedactedFields reports whether values of t may hold struct fields tagged `rpct:"redact"`.
+func hasRedactedFields(t reflect.Type, depth int) bool {
+	if depth > maxRedactDepth {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return hasRedactedFields(t.Elem(), depth+1)
+	case reflect.Map:
+		return hasRedactedFields(t.Key(), depth+1) || hasRedactedFields(t.Elem(), depth+1)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Tag.Get("rpct") == "redact" || hasRedactedFields(f.Type, depth+1) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func mapKeyString(k reflect.Value) string {
+	switch k.Kind() {
+	case reflect.String:
+		return k.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(k.Uint(), 10)
+	}
+	data, _ := json.Marshal(k.Interface())
+	return string(data)
+}
+
+// logfmt encodes the record as logfmt, with the args and the reply encoded as JSON.
+func (r *accessLogRecord) logfmt() []byte {
+	b := make([]byte, 0, 256)
+	add := func(key, value string) {
+		if len(b) > 0 {
+			b = append(b, ' ')
+		}
+		b = append(b, key...)
+		b = append(b, '=')
+		if value == "" || strings.ContainsAny(value, " =\"\\\n\t") {
+			b = strconv.AppendQuote(b, value)
+		} else {
+			b = append(b, value...)
+		}
+	}
+
+	add("time", r.Time.Format(time.RFC3339Nano))
+	add("peer", r.Peer)
+	add("method", r.ServiceMethod)
+	if r.Tag != "" {
+		add("tag", r.Tag)
+	}
+	add("request_size", strconv.FormatInt(r.RequestSize, 10))
+	add("response_size", strconv.FormatInt(r.ResponseSize, 10))
+	add("duration_ms", strconv.FormatFloat(r.Duration, 'f', 3, 64))
+	add("code", r.Code)
+	if r.Error != "" {
+		add("error", r.Error)
+	}
+	if r.TraceID != "" {
+		add("trace_id", r.TraceID)
+	}
+	if r.Slow {
+		add("slow", "true")
+	}
+	if r.Args != nil {
+		data, _ := json.Marshal(r.Args)
+		add("args", string(data))
+	}
+	if r.Reply != nil {
+		data, _ := json.Marshal(r.Reply)
+		add("reply", string(data))
+	}
+	return b
+}
+
+// Name return name of this plugin.
+func (plugin *AccessLogPlugin) Name() string {
+	return "AccessLogPlugin"
+}
+
+// Description return description of this plugin.
+func (plugin *AccessLogPlugin) Description() string {
+	return "an access log plugin which writes a record per call"
+}
@@ -0,0 +1,165 @@
+package src
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	//DefaultAdaptiveMinLimit is the least number of in-flight calls an AdaptiveLimiterPlugin allows
+	DefaultAdaptiveMinLimit = 4
+	//DefaultAdaptiveMaxLimit is the most number of in-flight calls an AdaptiveLimiterPlugin allows
+	DefaultAdaptiveMaxLimit = 1000
+)
+
+const (
+	// adaptiveLongWindow is the number of calls the long-term latency averages over
+	adaptiveLongWindow = 600
+	// adaptiveTolerance is how much the latency may grow above the long-term latency before the limit goes down
+	adaptiveTolerance = 1.5
+)
+
+// AdaptiveLimiterPlugin sheds the calls of a Server which are above a limit of in-flight calls,
+// with an UnavailableError. The limit follows the latency of the handlers with a gradient algorithm:
+// it goes down while the latency is above its long-term average, which means calls queue up,
+// and goes up by about its square root while the latency stays flat.
+type AdaptiveLimiterPlugin struct {
+	//MinLimit and MaxLimit bound the limit
+	MinLimit int
+	MaxLimit int
+	//Smoothing is the weight in (0, 1] of each change of the limit, 0.2 if zero
+	Smoothing float64
+
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+	longRTT  float64 // in seconds
+	samples  int
+	shed     uint64
+}
+
+// NewAdaptiveLimiterPlugin creates an AdaptiveLimiterPlugin which starts at initialLimit
+// and stays between DefaultAdaptiveMinLimit and DefaultAdaptiveMaxLimit.
+func NewAdaptiveLimiterPlugin(initialLimit int) *AdaptiveLimiterPlugin {
+	return &AdaptiveLimiterPlugin{
+		MinLimit: DefaultAdaptiveMinLimit,
+		MaxLimit: DefaultAdaptiveMaxLimit,
+		limit:    float64(initialLimit),
+	}
+}
+
+type adaptiveCall struct {
+	start    time.Time
+	inFlight int // when the call started
+}
+
+type adaptiveLimiterKey struct{ plugin *AdaptiveLimiterPlugin }
+
+// Limit returns the current limit of in-flight calls.
+func (plugin *AdaptiveLimiterPlugin) Limit() int {
+	plugin.mu.Lock()
+	defer plugin.mu.Unlock()
+	return int(plugin.clamp(plugin.limit))
+}
+
+// PreCall admits a call, or sheds it if the limit is reached.
+func (plugin *AdaptiveLimiterPlugin) PreCall(ctx context.Context, info *CallInfo) (context.Context, error) {
+	plugin.mu.Lock()
+	defer plugin.mu.Unlock()
+
+	limit := int(plugin.clamp(plugin.limit))
+	if plugin.inFlight >= limit {
+		plugin.shed++
+		return ctx, &UnavailableError{Reason: "server is over its limit of " + strconv.Itoa(limit) + " in-flight calls"}
+	}
+	plugin.inFlight++
+	return context.WithValue(ctx, adaptiveLimiterKey{plugin}, adaptiveCall{start: time.Now(), inFlight: plugin.inFlight}), nil
+}
+
+// PostCall updates the limit with the latency of a call.
+func (plugin *AdaptiveLimiterPlugin) PostCall(ctx context.Context, info *CallInfo, err error) {
+	call, ok := ctx.Value(adaptiveLimiterKey{plugin}).(adaptiveCall)
+	if !ok {
+		return
+	}
+	rtt := time.Since(call.start).Seconds()
+
+	plugin.mu.Lock()
+	defer plugin.mu.Unlock()
+
+	plugin.inFlight--
+	plugin.update(rtt, call.inFlight)
+}
+
+// update moves the limit after a call which took rtt seconds with inFlight calls running.
+func (plugin *AdaptiveLimiterPlugin) update(rtt float64, inFlight int) {
+	if rtt <= 0 {
+		rtt = 1e-9
+	}
+
+	plugin.samples++
+	if plugin.samples <= adaptiveLongWindow {
+		plugin.longRTT += (rtt - plugin.longRTT) / float64(plugin.samples)
+	} else {
+		plugin.longRTT += (rtt - plugin.longRTT) / adaptiveLongWindow
+	}
+	// the long-term latency catches up quickly once the load is gone
+	if plugin.longRTT > 2*rtt {
+		plugin.longRTT *= 0.95
+	}
+
+	limit := plugin.clamp(plugin.limit)
+	// the latency tells nothing about a limit which the calls are far from
+	if float64(inFlight) < limit/2 {
+		return
+	}
+
+	gradient := math.Max(0.5, math.Min(1, adaptiveTolerance*plugin.longRTT/rtt))
+	newLimit := limit*gradient + math.Sqrt(limit)
+
+	smoothing := plugin.Smoothing
+	if smoothing <= 0 || smoothing > 1 {
+		smoothing = 0.2
+	}
+	plugin.limit = plugin.clamp(limit*(1-smoothing) + newLimit*smoothing)
+}
+
+func (plugin *AdaptiveLimiterPlugin) clamp(limit float64) float64 {
+	if plugin.MaxLimit > 0 && limit > float64(plugin.MaxLimit) {
+		limit = float64(plugin.MaxLimit)
+	}
+	if limit < float64(plugin.MinLimit) {
+		limit = float64(plugin.MinLimit)
+	}
+	return math.Max(1, limit)
+}
+
+// writeMetrics writes the limit and the number of shed calls, see MetricsServerPlugin.
+func (plugin *AdaptiveLimiterPlugin) writeMetrics(w io.Writer) {
+	plugin.mu.Lock()
+	limit := int(plugin.clamp(plugin.limit))
+	shed := plugin.shed
+	plugin.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP rpct_server_concurrency_limit Limit of in-flight calls of the adaptive limiter.\n")
+	fmt.Fprintf(w, "# TYPE rpct_server_concurrency_limit gauge\n")
+	fmt.Fprintf(w, "rpct_server_concurrency_limit %d\n", limit)
+	fmt.Fprintf(w, "# HELP rpct_server_shed_total Calls shed by the adaptive limiter.\n")
+	fmt.Fprintf(w, "# TYPE rpct_server_shed_total counter\n")
+	fmt.Fprintf(w, "rpct_server_shed_total %d\n", shed)
+}
+
+// Name return name of this plugin.
+func (plugin *AdaptiveLimiterPlugin) Name() string {
+	return "AdaptiveLimiterPlugin"
+}
+
+// Description return description of this plugin.
+func (plugin *AdaptiveLimiterPlugin) Description() string {
+	return "a limiter plugin which sheds calls above a limit adapted to the latency"
+}
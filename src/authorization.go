@@ -2,6 +2,7 @@ package src
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"net/rpc"
 )
@@ -19,6 +20,23 @@ type AuthorizationAndServiceMethod struct {
 	Authorization string // Authorization
 	ServiceMethod string // real ServiceMethod name
 	Tag           string // extra tag for Authorization
+
+	principal *Principal // set by the server, never sent
+}
+
+// Principal returns the principal of the client proven by its certificate, if it connected over mutual TLS.
+// Servers set it before invoking AuthorizationFunc, if the plugins are in a ServerPluginContainer.
+func (p *AuthorizationAndServiceMethod) Principal() (*Principal, bool) {
+	return p.principal, p.principal != nil
+}
+
+type authorizationKey struct{}
+
+// AuthorizationFromContext returns the authorization which the client sent with the request
+// handled with ctx, if the server uses AuthorizationServerPlugin in a ServerPluginContainer.
+func AuthorizationFromContext(ctx context.Context) (*AuthorizationAndServiceMethod, bool) {
+	a, ok := ctx.Value(authorizationKey{}).(*AuthorizationAndServiceMethod)
+	return a, ok
 }
 
 func init() {
@@ -29,12 +47,23 @@ func init() {
 
 // PostReadRequestHeader extracts Authorization header from ServiceMethod field.
 func (plugin *AuthorizationServerPlugin) PostReadRequestHeader(r *rpc.Request) (err error) {
+	return plugin.postReadRequestHeader(r, nil)
+}
+
+// postReadRequestHeader extracts the authorization of a request into its state st, if not nil.
+func (plugin *AuthorizationServerPlugin) postReadRequestHeader(r *rpc.Request, st *requestState) (err error) {
 	b := bytes.NewBufferString(r.ServiceMethod)
 	var aAndS AuthorizationAndServiceMethod
 	dec := gob.NewDecoder(b)
 	err = dec.Decode(&aAndS)
 	if err == nil {
 		r.ServiceMethod = aAndS.ServiceMethod
+		if st != nil {
+			st.authorization = &aAndS
+			if st.conn != nil {
+				aAndS.principal, _ = PeerPrincipal(st.conn)
+			}
+		}
 
 		if plugin.AuthorizationFunc != nil {
 			err = plugin.AuthorizationFunc(&aAndS)
@@ -56,6 +85,8 @@ func (plugin *AuthorizationServerPlugin) Description() string {
 // AuthorizationClientPlugin is used to set Authorization info at client side.
 type AuthorizationClientPlugin struct {
 	AuthorizationAndServiceMethod *AuthorizationAndServiceMethod
+	//TokenSource supplies the Authorization of every request as a bearer token, if not nil
+	TokenSource TokenSource
 }
 
 // NewAuthorizationClientPlugin creates a AuthorizationClientPlugin with authorization header and tag
@@ -70,10 +101,20 @@ func NewAuthorizationClientPlugin(authorization, tag string) *AuthorizationClien
 
 // PreWriteRequest adds Authorization info in requests
 func (plugin *AuthorizationClientPlugin) PreWriteRequest(r *rpc.Request, body interface{}) error {
+	// requests of several connections may be written at once
+	aAndS := *plugin.AuthorizationAndServiceMethod
+	aAndS.ServiceMethod = r.ServiceMethod
+	if plugin.TokenSource != nil {
+		token, err := plugin.TokenSource.Token()
+		if err != nil {
+			return err
+		}
+		aAndS.Authorization = "Bearer " + token
+	}
+
 	var b bytes.Buffer
 	enc := gob.NewEncoder(&b)
-	plugin.AuthorizationAndServiceMethod.ServiceMethod = r.ServiceMethod
-	err := enc.Encode(plugin.AuthorizationAndServiceMethod)
+	err := enc.Encode(&aAndS)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,203 @@
+package src
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+//DefaultCertificateReloadInterval is how often a CertificateProvider checks for a new certificate
+const DefaultCertificateReloadInterval = time.Minute
+
+// CertificateProvider keeps a TLS certificate which is reloaded while servers and clients run,
+// so that rotated certificates are used by new handshakes without a restart.
+// Get the tls.Config of a server or a client from ServerConfig or ClientConfig.
+// It is also a plugin: added to the PluginContainer of a server or a client, MetricsServerPlugin
+// or MetricsClientPlugin serves the expiry time of the certificate.
+type CertificateProvider struct {
+	//Logger logs the errors of reloading the certificate, DefaultLogger if nil
+	Logger Logger
+
+	load      func() (*tls.Certificate, error)
+	certFile  string
+	keyFile   string
+	fileStamp string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	notAfter time.Time
+
+	done chan struct{}
+	once sync.Once
+}
+
+// NewFileCertificateProvider creates a CertificateProvider which loads the certificate in certFile and keyFile,
+// and reloads them once they have changed, checking every interval, DefaultCertificateReloadInterval if zero.
+func NewFileCertificateProvider(certFile, keyFile string, interval time.Duration) (*CertificateProvider, error) {
+	p := &CertificateProvider{certFile: certFile, keyFile: keyFile}
+	p.load = func() (*tls.Certificate, error) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		return &cert, err
+	}
+	p.fileStamp = p.stampFiles()
+	return p, p.start(interval)
+}
+
+// NewCertificateProvider creates a CertificateProvider which gets its certificate from load,
+// and calls it again every interval, DefaultCertificateReloadInterval if zero.
+func NewCertificateProvider(load func() (*tls.Certificate, error), interval time.Duration) (*CertificateProvider, error) {
+	p := &CertificateProvider{load: load}
+	return p, p.start(interval)
+}
+
+// stampFiles returns the sizes and modification times of the files, which change when they are replaced.
+func (p *CertificateProvider) stampFiles() string {
+	stamp := ""
+	for _, name := range []string{p.certFile, p.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return ""
+		}
+		stamp += fmt.Sprintf("%d@%d;", fi.Size(), fi.ModTime().UnixNano())
+	}
+	return stamp
+}
+
+func (p *CertificateProvider) start(interval time.Duration) error {
+	if err := p.Reload(); err != nil {
+		return err
+	}
+	if interval <= 0 {
+		interval = DefaultCertificateReloadInterval
+	}
+
+	p.done = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.done:
+				return
+			case <-ticker.C:
+				if err := p.reloadChanged(); err != nil {
+					LoggerOr(p.Logger).Error("reloading certificate", "err", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// reloadChanged reloads the certificate, if its files have changed when it is loaded from files.
+// The files are stamped only once they load, so that files which fail to, like a certificate
+// written before its key, are loaded again at the next check.
+func (p *CertificateProvider) reloadChanged() error {
+	if p.certFile == "" {
+		return p.Reload()
+	}
+	stamp := p.stampFiles()
+	if stamp == p.fileStamp {
+		return nil
+	}
+	if err := p.Reload(); err != nil {
+		return err
+	}
+	p.fileStamp = stamp
+	return nil
+}
+
+// Reload loads the certificate now. The current certificate is kept if loading fails.
+func (p *CertificateProvider) Reload() error {
+	cert, err := p.load()
+	if err != nil {
+		return err
+	}
+	if cert == nil || len(cert.Certificate) == 0 {
+		return errors.New("rpc: no certificate")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.cert = cert
+	p.notAfter = leaf.NotAfter
+	p.mu.Unlock()
+	return nil
+}
+
+// Certificate returns the current certificate.
+func (p *CertificateProvider) Certificate() *tls.Certificate {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.cert
+}
+
+// NotAfter returns the time the current certificate expires.
+func (p *CertificateProvider) NotAfter() time.Time {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.notAfter
+}
+
+// GetCertificate returns the current certificate, for tls.Config.GetCertificate.
+func (p *CertificateProvider) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return p.Certificate(), nil
+}
+
+// GetClientCertificate returns the current certificate, for tls.Config.GetClientCertificate.
+func (p *CertificateProvider) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return p.Certificate(), nil
+}
+
+// ServerConfig returns a copy of base, which may be nil, which presents the current certificate.
+func (p *CertificateProvider) ServerConfig(base *tls.Config) *tls.Config {
+	config := &tls.Config{}
+	if base != nil {
+		config = base.Clone()
+	}
+	config.Certificates = nil
+	config.GetCertificate = p.GetCertificate
+	return config
+}
+
+// ClientConfig returns a copy of base, which may be nil, which presents the current certificate
+// to servers which require mutual TLS.
+func (p *CertificateProvider) ClientConfig(base *tls.Config) *tls.Config {
+	config := &tls.Config{}
+	if base != nil {
+		config = base.Clone()
+	}
+	config.Certificates = nil
+	config.GetClientCertificate = p.GetClientCertificate
+	return config
+}
+
+// Close stops reloading the certificate.
+func (p *CertificateProvider) Close() {
+	p.once.Do(func() { close(p.done) })
+}
+
+// writeMetrics writes the expiry time of the certificate, see MetricsServerPlugin and MetricsClientPlugin.
+func (p *CertificateProvider) writeMetrics(w io.Writer) {
+	fmt.Fprintf(w, "# HELP rpct_tls_certificate_expiry_timestamp_seconds Expiry time of the TLS certificate.\n")
+	fmt.Fprintf(w, "# TYPE rpct_tls_certificate_expiry_timestamp_seconds gauge\n")
+	fmt.Fprintf(w, "rpct_tls_certificate_expiry_timestamp_seconds %d\n", p.NotAfter().Unix())
+}
+
+// Name return name of this plugin.
+func (p *CertificateProvider) Name() string {
+	return "CertificateProvider"
+}
+
+// Description return description of this plugin.
+func (p *CertificateProvider) Description() string {
+	return "a TLS certificate provider which reloads rotated certificates"
+}
@@ -0,0 +1,108 @@
+package src
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a certificate made from template, signed by parent with parentKey
+// or self-signed if parent is nil, to dir/name.crt and its key to dir/name.key.
+func writeTestCert(t *testing.T, dir, name string, template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for file, block := range map[string]*pem.Block{
+		name + ".crt": {Type: "CERTIFICATE", Bytes: der},
+		name + ".key": {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err := os.WriteFile(filepath.Join(dir, file), pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cert, key
+}
+
+func testCertTemplate(serial int64, commonName string) *x509.Certificate {
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+	}
+}
+
+func TestCertificateProviderReloadFails(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	writeTestCert(t, dir, "server", testCertTemplate(1, "one"), nil, nil)
+	p, err := NewFileCertificateProvider(certFile, keyFile, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	commonName := func() string {
+		leaf, err := x509.ParseCertificate(p.Certificate().Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.Subject.CommonName
+	}
+
+	writeTestCert(t, dir, "server", testCertTemplate(2, "two"), nil, nil)
+	later := time.Now().Add(time.Minute)
+	for _, name := range []string{certFile, keyFile} {
+		if err := os.Chtimes(name, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	load := p.load
+	p.load = func() (*tls.Certificate, error) { return nil, errors.New("key not written yet") }
+	if err := p.reloadChanged(); err == nil {
+		t.Fatal("failing reload succeeded")
+	}
+	if cn := commonName(); cn != "one" {
+		t.Fatalf("certificate %q replaced by a failing reload", cn)
+	}
+
+	// the files have not changed since, but failed to load
+	p.load = load
+	if err := p.reloadChanged(); err != nil {
+		t.Fatal(err)
+	}
+	if cn := commonName(); cn != "two" {
+		t.Fatalf("certificate %q not reloaded after a failed reload", cn)
+	}
+	if stamp := p.stampFiles(); p.fileStamp != stamp {
+		t.Fatalf("stamp %q of reloaded files, want %q", p.fileStamp, stamp)
+	}
+}
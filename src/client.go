@@ -2,12 +2,16 @@ package src
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
 	"errors"
 	"io"
 	"net"
 	"net/http"
 	"net/rpc"
+	"reflect"
+	"strings"
+	"sync"
 	"time"
 
 	msgpackrpc "github.com/hashicorp/net-rpc-msgpackrpc"
@@ -40,7 +44,7 @@ func (s SelectMode) String() string {
 type FailMode int
 
 const (
-	//Failover selects another server automaticaly
+	//Failover selects another server automaticaly, and avoids a server which shed the call with an UnavailableError
 	Failover FailMode = iota
 	//Failfast returns error immediately
 	Failfast
@@ -63,6 +67,13 @@ type ClientSelector interface {
 	AllClients(clientCodecFunc ClientCodecFunc) []*rpc.Client
 }
 
+//FailedServerHandler is implemented by ClientSelectors which want to know about failed servers,
+//like the selectors of clientselector, which lower their weight for WeightedRoundRobin.
+type FailedServerHandler interface {
+	//HandleFailedServer is invoked when a server stops answering heartbeats.
+	HandleFailedServer(network, address string)
+}
+
 // DirectClientSelector is used to a direct rpc server.
 // It don't select a node from service cluster but a specific rpc server.
 type DirectClientSelector struct {
@@ -96,12 +107,19 @@ func NewDirectRPCClient(c *Client, clientCodecFunc ClientCodecFunc, network, add
 	if network == "http" {
 		return NewDirectHTTPRPCClient(c, clientCodecFunc, network, address, "", timeout)
 	}
+	if c != nil && c.PluginContainer != nil {
+		if rpcClient := c.pool.get(network, address, c.MaxConcurrentStreams); rpcClient != nil {
+			return rpcClient, nil
+		}
+	}
 
 	var conn net.Conn
 	var tlsConn *tls.Conn
 	var err error
 
-	if c != nil && c.TLSConfig != nil {
+	if network == MemoryNetwork {
+		conn, err = dialMemory(c, address, timeout)
+	} else if c != nil && c.TLSConfig != nil {
 		dialer := &net.Dialer{
 			Timeout: timeout,
 		}
@@ -120,13 +138,7 @@ func NewDirectRPCClient(c *Client, clientCodecFunc ClientCodecFunc, network, add
 	if c == nil || c.PluginContainer == nil {
 		return rpc.NewClientWithCodec(clientCodecFunc(conn)), nil
 	}
-
-	wrapper := newClientCodecWrapper(c.PluginContainer, clientCodecFunc(conn), conn)
-	wrapper.Timeout = c.Timeout
-	wrapper.ReadTimeout = c.ReadTimeout
-	wrapper.WriteTimeout = c.WriteTimeout
-
-	return rpc.NewClientWithCodec(wrapper), nil
+	return c.newRPCClient(clientCodecFunc, conn, network, address)
 }
 
 // NewDirectHTTPRPCClient creates a rpc http client
@@ -134,6 +146,11 @@ func NewDirectHTTPRPCClient(c *Client, clientCodecFunc ClientCodecFunc, network,
 	if path == "" {
 		path = rpc.DefaultRPCPath
 	}
+	if c != nil && c.PluginContainer != nil {
+		if rpcClient := c.pool.get(network, address, c.MaxConcurrentStreams); rpcClient != nil {
+			return rpcClient, nil
+		}
+	}
 
 	var conn net.Conn
 	var tlsConn *tls.Conn
@@ -163,12 +180,7 @@ func NewDirectHTTPRPCClient(c *Client, clientCodecFunc ClientCodecFunc, network,
 		if c == nil || c.PluginContainer == nil {
 			return rpc.NewClientWithCodec(clientCodecFunc(conn)), nil
 		}
-		wrapper := newClientCodecWrapper(c.PluginContainer, clientCodecFunc(conn), conn)
-		wrapper.Timeout = c.Timeout
-		wrapper.ReadTimeout = c.ReadTimeout
-		wrapper.WriteTimeout = c.WriteTimeout
-
-		return rpc.NewClientWithCodec(wrapper), nil
+		return c.newRPCClient(clientCodecFunc, conn, network, address)
 	}
 	if err == nil {
 		err = errors.New("unexpected HTTP response: " + resp.Status)
@@ -182,6 +194,35 @@ func NewDirectHTTPRPCClient(c *Client, clientCodecFunc ClientCodecFunc, network,
 	}
 }
 
+// newRPCClient creates a rpc client of c over conn and adds it to the pool of c.
+func (c *Client) newRPCClient(clientCodecFunc ClientCodecFunc, conn net.Conn, network, address string) (*rpc.Client, error) {
+	codecConn := conn
+	var mux *muxConn
+	if c.Multiplex {
+		if _, err := io.WriteString(conn, muxPreface); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		mux = newMuxConn(conn)
+		codecConn = mux
+	}
+
+	wrapper := newClientCodecWrapper(c.PluginContainer, clientCodecFunc(codecConn), conn)
+	wrapper.Timeout = c.Timeout
+	wrapper.ReadTimeout = c.ReadTimeout
+	wrapper.WriteTimeout = c.WriteTimeout
+	wrapper.handlers = c.handlers
+	wrapper.mux = mux
+	wrapper.codecFunc = clientCodecFunc
+
+	rpcClient := rpc.NewClientWithCodec(wrapper)
+	if c.Heartbeat > 0 {
+		go c.heartbeat(rpcClient, network, address)
+	}
+	c.pool.put(network, address, rpcClient, wrapper)
+	return rpcClient, nil
+}
+
 // ClientCodecFunc is used to create a rpc.ClientCodecFunc from net.Conn.
 type ClientCodecFunc func(conn io.ReadWriteCloser) rpc.ClientCodec
 
@@ -201,11 +242,35 @@ type Client struct {
 	ReadTimeout time.Duration
 	//Timeout sets writedeadline for underlying net.Conns
 	WriteTimeout time.Duration
+	//StreamWindow is the number of items a streaming call buffers for Recv
+	StreamWindow int
+	//Heartbeat is the interval of pings sent over idle and busy connections alike. Zero disables them.
+	Heartbeat time.Duration
+	//HeartbeatMisses is the number of unanswered pings after which a connection is closed
+	//and the selector is told that the server has failed.
+	HeartbeatMisses int
+	//Multiplex interleaves the frames of concurrent calls on each connection, so that a large
+	//message does not hold back the others. The codec must encode every message on its own like msgpack does, gob does not.
+	Multiplex bool
+	//MaxConcurrentStreams is the number of calls in flight on a connection at which the next call
+	//opens another connection to the same server. Zero means one connection per server.
+	MaxConcurrentStreams int
+	//Interceptors wrap every call but Broadcast and Forking ones, the first one outermost.
+	//They run inside the call plugins, once per attempt of a failing call.
+	Interceptors []UnaryClientInterceptor
+	//Logger logs the errors of connections which calls do not return, DefaultLogger if nil.
+	//Selectors without a Logger of their own use it too.
+	Logger Logger
+	//pool keeps the connections to the servers
+	pool connPool
+	//handlers are the services which servers can push to or call
+	handlers *serviceMap
 }
 
 //NewClient create a client.
 func NewClient(s ClientSelector) *Client {
 	client := &Client{
+		handlers:        newServiceMap(),
 		PluginContainer: &ClientPluginContainer{plugins: make([]IPlugin, 0)},
 		ClientCodecFunc: msgpackrpc.NewClientCodec,
 		ClientSelector:  s,
@@ -227,11 +292,26 @@ func (c *Client) Close() error {
 		}
 	}
 
+	c.pool.close()
 	return nil
 }
 
+// logger returns the Logger of c.
+func (c *Client) logger() Logger {
+	return LoggerOr(c.Logger)
+}
+
 //Call invokes the named function, waits for it to complete, and returns its error status.
 func (c *Client) Call(serviceMethod string, args interface{}, reply interface{}) (err error) {
+	return c.CallContext(context.Background(), serviceMethod, args, reply)
+}
+
+//CallContext is like Call, and passes ctx to the call plugins, which may continue a trace from it.
+//The outgoing metadata of ctx, see NewOutgoingContext, is sent along with the request,
+//and so is its deadline, after which the context of the handler is cancelled.
+//CallContext returns ctx.Err() once ctx is done, without waiting for the response; reply must not be used then.
+//Broadcast and Forking calls run no call plugins and send no metadata.
+func (c *Client) CallContext(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) (err error) {
 	if c.FailMode == Broadcast {
 		return c.clientBroadCast(serviceMethod, args, reply)
 	}
@@ -243,22 +323,32 @@ func (c *Client) Call(serviceMethod string, args interface{}, reply interface{})
 	c.rpcClient = rpcClient
 
 	if err == nil && c.rpcClient != nil {
-		err = c.rpcClient.Call(serviceMethod, args, reply)
+		err = c.call(ctx, c.rpcClient, serviceMethod, args, reply)
 	}
 	if err != nil || c.rpcClient == nil {
 		if c.FailMode == Failover {
+			// a server which shed the call is only retried if the selector picks it twice in a row
+			var shedding *rpc.Client
+			if IsUnavailable(err) {
+				shedding = c.rpcClient
+			}
 			for retries := 0; retries < c.Retries; retries++ {
 				rpcClient, err := c.ClientSelector.Select(c.ClientCodecFunc, serviceMethod, args)
+				if err == nil && rpcClient != nil && rpcClient == shedding {
+					rpcClient, err = c.ClientSelector.Select(c.ClientCodecFunc, serviceMethod, args)
+				}
 				if err != nil || rpcClient == nil {
 					continue
 				}
-				c.Close()
 
 				c.rpcClient = rpcClient
-				err = c.rpcClient.Call(serviceMethod, args, reply)
+				err = c.call(ctx, c.rpcClient, serviceMethod, args, reply)
 				if err == nil {
 					return nil
 				}
+				if IsUnavailable(err) {
+					shedding = rpcClient
+				}
 			}
 		} else if c.FailMode == Failtry {
 			for retries := 0; retries < c.Retries; retries++ {
@@ -268,7 +358,7 @@ func (c *Client) Call(serviceMethod string, args interface{}, reply interface{})
 
 				}
 				if c.rpcClient != nil {
-					err = c.rpcClient.Call(serviceMethod, args, reply)
+					err = c.call(ctx, c.rpcClient, serviceMethod, args, reply)
 					if err == nil {
 						return nil
 					}
@@ -280,6 +370,74 @@ func (c *Client) Call(serviceMethod string, args interface{}, reply interface{})
 	return
 }
 
+// call invokes serviceMethod over rpcClient and runs the call plugins and the interceptors around it.
+func (c *Client) call(ctx context.Context, rpcClient *rpc.Client, serviceMethod string, args interface{}, reply interface{}) error {
+	invoker := func(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+		return invoke(ctx, rpcClient, serviceMethod, args, reply)
+	}
+	if len(c.Interceptors) > 0 {
+		invoker = chainClientInterceptors(c.Interceptors, invoker)
+	}
+	if c.PluginContainer == nil {
+		return invoker(ctx, serviceMethod, args, reply)
+	}
+
+	info := &CallInfo{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Peer:          c.pool.peer(rpcClient),
+	}
+	if s, ok := c.ClientSelector.(selectModeGetter); ok {
+		info.SelectMode = s.GetSelectMode().String()
+	}
+
+	ctx, err := containerPreCall(c.PluginContainer, ctx, info)
+	if err == nil {
+		err = invoker(ctx, serviceMethod, args, reply)
+	}
+	containerPostCall(c.PluginContainer, ctx, info, err)
+	return err
+}
+
+// invoke sends a call with the outgoing metadata and the deadline of ctx, and waits for its response.
+func invoke(ctx context.Context, rpcClient *rpc.Client, serviceMethod string, args interface{}, reply interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	md, _ := OutgoingMetadataFromContext(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		md = md.Copy()
+		md[timeoutKey] = time.Until(deadline).String()
+	}
+
+	// a response which arrives once ctx is done must not be decoded into reply,
+	// so it is decoded into a private value which is copied to reply on success
+	target := reflect.ValueOf(reply)
+	private := reply
+	copyReply := ctx.Done() != nil && target.Kind() == reflect.Ptr && !target.IsNil()
+	if copyReply {
+		private = reflect.New(target.Elem().Type()).Interface()
+	}
+
+	call := rpcClient.Go(serviceMethod, &outgoingCall{args: args, metadata: md}, private, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		if call.Error == nil && copyReply {
+			target.Elem().Set(reflect.ValueOf(private).Elem())
+		}
+		return typedServerError(call.Error)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// selectModeGetter is implemented by the selectors which have a SelectMode.
+type selectModeGetter interface {
+	GetSelectMode() SelectMode
+}
+
 func (c *Client) clientBroadCast(serviceMethod string, args interface{}, reply interface{}) (err error) {
 	if c.rpcClients == nil {
 		rpcClients := c.ClientSelector.AllClients(c.ClientCodecFunc)
@@ -345,19 +503,112 @@ func (c *Client) clientForking(serviceMethod string, args interface{}, reply int
 //The done channel will signal when the call is complete by returning the same Call object. If done is nil, Go will allocate a new channel. If non-nil, done must be buffered or Go will deliberately crash.
 func (c *Client) Go(serviceMethod string, args interface{}, reply interface{}, done chan *rpc.Call) *rpc.Call {
 	if c.rpcClient == nil {
-		rpcClient, _ := c.ClientSelector.Select(c.ClientCodecFunc)
+		rpcClient, err := c.ClientSelector.Select(c.ClientCodecFunc)
+		if err != nil {
+			c.logger().Warn("selecting server", "method", serviceMethod, "err", err)
+			return failedCall(serviceMethod, args, reply, done, err)
+		}
 		c.rpcClient = rpcClient
-
 	}
 	return c.rpcClient.Go(serviceMethod, args, reply, done)
 }
 
+// failedCall returns a Call which is done already with err, checking done as rpc.Client.Go does.
+func failedCall(serviceMethod string, args interface{}, reply interface{}, done chan *rpc.Call, err error) *rpc.Call {
+	if done == nil {
+		done = make(chan *rpc.Call, 1)
+	} else if cap(done) == 0 {
+		panic("rpc: done channel is unbuffered")
+	}
+	call := &rpc.Call{ServiceMethod: serviceMethod, Args: args, Reply: reply, Error: err, Done: done}
+	select {
+	case done <- call:
+	default:
+		// like rpc.Client, a full done channel is the caller's mistake and the call is not signalled
+	}
+	return call
+}
+
+//Send invokes the named function as a one-way call and returns as soon as the request is written.
+//The server runs the handler but never writes a response, so errors returned by the handler are lost.
+func (c *Client) Send(serviceMethod string, args interface{}) error {
+	if c.rpcClient == nil {
+		rpcClient, err := c.ClientSelector.Select(c.ClientCodecFunc, serviceMethod, args)
+		if err != nil {
+			return err
+		}
+		c.rpcClient = rpcClient
+	}
+
+	call := c.rpcClient.Go(serviceMethod, &outgoingCall{args: args, oneWay: true}, nil, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		return typedServerError(call.Error)
+	default:
+		return nil
+	}
+}
+
+//NewStream starts a streaming call of serviceMethod, see Stream for the forms of streaming methods.
+//args is only sent to server-streaming methods and reply is only filled by client-streaming methods,
+//once ClientStream.Wait returns. Pass nil for the ones the method does not take.
+func (c *Client) NewStream(serviceMethod string, args interface{}, reply interface{}) (*ClientStream, error) {
+	if c.rpcClient == nil {
+		rpcClient, err := c.ClientSelector.Select(c.ClientCodecFunc, serviceMethod, args)
+		if err != nil {
+			return nil, err
+		}
+		c.rpcClient = rpcClient
+	}
+
+	if args == nil {
+		args = invalidRequest
+	}
+	st := newStream(c.StreamWindow)
+	call := c.rpcClient.Go(serviceMethod, &outgoingCall{args: args, stream: st}, reply, make(chan *rpc.Call, 1))
+	return newClientStream(st, call), nil
+}
+
+// RegisterName publishes a set of methods which servers can invoke over the connections
+// of this client with ServerConn.Push and ServerConn.Call.
+// Methods must satisfy the same conditions as for Server.RegisterName.
+// It must be invoked before connections are made, whose codec must carry control frames, see ControlFrameCodec.
+func (c *Client) RegisterName(name string, rcvr interface{}) error {
+	if c.handlers == nil {
+		c.handlers = newServiceMap()
+	}
+	return c.handlers.register(name, rcvr)
+}
+
 // Auth sets Authorization info
 func (c *Client) Auth(authorization, tag string) error {
 	p := NewAuthorizationClientPlugin(authorization, tag)
 	return c.PluginContainer.Add(p)
 }
 
+// AuthTokenSource sets Authorization info to the bearer tokens of ts, like the JWTs which JWTAuthPlugin validates.
+// ts is asked for a token for every request, see RefreshingTokenSource.
+func (c *Client) AuthTokenSource(ts TokenSource, tag string) error {
+	p := NewAuthorizationClientPlugin("", tag)
+	p.TokenSource = ts
+	return c.PluginContainer.Add(p)
+}
+
+// outgoingCall is passed by Client as the body of a request when the call needs more
+// than its args, so that clientCodecWrapper can see how the request must be sent.
+type outgoingCall struct {
+	args     interface{}
+	oneWay   bool
+	stream   *Stream
+	metadata Metadata
+}
+
+// incomingResponse is a response header read by clientCodecWrapper.readLoop.
+type incomingResponse struct {
+	resp rpc.Response
+	err  error
+}
+
 type clientCodecWrapper struct {
 	rpc.ClientCodec
 	PluginContainer IClientPluginContainer
@@ -365,14 +616,121 @@ type clientCodecWrapper struct {
 	ReadTimeout     time.Duration
 	WriteTimeout    time.Duration
 	Conn            net.Conn
+
+	handlers *serviceMap
+	writeMu  sync.Mutex // serializes writes of requests and control frames
+
+	startOnce sync.Once
+	responses chan incomingResponse
+	bodies    chan interface{}
+	bodyErrs  chan error
+
+	mu        sync.Mutex
+	streams   map[uint64]*Stream  // streaming calls in progress by request seq
+	localSeqs []uint64            // requests which are answered locally
+	notify    chan struct{}       // signals a new entry in localSeqs
+	local     bool                // the header returned last is a local one
+	active    map[uint64]struct{} // seqs of the requests in flight
+	closed    bool                // the connection is broken or closed
+
+	mux *muxConn // the multiplexed connection, if any
+
+	codecFunc ClientCodecFunc // encodes the args of signed requests
 }
 
 // newClientCodecWrapper wraps a rpc.ServerCodec.
 func newClientCodecWrapper(pc IClientPluginContainer, c rpc.ClientCodec, conn net.Conn) *clientCodecWrapper {
-	return &clientCodecWrapper{ClientCodec: c, PluginContainer: pc, Conn: conn}
+	return &clientCodecWrapper{
+		ClientCodec:     c,
+		PluginContainer: pc,
+		Conn:            conn,
+		responses:       make(chan incomingResponse),
+		bodies:          make(chan interface{}),
+		bodyErrs:        make(chan error),
+		streams:         make(map[uint64]*Stream),
+		active:          make(map[uint64]struct{}),
+		notify:          make(chan struct{}, 1),
+	}
+}
+
+// readLoop reads responses from the underlying codec so that ReadResponseHeader
+// can also return the local responses of one-way requests while waiting for the peer.
+// Control frames sent by the server are handled here and never reach rpc.Client.
+func (w *clientCodecWrapper) readLoop() {
+	for {
+		var r rpc.Response
+		err := w.readResponseHeader(&r)
+		if err == nil && r.ServiceMethod == pingMethod {
+			// any answer to a ping proves that the server is alive
+			if err = w.ClientCodec.ReadResponseBody(nil); err == nil {
+				w.answerLocally(r.Seq)
+				continue
+			}
+		} else if err == nil && isServerControl(r.ServiceMethod) {
+			if err = w.readControl(&r); err == nil {
+				continue
+			}
+		}
+		if err == nil {
+			// the final response of a streaming call ends its stream
+			w.mu.Lock()
+			delete(w.streams, r.Seq)
+			w.mu.Unlock()
+		}
+		if err != nil {
+			w.mu.Lock()
+			w.closed = true
+			w.mu.Unlock()
+		}
+		w.responses <- incomingResponse{resp: r, err: err}
+		if err != nil {
+			return
+		}
+
+		w.bodyErrs <- w.readResponseBody(<-w.bodies)
+	}
+}
+
+func (w *clientCodecWrapper) ReadResponseHeader(r *rpc.Response) error {
+	w.startOnce.Do(func() { go w.readLoop() })
+
+	for {
+		w.mu.Lock()
+		if len(w.localSeqs) > 0 {
+			*r = rpc.Response{Seq: w.localSeqs[0]}
+			w.localSeqs = w.localSeqs[1:]
+			w.local = true
+			delete(w.active, r.Seq)
+			w.mu.Unlock()
+			return nil
+		}
+		w.mu.Unlock()
+
+		select {
+		case <-w.notify:
+		case in := <-w.responses:
+			*r = in.resp
+			if in.err == nil {
+				w.mu.Lock()
+				delete(w.active, r.Seq)
+				w.mu.Unlock()
+			}
+			return in.err
+		}
+	}
+}
+
+func (w *clientCodecWrapper) ReadResponseBody(body interface{}) error {
+	if w.local {
+		w.local = false
+		return nil
+	}
+
+	w.bodies <- body
+	return <-w.bodyErrs
 }
 
-func (w *clientCodecWrapper) ReadRequestHeader(r *rpc.Response) error {
+func (w *clientCodecWrapper) readResponseHeader(r *rpc.Response) error {
 	if w.Timeout > 0 {
 		w.Conn.SetDeadline(time.Now().Add(w.Timeout))
 	}
@@ -390,12 +748,107 @@ func (w *clientCodecWrapper) ReadRequestHeader(r *rpc.Response) error {
 	if err != nil {
 		return err
 	}
+	if isServerControl(r.ServiceMethod) {
+		return nil
+	}
+	if strings.HasPrefix(r.ServiceMethod, metadataPrefix) {
+		// echoed by codecs like jsonrpc
+		_, r.ServiceMethod, _ = splitMetadata(r.ServiceMethod)
+	}
 
 	//post
 	return w.PluginContainer.DoPostReadResponseHeader(r)
 }
 
-func (w *clientCodecWrapper) ReadRequestBody(body interface{}) error {
+// readControl handles a control frame sent by the server.
+func (w *clientCodecWrapper) readControl(r *rpc.Response) error {
+	switch {
+	case strings.HasPrefix(r.ServiceMethod, pushPrefix):
+		return w.serveCall(r.ServiceMethod[len(pushPrefix):], r.Seq, false)
+	case strings.HasPrefix(r.ServiceMethod, callPrefix):
+		return w.serveCall(r.ServiceMethod[len(callPrefix):], r.Seq, true)
+	}
+
+	w.mu.Lock()
+	st := w.streams[r.Seq]
+	w.mu.Unlock()
+	return readStreamControl(st, r.ServiceMethod, w.ClientCodec.ReadResponseBody)
+}
+
+// serveCall reads the args of a push or of a call from the server and runs the handler.
+func (w *clientCodecWrapper) serveCall(serviceMethod string, seq uint64, needReply bool) error {
+	var svc *service
+	var mtype *methodType
+	err := errors.New("rpc: can't find service " + serviceMethod)
+	if w.handlers != nil {
+		svc, mtype, err = w.handlers.lookup(serviceMethod)
+	}
+	if err == nil && mtype.stream != noStream {
+		err = errors.New("rpc: streaming is not supported for calls from the server: " + serviceMethod)
+	}
+	if err != nil {
+		if e := w.ClientCodec.ReadResponseBody(nil); e != nil {
+			return e
+		}
+		if needReply {
+			go w.writeReply(seq, nil, err.Error())
+		}
+		return nil
+	}
+
+	argv, argIsValue := mtype.newArgs()
+	if err = w.ClientCodec.ReadResponseBody(argv.Interface()); err != nil {
+		return err
+	}
+	if argIsValue {
+		argv = argv.Elem()
+	}
+	replyv := mtype.newReply()
+
+	go func() {
+		errmsg := ""
+		if err := svc.call(context.Background(), mtype, argv, replyv, nil); err != nil {
+			errmsg = err.Error()
+		}
+		if needReply {
+			w.writeReply(seq, replyv.Interface(), errmsg)
+		}
+	}()
+	return nil
+}
+
+// writeReply answers a call from the server.
+func (w *clientCodecWrapper) writeReply(seq uint64, reply interface{}, errmsg string) error {
+	if errmsg != "" {
+		reply = invalidRequest
+	}
+
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+	if w.WriteTimeout > 0 {
+		w.Conn.SetWriteDeadline(time.Now().Add(w.WriteTimeout))
+	}
+	return w.writeControl(&rpc.Request{ServiceMethod: replyPrefix + errmsg, Seq: seq}, reply)
+}
+
+// writeControl writes a control frame, bypassing plugins. The caller holds writeMu.
+func (w *clientCodecWrapper) writeControl(r *rpc.Request, body interface{}) error {
+	if w.WriteTimeout > 0 {
+		w.Conn.SetWriteDeadline(time.Now().Add(w.WriteTimeout))
+	}
+	return w.writeMessage(r, body)
+}
+
+// writeMessage writes a request with the codec as one message. The caller holds writeMu.
+func (w *clientCodecWrapper) writeMessage(r *rpc.Request, body interface{}) error {
+	err := w.ClientCodec.WriteRequest(r, body)
+	if w.mux != nil {
+		w.mux.endMessage(err != nil)
+	}
+	return err
+}
+
+func (w *clientCodecWrapper) readResponseBody(body interface{}) error {
 	//pre
 	err := w.PluginContainer.DoPreReadResponseBody(body)
 	if err != nil {
@@ -412,6 +865,13 @@ func (w *clientCodecWrapper) ReadRequestBody(body interface{}) error {
 }
 
 func (w *clientCodecWrapper) WriteRequest(r *rpc.Request, body interface{}) error {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+
+	if r.ServiceMethod == pingMethod {
+		return w.writeControl(r, body)
+	}
+
 	if w.Timeout > 0 {
 		w.Conn.SetDeadline(time.Now().Add(w.Timeout))
 	}
@@ -419,21 +879,126 @@ func (w *clientCodecWrapper) WriteRequest(r *rpc.Request, body interface{}) erro
 		w.Conn.SetWriteDeadline(time.Now().Add(w.WriteTimeout))
 	}
 
+	oneWay := false
+	var stream *Stream
+	var md Metadata
+	if call, ok := body.(*outgoingCall); ok {
+		body = call.args
+		oneWay = call.oneWay
+		stream = call.stream
+		md = call.metadata
+	}
+	if stream != nil && !carriesControlFrames(w.ClientCodec) {
+		return ErrNoControlFrames
+	}
+
 	//pre
 	err := w.PluginContainer.DoPreWriteRequest(r, body)
 	if err != nil {
 		return err
 	}
 
-	err = w.ClientCodec.WriteRequest(r, body)
+	// signers add to the metadata of the request
+	md = md.Copy()
+	wire, err := w.signRequest(r, md, body, oneWay)
+	if err != nil {
+		return err
+	}
+
+	r.ServiceMethod = withMetadata(r.ServiceMethod, md)
+	if oneWay {
+		r.ServiceMethod = oneWayPrefix + r.ServiceMethod
+	}
+	if stream != nil {
+		// items may follow as soon as the request is written
+		seq := r.Seq
+		stream.write = func(serviceMethod string, body interface{}) error {
+			w.writeMu.Lock()
+			defer w.writeMu.Unlock()
+			return w.writeControl(&rpc.Request{ServiceMethod: serviceMethod, Seq: seq}, body)
+		}
+		w.mu.Lock()
+		w.streams[seq] = stream
+		w.mu.Unlock()
+	}
+	err = w.writeMessage(r, wire)
 	if err != nil {
+		if stream != nil {
+			w.mu.Lock()
+			delete(w.streams, r.Seq)
+			w.mu.Unlock()
+		}
 		return err
 	}
+	w.mu.Lock()
+	w.active[r.Seq] = struct{}{}
+	w.mu.Unlock()
+
+	if oneWay {
+		// the server never answers, so the pending call is completed locally.
+		w.answerLocally(r.Seq)
+	}
 
 	//post
 	return w.PluginContainer.DoPostWriteRequest(r, body)
 }
 
+// signRequest invokes the request signers among the plugins with the request as it is sent.
+// A signed request sends its args as the bytes of their encoding, which are returned.
+func (w *clientCodecWrapper) signRequest(r *rpc.Request, md Metadata, body interface{}, oneWay bool) (interface{}, error) {
+	var signers []requestSigner
+	for _, p := range w.PluginContainer.GetAll() {
+		if signer, ok := p.(requestSigner); ok {
+			signers = append(signers, signer)
+		}
+	}
+	if len(signers) == 0 {
+		return body, nil
+	}
+
+	// nil args are sent as no bytes, whose digest is signed all the same
+	data := []byte{}
+	if body != nil {
+		var err error
+		if data, err = encodeBody(w.codecFunc, body); err != nil {
+			return nil, err
+		}
+	}
+	md[signatureDigestKey] = bodyDigest(data)
+	body = data
+	serviceMethod := r.ServiceMethod
+	if oneWay {
+		serviceMethod = oneWayPrefix + serviceMethod
+	}
+	for _, signer := range signers {
+		if err := signer.signRequest(serviceMethod, md); err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+// answerLocally makes ReadResponseHeader return a successful response for seq.
+func (w *clientCodecWrapper) answerLocally(seq uint64) {
+	w.mu.Lock()
+	w.localSeqs = append(w.localSeqs, seq)
+	w.mu.Unlock()
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// load returns the number of requests in flight and whether the connection is closed.
+func (w *clientCodecWrapper) load() (active int, closed bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.active), w.closed
+}
+
 func (w *clientCodecWrapper) Close() error {
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
 	return w.ClientCodec.Close()
 }
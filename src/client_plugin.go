@@ -1,6 +1,9 @@
 package src
 
-import "net/rpc"
+import (
+	"context"
+	"net/rpc"
+)
 
 // ClientPluginContainer implements IPluginContainer interface.
 type ClientPluginContainer struct {
@@ -159,6 +162,16 @@ func (p *ClientPluginContainer) DoPostWriteRequest(r *rpc.Request, body interfac
 	return nil
 }
 
+// DoPreCall invokes IPreCallPlugin plugins.
+func (p *ClientPluginContainer) DoPreCall(ctx context.Context, info *CallInfo) (context.Context, error) {
+	return doPreCall(p.plugins, ctx, info)
+}
+
+// DoPostCall invokes IPostCallPlugin plugins.
+func (p *ClientPluginContainer) DoPostCall(ctx context.Context, info *CallInfo, err error) {
+	doPostCall(p.plugins, ctx, info, err)
+}
+
 type (
 
 	//IPreReadResponseHeaderPlugin represents .
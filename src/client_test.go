@@ -0,0 +1,23 @@
+package src
+
+import (
+	"net/rpc"
+	"testing"
+)
+
+func TestClientGoSelectFails(t *testing.T) {
+	c := NewClient(NewMemoryClientSelector("client-test-nowhere"))
+	defer c.Close()
+
+	for _, done := range []chan *rpc.Call{nil, make(chan *rpc.Call, 1)} {
+		call := c.Go("Arith.Mul", &MemoryArgs{6, 7}, new(int), done)
+		select {
+		case <-call.Done:
+		default:
+			t.Fatal("call not done")
+		}
+		if call.Error == nil {
+			t.Fatal("call to no server succeeded")
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package src
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// remoteIP returns the IP address of the remote end of conn, or its whole address
+// for networks which have no IP addresses.
+func remoteIP(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// IPFilterPlugin closes the connections of clients whose IP address is denied, or is not allowed.
+type IPFilterPlugin struct {
+	//Allow lists the networks clients may connect from, every one if empty
+	Allow []*net.IPNet
+	//Deny lists the networks clients may not connect from, even if they are allowed
+	Deny []*net.IPNet
+}
+
+// NewIPFilterPlugin creates an IPFilterPlugin from lists of IP addresses and CIDRs,
+// like "10.0.0.1" and "192.168.0.0/16".
+func NewIPFilterPlugin(allow, deny []string) (*IPFilterPlugin, error) {
+	a, err := parseIPNets(allow)
+	if err != nil {
+		return nil, err
+	}
+	d, err := parseIPNets(deny)
+	if err != nil {
+		return nil, err
+	}
+	return &IPFilterPlugin{Allow: a, Deny: d}, nil
+}
+
+func parseIPNets(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, errors.New("rpc: invalid IP address " + s)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleConnAccept accepts conn if its IP address is allowed and not denied.
+// Connections which have no IP address are only accepted if Allow is empty.
+func (plugin *IPFilterPlugin) HandleConnAccept(conn net.Conn) bool {
+	ip := net.ParseIP(remoteIP(conn))
+	if ip == nil {
+		return len(plugin.Allow) == 0
+	}
+	if containsIP(plugin.Deny, ip) {
+		return false
+	}
+	return len(plugin.Allow) == 0 || containsIP(plugin.Allow, ip)
+}
+
+// Name return name of this plugin.
+func (plugin *IPFilterPlugin) Name() string {
+	return "IPFilterPlugin"
+}
+
+// Description return description of this plugin.
+func (plugin *IPFilterPlugin) Description() string {
+	return "a connection plugin which filters clients by IP address"
+}
+
+// ConnLimiterPlugin limits the connections of a Server: how many are open, in total and per client IP address,
+// and how fast they are accepted. Connections over a limit are closed as soon as they are accepted.
+// The limits must be set before the plugin is added to the server.
+type ConnLimiterPlugin struct {
+	//MaxConns is the number of connections which may be open, zero means unlimited
+	MaxConns int
+	//MaxConnsPerIP is the number of connections which may be open from the same IP address, zero means unlimited
+	MaxConnsPerIP int
+	//AcceptRate is the number of connections accepted per second, zero means unlimited
+	AcceptRate float64
+	//AcceptBurst is the number of connections which may be accepted at once above AcceptRate.
+	//Zero means AcceptRate rounded up.
+	AcceptBurst int
+
+	mu       sync.Mutex
+	accepted map[net.Conn]string // the open connections the plugin accepted, with their IP address
+	perIP    map[string]int
+	accept   *limitState
+}
+
+// NewConnLimiterPlugin creates a ConnLimiterPlugin with limits of open connections.
+func NewConnLimiterPlugin(maxConns, maxConnsPerIP int) *ConnLimiterPlugin {
+	return &ConnLimiterPlugin{MaxConns: maxConns, MaxConnsPerIP: maxConnsPerIP}
+}
+
+// HandleConnAccept accepts conn if it is within the limits.
+func (plugin *ConnLimiterPlugin) HandleConnAccept(conn net.Conn) bool {
+	ip := remoteIP(conn)
+	now := time.Now()
+
+	plugin.mu.Lock()
+	defer plugin.mu.Unlock()
+
+	if plugin.AcceptRate > 0 {
+		if plugin.accept == nil {
+			plugin.accept = newLimitState(Limit{Rate: plugin.AcceptRate, Burst: plugin.AcceptBurst}, now)
+		}
+		plugin.accept.refill(now)
+		if plugin.accept.check() != "" {
+			return false
+		}
+	}
+	if plugin.MaxConns > 0 && len(plugin.accepted) >= plugin.MaxConns {
+		return false
+	}
+	if plugin.MaxConnsPerIP > 0 && plugin.perIP[ip] >= plugin.MaxConnsPerIP {
+		return false
+	}
+
+	if plugin.accept != nil {
+		plugin.accept.tokens--
+	}
+	if plugin.accepted == nil {
+		plugin.accepted = make(map[net.Conn]string)
+		plugin.perIP = make(map[string]int)
+	}
+	plugin.accepted[conn] = ip
+	plugin.perIP[ip]++
+	return true
+}
+
+// HandleConnClose releases the limits which conn was accepted by.
+// Connections accepted before the plugin was added hold no limits.
+func (plugin *ConnLimiterPlugin) HandleConnClose(conn net.Conn) {
+	plugin.mu.Lock()
+	defer plugin.mu.Unlock()
+
+	ip, ok := plugin.accepted[conn]
+	if !ok {
+		return
+	}
+	delete(plugin.accepted, conn)
+	if n := plugin.perIP[ip]; n <= 1 {
+		delete(plugin.perIP, ip)
+	} else {
+		plugin.perIP[ip] = n - 1
+	}
+}
+
+// Name return name of this plugin.
+func (plugin *ConnLimiterPlugin) Name() string {
+	return "ConnLimiterPlugin"
+}
+
+// Description return description of this plugin.
+func (plugin *ConnLimiterPlugin) Description() string {
+	return "a connection plugin which limits the connections of clients"
+}
@@ -0,0 +1,56 @@
+package src
+
+import (
+	"context"
+	"net"
+	"net/rpc"
+	"time"
+)
+
+// timeoutKey is the metadata key of the time left until the deadline of a call,
+// which Client.CallContext sends for contexts with a deadline.
+const timeoutKey = "rpct-timeout"
+
+// requestState is what the server learns about a request while reading its header.
+type requestState struct {
+	conn          net.Conn
+	metadata      Metadata
+	authorization *AuthorizationAndServiceMethod
+
+	readStart int64 // the bytes read from the connection before the request
+	size      int64 // the bytes read from the connection for the request
+}
+
+// wireSize is how many bytes a call handled by a server took on its connection.
+type wireSize struct {
+	request, response int64
+}
+
+type wireSizeKey struct{}
+
+// requestHeaderPlugin is implemented by the plugins which keep what they read from the header
+// of a request in its state. ServerPluginContainer invokes it instead of PostReadRequestHeader
+// for the requests a server reads.
+type requestHeaderPlugin interface {
+	postReadRequestHeader(r *rpc.Request, st *requestState) error
+}
+
+// context returns the context of the handler of the request, which is cancelled
+// when the client disconnects or the deadline sent by the client passes.
+func (st *requestState) context(sc *ServerConn) (context.Context, context.CancelFunc) {
+	ctx := context.WithValue(sc.ctx, serverConnKey{}, sc)
+	if st == nil {
+		return context.WithCancel(ctx)
+	}
+
+	if st.metadata != nil {
+		ctx = context.WithValue(ctx, incomingMetadataKey{}, st.metadata)
+	}
+	if st.authorization != nil {
+		ctx = context.WithValue(ctx, authorizationKey{}, st.authorization)
+	}
+	if timeout, err := time.ParseDuration(st.metadata[timeoutKey]); err == nil {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
@@ -1,8 +1,11 @@
 package src
 
 import (
+	"context"
 	"fmt"
+	"net/rpc"
 	"runtime"
+	"strings"
 )
 
 var (
@@ -90,3 +93,86 @@ func (e *MultiError) Error() string {
 func NewMultiError(errors []error) *MultiError {
 	return &MultiError{Errors: errors}
 }
+
+const (
+	resourceExhaustedPrefix = "rpc: resource exhausted: "
+	unavailablePrefix       = "rpc: unavailable: "
+)
+
+// ResourceExhaustedError is the error of calls rejected by a LimiterPlugin.
+// Client calls fail with it too, instead of a rpc.ServerError.
+type ResourceExhaustedError struct {
+	//Limit tells which limit was hit, like "rate of Arith.Mul" or "in-flight calls of 10.0.0.1"
+	Limit string
+}
+
+func (e *ResourceExhaustedError) Error() string {
+	return resourceExhaustedPrefix + e.Limit
+}
+
+// IsResourceExhausted reports whether err is a ResourceExhaustedError.
+func IsResourceExhausted(err error) bool {
+	_, ok := err.(*ResourceExhaustedError)
+	return ok
+}
+
+// UnavailableError is the error of calls shed by a server which is overloaded, like by an AdaptiveLimiterPlugin.
+// Client calls fail with it too, instead of a rpc.ServerError, and Failover retries them on another server.
+type UnavailableError struct {
+	Reason string
+}
+
+func (e *UnavailableError) Error() string {
+	return unavailablePrefix + e.Reason
+}
+
+// IsUnavailable reports whether err is an UnavailableError.
+func IsUnavailable(err error) bool {
+	_, ok := err.(*UnavailableError)
+	return ok
+}
+
+// typedServerError turns the errors which servers send for their typed errors back into them.
+func typedServerError(err error) error {
+	se, ok := err.(rpc.ServerError)
+	if !ok {
+		return err
+	}
+	switch {
+	case strings.HasPrefix(string(se), resourceExhaustedPrefix):
+		return &ResourceExhaustedError{Limit: string(se)[len(resourceExhaustedPrefix):]}
+	case strings.HasPrefix(string(se), unavailablePrefix):
+		return &UnavailableError{Reason: string(se)[len(unavailablePrefix):]}
+	}
+	return err
+}
+
+// ErrorCode classifies the error of a call for logs and metrics: "ok" if err is nil, "resource_exhausted",
+// "unavailable", "unauthenticated", "permission_denied", "canceled", "deadline_exceeded", or "unknown".
+func ErrorCode(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	switch err {
+	case context.Canceled:
+		return "canceled"
+	case context.DeadlineExceeded:
+		return "deadline_exceeded"
+	}
+	msg := err.Error()
+	switch {
+	case IsResourceExhausted(err) || strings.HasPrefix(msg, resourceExhaustedPrefix):
+		return "resource_exhausted"
+	case IsUnavailable(err) || strings.HasPrefix(msg, unavailablePrefix):
+		return "unavailable"
+	case strings.HasPrefix(msg, "rpc: unauthenticated: "):
+		return "unauthenticated"
+	case strings.HasPrefix(msg, "rpc: permission denied: "):
+		return "permission_denied"
+	case msg == context.Canceled.Error():
+		return "canceled"
+	case msg == context.DeadlineExceeded.Error():
+		return "deadline_exceeded"
+	}
+	return "unknown"
+}
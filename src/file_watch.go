@@ -0,0 +1,35 @@
+package src
+
+import (
+	"os"
+	"time"
+)
+
+// watchFile calls reload every interval, DefaultCertificateReloadInterval if zero, once the modification
+// time of the file at path has changed since stamp, until done is closed. Errors are logged as errors of reloading what.
+// The file is stamped only once it reloads, so that a file which failed to, like one read while it was
+// being written, is reloaded again at the next check.
+func watchFile(path string, stamp time.Time, interval time.Duration, done chan struct{}, what string, reload func() error) {
+	if interval <= 0 {
+		interval = DefaultCertificateReloadInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			fi, err := os.Stat(path)
+			if err != nil || fi.ModTime().Equal(stamp) {
+				continue
+			}
+			if err := reload(); err != nil {
+				DefaultLogger.Error("reloading "+what, "path", path, "err", err)
+				continue
+			}
+			stamp = fi.ModTime()
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package src
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// Control frames travel in the ordinary request and response headers. They are told
+// apart from normal calls by a reserved prefix of ServiceMethod which can never be a
+// registered "Service.Method" name. The frames sent by clients, like one-way requests,
+// metadata and heartbeats, work with every codec. The frames sent by servers on their own,
+// which push, callbacks and streams need, also need a codec which carries the ServiceMethod
+// of responses and responses to no pending request, see ControlFrameCodec.
+const (
+	controlPrefix = "!rpct."
+
+	//oneWayPrefix marks a request whose caller does not want a response.
+	oneWayPrefix = controlPrefix + "oneway:"
+
+	//metadataPrefix carries the metadata of a request in front of its ServiceMethod.
+	//It is followed by the URL encoded metadata and a '|'.
+	metadataPrefix = controlPrefix + "md:"
+
+	//pushPrefix marks a response which is a notification pushed by the server.
+	pushPrefix = controlPrefix + "push:"
+	//callPrefix marks a response which is a call from the server to a client handler.
+	callPrefix = controlPrefix + "call:"
+	//replyPrefix marks a request which answers a call from the server.
+	//The rest of ServiceMethod is the error returned by the client handler.
+	replyPrefix = controlPrefix + "reply:"
+
+	//itemPrefix marks an item of a streaming call, in either direction.
+	itemPrefix = controlPrefix + "item"
+	//closePrefix marks the end of the items sent by the client of a streaming call.
+	closePrefix = controlPrefix + "close"
+	//creditPrefix grants the peer of a streaming call room for more items.
+	//The rest of ServiceMethod is the number of items.
+	creditPrefix = controlPrefix + "credit:"
+
+	//pingMethod is a heartbeat request. The server answers it with a response of the same name.
+	pingMethod = controlPrefix + "ping"
+)
+
+// isServerControl reports whether the ServiceMethod of a response marks a control frame.
+// Codecs like jsonrpc answer with the ServiceMethod of the request, whose metadata
+// or one-way prefix makes no control frame of the response.
+func isServerControl(serviceMethod string) bool {
+	return strings.HasPrefix(serviceMethod, controlPrefix) &&
+		!strings.HasPrefix(serviceMethod, metadataPrefix) && !strings.HasPrefix(serviceMethod, oneWayPrefix)
+}
+
+// ControlFrameCodec is implemented by codecs which tell whether they carry the control frames
+// of servers: responses whose ServiceMethod is not the one of the request, and responses which
+// answer no request. Codecs which do not implement it are assumed to carry them, as the msgpack
+// and gob codecs do, except the codecs of net/rpc/jsonrpc: the server codec only answers pending
+// requests and the client codec drops the ServiceMethod of responses.
+// Push, Call, Go of ServerConn and streaming calls fail with ErrNoControlFrames over codecs which don't.
+type ControlFrameCodec interface {
+	ControlFrames() bool
+}
+
+// ErrNoControlFrames is returned for push, callbacks and streams over a codec which cannot carry them.
+var ErrNoControlFrames = errors.New("rpc: the codec cannot carry push, callbacks or streams")
+
+// carriesControlFrames reports whether codec carries the control frames of servers, see ControlFrameCodec.
+func carriesControlFrames(codec interface{}) bool {
+	if c, ok := codec.(ControlFrameCodec); ok {
+		return c.ControlFrames()
+	}
+	t := reflect.TypeOf(codec)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t == nil || t.PkgPath() != "net/rpc/jsonrpc"
+}
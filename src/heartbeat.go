@@ -0,0 +1,54 @@
+package src
+
+import (
+	"net/rpc"
+	"time"
+)
+
+// DefaultHeartbeatMisses is the number of missed heartbeats after which a connection
+// is closed unless Client.HeartbeatMisses is set.
+const DefaultHeartbeatMisses = 3
+
+// heartbeat pings the server over rpcClient every c.Heartbeat until rpcClient is closed.
+// Once c.HeartbeatMisses pings in a row are not answered within the interval,
+// it closes rpcClient and tells the selector that the server has failed.
+func (c *Client) heartbeat(rpcClient *rpc.Client, network, address string) {
+	interval := c.Heartbeat
+	maxMisses := c.HeartbeatMisses
+	if maxMisses <= 0 {
+		maxMisses = DefaultHeartbeatMisses
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	misses := 0
+	for range ticker.C {
+		call := rpcClient.Go(pingMethod, invalidRequest, nil, make(chan *rpc.Call, 1))
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-call.Done:
+			timer.Stop()
+			if call.Error == rpc.ErrShutdown {
+				return
+			}
+			if call.Error != nil {
+				misses++
+			} else {
+				misses = 0
+			}
+		case <-timer.C:
+			misses++
+		}
+
+		if misses >= maxMisses {
+			c.logger().Warn("closing connection which misses heartbeats", "network", network, "address", address, "misses", misses)
+			rpcClient.Close()
+			if h, ok := c.ClientSelector.(FailedServerHandler); ok {
+				h.HandleFailedServer(network, address)
+			}
+			return
+		}
+	}
+}
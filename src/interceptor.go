@@ -0,0 +1,40 @@
+package src
+
+import "context"
+
+// UnaryHandler invokes the service method of a call on the server.
+type UnaryHandler func(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error
+
+// UnaryServerInterceptor wraps the handling of a call by a Server. It sees the args and the reply
+// of the call together, and calls next to continue the chain, or returns without calling it to reject the call.
+// The error it returns is sent to the client.
+type UnaryServerInterceptor func(ctx context.Context, serviceMethod string, args interface{}, reply interface{}, next UnaryHandler) error
+
+// UnaryInvoker sends a call to the server and waits for its response.
+type UnaryInvoker func(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error
+
+// UnaryClientInterceptor wraps a call made by a Client. It calls next to continue the chain,
+// or returns without calling it to fail the call without sending it.
+type UnaryClientInterceptor func(ctx context.Context, serviceMethod string, args interface{}, reply interface{}, next UnaryInvoker) error
+
+// chainServerInterceptors returns a handler which runs interceptors in order around handler.
+func chainServerInterceptors(interceptors []UnaryServerInterceptor, handler UnaryHandler) UnaryHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+			return interceptor(ctx, serviceMethod, args, reply, next)
+		}
+	}
+	return handler
+}
+
+// chainClientInterceptors returns an invoker which runs interceptors in order around invoker.
+func chainClientInterceptors(interceptors []UnaryClientInterceptor, invoker UnaryInvoker) UnaryInvoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+			return interceptor(ctx, serviceMethod, args, reply, next)
+		}
+	}
+	return invoker
+}
@@ -0,0 +1,374 @@
+package src
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWTClaims are the claims of a validated JWT.
+type JWTClaims map[string]interface{}
+
+// Subject returns the sub claim.
+func (c JWTClaims) Subject() string {
+	s, _ := c["sub"].(string)
+	return s
+}
+
+// Issuer returns the iss claim.
+func (c JWTClaims) Issuer() string {
+	s, _ := c["iss"].(string)
+	return s
+}
+
+// time returns the NumericDate claim name.
+func (c JWTClaims) time(name string) (time.Time, bool) {
+	v, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(v), 0), true
+}
+
+// ExpiresAt returns the exp claim.
+func (c JWTClaims) ExpiresAt() (time.Time, bool) {
+	return c.time("exp")
+}
+
+// Audience returns the aud claim, which may be a string or a list of them.
+func (c JWTClaims) Audience() []string {
+	switch aud := c["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		auds := make([]string, 0, len(aud))
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				auds = append(auds, s)
+			}
+		}
+		return auds
+	}
+	return nil
+}
+
+type jwtClaimsKey struct{}
+
+// JWTClaimsFromContext returns the claims of the token of the call handled with ctx,
+// if the server uses JWTAuthPlugin.
+func JWTClaimsFromContext(ctx context.Context) (JWTClaims, bool) {
+	c, ok := ctx.Value(jwtClaimsKey{}).(JWTClaims)
+	return c, ok
+}
+
+// JWTKeySet finds the keys which JWTs are verified with, by the kid of their header.
+// Keys are []byte for HS256, *rsa.PublicKey for RS256 and *ecdsa.PublicKey for ES256.
+type JWTKeySet interface {
+	JWTKey(kid string) (interface{}, error)
+}
+
+// StaticJWTKeys is a fixed JWTKeySet by kid. The key of the empty kid verifies tokens without one.
+type StaticJWTKeys map[string]interface{}
+
+// JWTKey returns the key of kid.
+func (keys StaticJWTKeys) JWTKey(kid string) (interface{}, error) {
+	key, ok := keys[kid]
+	if !ok {
+		return nil, errors.New("rpc: unknown JWT key " + kid)
+	}
+	return key, nil
+}
+
+// JWKSFile is a JWTKeySet read from a local JWKS file, which is read again once it changes,
+// so that keys can be rotated by replacing the file.
+type JWKSFile struct {
+	path string
+
+	mu   sync.RWMutex
+	keys StaticJWTKeys
+
+	done chan struct{}
+	once sync.Once
+}
+
+// NewJWKSFile reads the JWKS file at path and checks it for changes every interval,
+// DefaultCertificateReloadInterval if zero.
+func NewJWKSFile(path string, interval time.Duration) (*JWKSFile, error) {
+	f := &JWKSFile{path: path, done: make(chan struct{})}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	go watchFile(path, fi.ModTime(), interval, f.done, "JWKS", f.Reload)
+	return f, nil
+}
+
+// Reload reads the file now. The current keys are kept if reading fails.
+func (f *JWKSFile) Reload() error {
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.keys = keys
+	f.mu.Unlock()
+	return nil
+}
+
+// JWTKey returns the key of kid.
+func (f *JWKSFile) JWTKey(kid string) (interface{}, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.keys.JWTKey(kid)
+}
+
+// Close stops checking the file for changes.
+func (f *JWKSFile) Close() {
+	f.once.Do(func() { close(f.done) })
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// ParseJWKS parses a JSON Web Key Set of RSA, P-256 EC and symmetric keys.
+// Keys of other types are skipped.
+func ParseJWKS(data []byte) (StaticJWTKeys, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(StaticJWTKeys, len(set.Keys))
+	for _, k := range set.Keys {
+		key, err := k.key()
+		if err != nil {
+			return nil, errors.New("rpc: JWK " + k.Kid + ": " + err.Error())
+		}
+		if key != nil {
+			keys[k.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+func (k *jwk) key() (interface{}, error) {
+	b64 := base64.RawURLEncoding
+	switch k.Kty {
+	case "RSA":
+		n, err := b64.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, nil
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "oct":
+		return b64.DecodeString(k.K)
+	}
+	return nil, nil
+}
+
+// ValidateJWT verifies the signature of token with keys, and that it has an exp claim and is not expired,
+// already valid and meant for audience unless it is empty. leeway is the tolerated clock skew.
+func ValidateJWT(token string, keys JWTKeySet, audience string, leeway time.Duration) (JWTClaims, error) {
+	return validateJWT(token, keys, audience, leeway, false)
+}
+
+// validateJWT is ValidateJWT, which also accepts tokens without exp if allowNoExpiry.
+func validateJWT(token string, keys JWTKeySet, audience string, leeway time.Duration, allowNoExpiry bool) (JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("rpc: malformed JWT")
+	}
+	b64 := base64.RawURLEncoding
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	data, err := b64.DecodeString(parts[0])
+	if err == nil {
+		err = json.Unmarshal(data, &header)
+	}
+	if err != nil {
+		return nil, errors.New("rpc: malformed JWT header")
+	}
+	sig, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("rpc: malformed JWT signature")
+	}
+
+	key, err := keys.JWTKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims JWTClaims
+	data, err = b64.DecodeString(parts[1])
+	if err == nil {
+		err = json.Unmarshal(data, &claims)
+	}
+	if err != nil {
+		return nil, errors.New("rpc: malformed JWT claims")
+	}
+
+	now := time.Now()
+	exp, ok := claims.time("exp")
+	if !ok && !allowNoExpiry {
+		return nil, errors.New("rpc: JWT has no expiry")
+	}
+	if ok && now.After(exp.Add(leeway)) {
+		return nil, errors.New("rpc: JWT is expired")
+	}
+	if nbf, ok := claims.time("nbf"); ok && now.Add(leeway).Before(nbf) {
+		return nil, errors.New("rpc: JWT is not valid yet")
+	}
+	if audience != "" {
+		found := false
+		for _, aud := range claims.Audience() {
+			if aud == audience {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.New("rpc: JWT is not meant for " + audience)
+		}
+	}
+	return claims, nil
+}
+
+// verifyJWTSignature checks sig of signed, the header and claims of a JWT, with alg and key.
+// The type of key must suit alg, so that a public key cannot be used as a HMAC secret.
+func verifyJWTSignature(alg string, key interface{}, signed string, sig []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+	invalid := errors.New("rpc: invalid JWT signature")
+
+	switch alg {
+	case "HS256":
+		secret, ok := key.([]byte)
+		if !ok {
+			return errors.New("rpc: JWT key is not for " + alg)
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return invalid
+		}
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("rpc: JWT key is not for " + alg)
+		}
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) != nil {
+			return invalid
+		}
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != elliptic.P256() {
+			return errors.New("rpc: JWT key is not for " + alg)
+		}
+		if len(sig) != 64 {
+			return invalid
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return invalid
+		}
+	default:
+		return errors.New("rpc: unsupported JWT algorithm " + alg)
+	}
+	return nil
+}
+
+// JWTAuthPlugin authenticates the calls of a Server by the JWT bearer tokens which clients send
+// as their authorization, see Client.Auth and Client.AuthTokenSource. It needs AuthorizationServerPlugin
+// to read the authorization. Handlers find the claims of the token with JWTClaimsFromContext.
+type JWTAuthPlugin struct {
+	Keys JWTKeySet
+	//Audience must be in the aud claim of tokens, unless it is empty
+	Audience string
+	//Leeway is the tolerated clock skew when checking exp and nbf
+	Leeway time.Duration
+	//AllowNoExpiry accepts tokens without an exp claim, which never expire. They are rejected by default.
+	AllowNoExpiry bool
+}
+
+// NewJWTAuthPlugin creates a JWTAuthPlugin which verifies tokens with keys.
+func NewJWTAuthPlugin(keys JWTKeySet, audience string) *JWTAuthPlugin {
+	return &JWTAuthPlugin{Keys: keys, Audience: audience}
+}
+
+// PreCall validates the token of a call.
+func (plugin *JWTAuthPlugin) PreCall(ctx context.Context, info *CallInfo) (context.Context, error) {
+	a, ok := AuthorizationFromContext(ctx)
+	if !ok || a.Authorization == "" {
+		return ctx, errors.New("rpc: unauthenticated: no token")
+	}
+	token := strings.TrimPrefix(a.Authorization, "Bearer ")
+
+	claims, err := validateJWT(token, plugin.Keys, plugin.Audience, plugin.Leeway, plugin.AllowNoExpiry)
+	if err != nil {
+		return ctx, errors.New("rpc: unauthenticated: " + strings.TrimPrefix(err.Error(), "rpc: "))
+	}
+	return context.WithValue(ctx, jwtClaimsKey{}, claims), nil
+}
+
+// Name return name of this plugin.
+func (plugin *JWTAuthPlugin) Name() string {
+	return "JWTAuthPlugin"
+}
+
+// Description return description of this plugin.
+func (plugin *JWTAuthPlugin) Description() string {
+	return "a JWT plugin which authenticates the bearer tokens of clients"
+}
@@ -0,0 +1,195 @@
+package src
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signTestJWT returns a JWT of claims with the header alg and kid, signed with key for alg,
+// which may differ from the header to forge tokens.
+func signTestJWT(t *testing.T, alg, kid string, key interface{}, claims JWTClaims) string {
+	t.Helper()
+	b64 := base64.RawURLEncoding
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	body, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(body)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+func TestValidateJWT(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPublicDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("secret")
+	keys := StaticJWTKeys{"hs": secret, "rs": &rsaKey.PublicKey, "es": &ecKey.PublicKey}
+
+	now := time.Now()
+	valid := func() JWTClaims {
+		return JWTClaims{"sub": "alice", "aud": "billing", "exp": float64(now.Add(time.Hour).Unix())}
+	}
+	with := func(name string, value interface{}) JWTClaims {
+		claims := valid()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+
+	// withClaims replaces the claims of token, keeping its signature
+	withClaims := func(token string, claims JWTClaims) string {
+		parts := strings.Split(token, ".")
+		body, _ := json.Marshal(claims)
+		return parts[0] + "." + base64.RawURLEncoding.EncodeToString(body) + "." + parts[2]
+	}
+
+	for _, tt := range []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"HS256", signTestJWT(t, "HS256", "hs", secret, valid()), true},
+		{"RS256", signTestJWT(t, "RS256", "rs", rsaKey, valid()), true},
+		{"ES256", signTestJWT(t, "ES256", "es", ecKey, valid()), true},
+
+		// the key decides which algorithms may verify a token
+		{"RSA public key as HMAC secret", signTestJWT(t, "HS256", "rs", rsaPublicDER, valid()), false},
+		{"HMAC secret for RS256", signTestJWT(t, "RS256", "hs", rsaKey, valid()), false},
+		{"EC key for RS256", signTestJWT(t, "RS256", "es", rsaKey, valid()), false},
+		{"RSA key for ES256", signTestJWT(t, "ES256", "rs", ecKey, valid()), false},
+		{"alg none", signTestJWT(t, "none", "hs", nil, valid()), false},
+		{"wrong secret", signTestJWT(t, "HS256", "hs", []byte("guess"), valid()), false},
+		{"unknown kid", signTestJWT(t, "HS256", "other", secret, valid()), false},
+		{"claims changed", withClaims(signTestJWT(t, "HS256", "hs", secret, valid()), with("sub", "root")), false},
+		{"malformed", "a.b", false},
+
+		{"expired", signTestJWT(t, "HS256", "hs", secret, with("exp", float64(now.Add(-time.Minute).Unix()))), false},
+		{"expired within leeway", signTestJWT(t, "HS256", "hs", secret, with("exp", float64(now.Add(-time.Second).Unix()))), true},
+		{"no expiry", signTestJWT(t, "HS256", "hs", secret, with("exp", nil)), false},
+		{"expiry not a number", signTestJWT(t, "HS256", "hs", secret, with("exp", "tomorrow")), false},
+		{"not valid yet", signTestJWT(t, "HS256", "hs", secret, with("nbf", float64(now.Add(time.Minute).Unix()))), false},
+		{"valid within leeway", signTestJWT(t, "HS256", "hs", secret, with("nbf", float64(now.Add(time.Second).Unix()))), true},
+		{"audiences", signTestJWT(t, "HS256", "hs", secret, with("aud", []interface{}{"audit", "billing"})), true},
+		{"other audience", signTestJWT(t, "HS256", "hs", secret, with("aud", "audit")), false},
+		{"no audience", signTestJWT(t, "HS256", "hs", secret, with("aud", nil)), false},
+	} {
+		claims, err := ValidateJWT(tt.token, keys, "billing", 10*time.Second)
+		if tt.valid && (err != nil || claims.Subject() != "alice") {
+			t.Errorf("%s: claims %v, err %v", tt.name, claims, err)
+		} else if !tt.valid && err == nil {
+			t.Errorf("%s: token accepted", tt.name)
+		}
+	}
+
+	noExpiry := signTestJWT(t, "HS256", "hs", secret, with("exp", nil))
+	if _, err := validateJWT(noExpiry, keys, "", 0, true); err != nil {
+		t.Error("token without expiry rejected although allowed:", err)
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := base64.RawURLEncoding
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "EC", "kid": "es", "crv": "P-256", "x": b64.EncodeToString(ecKey.X.Bytes()), "y": b64.EncodeToString(ecKey.Y.Bytes())},
+		{"kty": "oct", "kid": "hs", "k": b64.EncodeToString([]byte("secret"))},
+		{"kty": "EC", "kid": "p384", "crv": "P-384", "x": "AA", "y": "AA"},
+		{"kty": "OKP", "kid": "ed"},
+	}})
+	keys, err := ParseJWKS(jwks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("keys %v, want those of es and hs only", keys)
+	}
+	claims := JWTClaims{"sub": "alice", "exp": float64(time.Now().Add(time.Hour).Unix())}
+	for kid, key := range map[string]interface{}{"es": ecKey, "hs": []byte("secret")} {
+		alg := map[string]string{"es": "ES256", "hs": "HS256"}[kid]
+		if _, err := ValidateJWT(signTestJWT(t, alg, kid, key, claims), keys, "", 0); err != nil {
+			t.Errorf("%s: %v", kid, err)
+		}
+	}
+
+	if _, err := ParseJWKS([]byte(`{"keys":[{"kty":"oct","kid":"bad","k":"!"}]}`)); err == nil {
+		t.Error("key which is not base64 parsed")
+	}
+}
+
+func TestJWTAuthPlugin(t *testing.T) {
+	secret := []byte("secret")
+	plugin := NewJWTAuthPlugin(StaticJWTKeys{"": secret}, "")
+	token := signTestJWT(t, "HS256", "", secret, JWTClaims{"sub": "alice", "exp": float64(time.Now().Add(time.Hour).Unix())})
+	authorized := func(authorization string) context.Context {
+		return context.WithValue(context.Background(), authorizationKey{}, &AuthorizationAndServiceMethod{Authorization: authorization})
+	}
+	info := &CallInfo{ServiceMethod: "Arith.Mul"}
+
+	for _, authorization := range []string{token, "Bearer " + token} {
+		ctx, err := plugin.PreCall(authorized(authorization), info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claims, ok := JWTClaimsFromContext(ctx); !ok || claims.Subject() != "alice" {
+			t.Fatalf("claims %v", claims)
+		}
+	}
+	for name, ctx := range map[string]context.Context{
+		"no authorization": context.Background(),
+		"no token":         authorized(""),
+		"bad token":        authorized("Bearer " + token + "x"),
+	} {
+		if _, err := plugin.PreCall(ctx, info); err == nil {
+			t.Errorf("%s: call authenticated", name)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package src
+
+import (
+	"context"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// Limit bounds the calls of a server, or of a service method or a client of it.
+type Limit struct {
+	//Rate is the number of calls per second, zero means unlimited
+	Rate float64
+	//Burst is the number of calls which may be made at once above Rate, at least one. Zero means Rate rounded up.
+	Burst int
+	//MaxInFlight is the number of calls which may run at the same time, zero means unlimited
+	MaxInFlight int
+}
+
+func (l Limit) isZero() bool {
+	return l.Rate <= 0 && l.MaxInFlight <= 0
+}
+
+// limitState is the state of a Limit for a method or a client, or for the whole server.
+type limitState struct {
+	limit    Limit
+	tokens   float64
+	last     time.Time
+	inFlight int
+}
+
+func newLimitState(limit Limit, now time.Time) *limitState {
+	if limit.Burst <= 0 {
+		limit.Burst = int(math.Ceil(limit.Rate))
+	}
+	return &limitState{limit: limit, tokens: float64(limit.Burst), last: now}
+}
+
+// refill adds the tokens earned since the last call.
+func (st *limitState) refill(now time.Time) {
+	if st.limit.Rate <= 0 {
+		return
+	}
+	st.tokens = math.Min(float64(st.limit.Burst), st.tokens+now.Sub(st.last).Seconds()*st.limit.Rate)
+	st.last = now
+}
+
+// check returns which of the limits of st a new call exceeds, or "".
+func (st *limitState) check() string {
+	if st.limit.Rate > 0 && st.tokens < 1 {
+		return "rate"
+	}
+	if st.limit.MaxInFlight > 0 && st.inFlight >= st.limit.MaxInFlight {
+		return "in-flight calls"
+	}
+	return ""
+}
+
+// idle reports whether st is as good as new, so that it can be forgotten.
+func (st *limitState) idle() bool {
+	return st.inFlight == 0 && (st.limit.Rate <= 0 || st.tokens >= float64(st.limit.Burst))
+}
+
+// limiterSweepInterval is how often the states of clients which have gone idle are removed.
+const limiterSweepInterval = time.Minute
+
+// LimiterPlugin limits the rate and the number of in-flight calls of a Server, globally,
+// per service method and per client. Clients are identified by what they authenticated as:
+// the principal of their certificate like "mtls:spiffe://example.org/billing", see MTLSAuthPlugin,
+// else the subject of their token like "jwt:alice", see JWTAuthPlugin, else their IP address.
+// The authorization tag is never used, since clients choose it freely. The plugin must be added
+// after the authentication plugins, whose PreCall finds the identity.
+// Calls over a limit fail at once with a ResourceExhaustedError.
+// The limits must be set before the plugin is added to the server.
+type LimiterPlugin struct {
+	//Global limits all the calls of the server together
+	Global Limit
+	//PerMethod limits the calls of each service method which is not in Methods
+	PerMethod Limit
+	//Methods limits the calls of service methods by name, like "Arith.Mul"
+	Methods map[string]Limit
+	//PerIdentity limits the calls of each client which is not in Identities
+	PerIdentity Limit
+	//Identities limits the calls of clients by identity, like "mtls:spiffe://example.org/billing",
+	//"jwt:alice" or "10.0.0.1"
+	Identities map[string]Limit
+
+	mu         sync.Mutex
+	global     *limitState
+	methods    map[string]*limitState
+	identities map[string]*limitState
+	lastSweep  time.Time
+}
+
+// NewLimiterPlugin creates a LimiterPlugin with a global limit.
+func NewLimiterPlugin(global Limit) *LimiterPlugin {
+	return &LimiterPlugin{Global: global}
+}
+
+type limiterKey struct{ plugin *LimiterPlugin }
+
+// PreCall admits a call, or rejects it if it exceeds a limit.
+func (plugin *LimiterPlugin) PreCall(ctx context.Context, info *CallInfo) (context.Context, error) {
+	identity := identityOf(ctx, info.Peer)
+
+	now := time.Now()
+
+	plugin.mu.Lock()
+	defer plugin.mu.Unlock()
+
+	if plugin.methods == nil {
+		plugin.methods = make(map[string]*limitState)
+		plugin.identities = make(map[string]*limitState)
+		plugin.lastSweep = now
+	}
+	if now.Sub(plugin.lastSweep) >= limiterSweepInterval {
+		plugin.sweep(now)
+	}
+
+	var states []*limitState
+	var names []string
+	if !plugin.Global.isZero() {
+		if plugin.global == nil {
+			plugin.global = newLimitState(plugin.Global, now)
+		}
+		states, names = append(states, plugin.global), append(names, "server")
+	}
+	if limit, ok := plugin.Methods[info.ServiceMethod]; ok || !plugin.PerMethod.isZero() {
+		if !ok {
+			limit = plugin.PerMethod
+		}
+		if st := stateOf(plugin.methods, info.ServiceMethod, limit, now); st != nil {
+			states, names = append(states, st), append(names, info.ServiceMethod)
+		}
+	}
+	if limit, ok := plugin.Identities[identity]; ok || !plugin.PerIdentity.isZero() {
+		if !ok {
+			limit = plugin.PerIdentity
+		}
+		if st := stateOf(plugin.identities, identity, limit, now); st != nil {
+			states, names = append(states, st), append(names, identity)
+		}
+	}
+
+	// a call takes from all of its limits or from none
+	for i, st := range states {
+		st.refill(now)
+		if exceeded := st.check(); exceeded != "" {
+			return ctx, &ResourceExhaustedError{Limit: exceeded + " of " + names[i]}
+		}
+	}
+	for _, st := range states {
+		if st.limit.Rate > 0 {
+			st.tokens--
+		}
+		st.inFlight++
+	}
+	return context.WithValue(ctx, limiterKey{plugin}, states), nil
+}
+
+// identityOf returns the identity of the client of a call for the limits per client:
+// its mTLS principal, else its JWT subject, else the IP address of peer.
+func identityOf(ctx context.Context, peer string) string {
+	if p, ok := PrincipalFromContext(ctx); ok {
+		return rbacMTLSQualifier + p.Name
+	}
+	if claims, ok := JWTClaimsFromContext(ctx); ok && claims.Subject() != "" {
+		return rbacJWTQualifier + claims.Subject()
+	}
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		return host
+	}
+	return peer
+}
+
+// stateOf returns the state of the limit of key, creating it if needed, or nil if limit is unlimited.
+func stateOf(states map[string]*limitState, key string, limit Limit, now time.Time) *limitState {
+	if limit.isZero() {
+		return nil
+	}
+	st := states[key]
+	if st == nil {
+		st = newLimitState(limit, now)
+		states[key] = st
+	}
+	return st
+}
+
+// sweep forgets the states of methods and clients which are idle.
+func (plugin *LimiterPlugin) sweep(now time.Time) {
+	for _, states := range []map[string]*limitState{plugin.methods, plugin.identities} {
+		for key, st := range states {
+			st.refill(now)
+			if st.idle() {
+				delete(states, key)
+			}
+		}
+	}
+	plugin.lastSweep = now
+}
+
+// PostCall releases the limits which a call was admitted by.
+func (plugin *LimiterPlugin) PostCall(ctx context.Context, info *CallInfo, err error) {
+	states, ok := ctx.Value(limiterKey{plugin}).([]*limitState)
+	if !ok {
+		return
+	}
+
+	plugin.mu.Lock()
+	for _, st := range states {
+		st.inFlight--
+	}
+	plugin.mu.Unlock()
+}
+
+// Name return name of this plugin.
+func (plugin *LimiterPlugin) Name() string {
+	return "LimiterPlugin"
+}
+
+// Description return description of this plugin.
+func (plugin *LimiterPlugin) Description() string {
+	return "a limiter plugin which limits the rate and the concurrency of calls"
+}
@@ -0,0 +1,63 @@
+package src
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLimiterIdentity(t *testing.T) {
+	tagged := func(ctx context.Context, tag string) context.Context {
+		return context.WithValue(ctx, authorizationKey{}, &AuthorizationAndServiceMethod{Tag: tag})
+	}
+	mtls := context.WithValue(context.Background(), principalKey{}, &Principal{Name: "spiffe://example.org/billing"})
+	jwt := context.WithValue(context.Background(), jwtClaimsKey{}, JWTClaims{"sub": "alice"})
+
+	for _, tt := range []struct {
+		name string
+		ctx  context.Context
+		peer string
+		want string
+	}{
+		{"ip", context.Background(), "10.0.0.1:4000", "10.0.0.1"},
+		{"no port", context.Background(), "pipe", "pipe"},
+		{"tag ignored", tagged(context.Background(), "gold"), "10.0.0.1:4000", "10.0.0.1"},
+		{"mtls", tagged(mtls, "gold"), "10.0.0.1:4000", "mtls:spiffe://example.org/billing"},
+		{"jwt", tagged(jwt, "gold"), "10.0.0.1:4000", "jwt:alice"},
+		{"jwt without subject", context.WithValue(context.Background(), jwtClaimsKey{}, JWTClaims{}), "10.0.0.1:4000", "10.0.0.1"},
+		{"mtls before jwt", context.WithValue(mtls, jwtClaimsKey{}, JWTClaims{"sub": "alice"}), "10.0.0.1:4000", "mtls:spiffe://example.org/billing"},
+	} {
+		if got := identityOf(tt.ctx, tt.peer); got != tt.want {
+			t.Errorf("%s: identity %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLimiterPerIdentity(t *testing.T) {
+	plugin := NewLimiterPlugin(Limit{})
+	plugin.PerIdentity = Limit{MaxInFlight: 1}
+	info := &CallInfo{ServiceMethod: "Arith.Mul", Peer: "10.0.0.1:4000"}
+
+	// a client changing its tag on each call stays one client
+	ctx := context.WithValue(context.Background(), authorizationKey{}, &AuthorizationAndServiceMethod{Tag: "a"})
+	if _, err := plugin.PreCall(ctx, info); err != nil {
+		t.Fatal(err)
+	}
+	ctx = context.WithValue(context.Background(), authorizationKey{}, &AuthorizationAndServiceMethod{Tag: "b"})
+	if _, err := plugin.PreCall(ctx, info); !IsResourceExhausted(err) {
+		t.Fatalf("call with another tag: err %v", err)
+	}
+
+	// an authenticated client is limited apart from its address
+	ctx = context.WithValue(context.Background(), jwtClaimsKey{}, JWTClaims{"sub": "alice"})
+	ctx, err := plugin.PreCall(ctx, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := plugin.PreCall(ctx, info); !IsResourceExhausted(err) {
+		t.Fatalf("second call of alice: err %v", err)
+	}
+	plugin.PostCall(ctx, info, nil)
+	if _, err := plugin.PreCall(ctx, info); err != nil {
+		t.Fatalf("call of alice after the first returned: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package src
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger logs what the library cannot return to its callers, like the errors of accept loops
+// and registries. Args are alternating keys and values. *slog.Logger is a Logger.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// DefaultLogger is used by servers, clients and selectors which have no Logger,
+// and by plugins. It writes Info and above with the standard log package.
+var DefaultLogger Logger = &StdLogger{}
+
+// StdLogger writes with a *log.Logger, the standard one if Logger is nil, like
+//	rpc: WARN accepting connection err="too many open files"
+type StdLogger struct {
+	Logger *log.Logger
+	//Verbose also writes Debug messages
+	Verbose bool
+}
+
+func (l *StdLogger) log(level, msg string, args []interface{}) {
+	var b strings.Builder
+	b.WriteString("rpc: ")
+	b.WriteString(level)
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		b.WriteByte(' ')
+		if i+1 == len(args) {
+			// a value without key
+			fmt.Fprintf(&b, "!BADKEY=%v", args[i])
+			break
+		}
+		value := fmt.Sprint(args[i+1])
+		if value == "" || strings.ContainsAny(value, " =\"") {
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(&b, "%v=%s", args[i], value)
+	}
+
+	if l.Logger != nil {
+		l.Logger.Print(b.String())
+	} else {
+		log.Print(b.String())
+	}
+}
+
+// Debug writes msg if l.Verbose is set.
+func (l *StdLogger) Debug(msg string, args ...interface{}) {
+	if l.Verbose {
+		l.log("DEBUG", msg, args)
+	}
+}
+
+// Info writes msg.
+func (l *StdLogger) Info(msg string, args ...interface{}) { l.log("INFO", msg, args) }
+
+// Warn writes msg.
+func (l *StdLogger) Warn(msg string, args ...interface{}) { l.log("WARN", msg, args) }
+
+// Error writes msg.
+func (l *StdLogger) Error(msg string, args ...interface{}) { l.log("ERROR", msg, args) }
+
+// DiscardLogger drops everything.
+type DiscardLogger struct{}
+
+// Debug does nothing.
+func (DiscardLogger) Debug(msg string, args ...interface{}) {}
+
+// Info does nothing.
+func (DiscardLogger) Info(msg string, args ...interface{}) {}
+
+// Warn does nothing.
+func (DiscardLogger) Warn(msg string, args ...interface{}) {}
+
+// Error does nothing.
+func (DiscardLogger) Error(msg string, args ...interface{}) {}
+
+// LoggerOr returns l, or DefaultLogger if l is nil.
+func LoggerOr(l Logger) Logger {
+	if l != nil {
+		return l
+	}
+	return DefaultLogger
+}
@@ -0,0 +1,21 @@
+//go:build go1.21
+
+package src
+
+import "log/slog"
+
+// a *slog.Logger logs for the library as it is
+var _ Logger = (*slog.Logger)(nil)
+
+// SlogLogger returns a Logger which logs with l, slog.Default() if l is nil.
+func SlogLogger(l *slog.Logger) Logger {
+	if l == nil {
+		return slog.Default()
+	}
+	return l
+}
+
+// NewSlogLogger returns a Logger which logs to h.
+func NewSlogLogger(h slog.Handler) Logger {
+	return slog.New(h)
+}
@@ -0,0 +1,324 @@
+package src
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemoryNetwork is the network of MemoryListeners. Servers Serve and Start on it, and clients
+// dial it with NewDirectRPCClient, so that tests need no ports, like
+//	server.Start(src.MemoryNetwork, "arith")
+//	client := src.NewClient(src.NewMemoryClientSelector("arith"))
+const MemoryNetwork = "mem"
+
+var (
+	errMemoryListenerClosed = errors.New("rpc: memory listener is closed")
+	errMemoryConnReset      = errors.New("rpc: memory connection is reset")
+)
+
+// memoryListeners are the MemoryListeners which are open, by address.
+var memoryListeners = struct {
+	sync.Mutex
+	listeners map[string]*MemoryListener
+	next      int
+}{listeners: make(map[string]*MemoryListener)}
+
+// MemoryListener is a net.Listener whose connections are pipes within the process.
+// The full codec and plugin pipeline runs over them, and Faults can be injected into them.
+type MemoryListener struct {
+	//Faults are injected into the connections dialed while they are set, none if nil
+	Faults FaultInjector
+	//DialTimeout is the timeout of dialing for those that do not set one, none if zero
+	DialTimeout time.Duration
+
+	address string
+	conns   chan net.Conn
+	done    chan struct{}
+	once    sync.Once
+	mu      sync.Mutex
+	dialed  int
+}
+
+// NewMemoryListener creates a MemoryListener at address, or at a new one if address is empty.
+// It fails if another open MemoryListener has the address.
+func NewMemoryListener(address string) (*MemoryListener, error) {
+	memoryListeners.Lock()
+	defer memoryListeners.Unlock()
+
+	if address == "" {
+		memoryListeners.next++
+		address = "mem-" + strconv.Itoa(memoryListeners.next)
+	}
+	if memoryListeners.listeners[address] != nil {
+		return nil, errors.New("rpc: memory address already in use: " + address)
+	}
+
+	l := &MemoryListener{address: address, conns: make(chan net.Conn), done: make(chan struct{})}
+	memoryListeners.listeners[address] = l
+	return l, nil
+}
+
+// DialMemory connects to the MemoryListener at address.
+func DialMemory(address string, timeout time.Duration) (net.Conn, error) {
+	memoryListeners.Lock()
+	l := memoryListeners.listeners[address]
+	memoryListeners.Unlock()
+
+	if l == nil {
+		return nil, &net.OpError{Op: "dial", Net: MemoryNetwork, Addr: memoryAddr(address), Err: errors.New("connection refused")}
+	}
+	return l.Dial(timeout)
+}
+
+// NewMemoryClientSelector creates a selector of the MemoryListener at address.
+func NewMemoryClientSelector(address string) *DirectClientSelector {
+	return &DirectClientSelector{Network: MemoryNetwork, Address: address}
+}
+
+// Accept waits for the next connection dialed to l.
+func (l *MemoryListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, errMemoryListenerClosed
+	}
+}
+
+// Close stops l from accepting connections and frees its address.
+// The connections accepted so far stay open.
+func (l *MemoryListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+		memoryListeners.Lock()
+		if memoryListeners.listeners[l.address] == l {
+			delete(memoryListeners.listeners, l.address)
+		}
+		memoryListeners.Unlock()
+	})
+	return nil
+}
+
+// Addr returns the address of l.
+func (l *MemoryListener) Addr() net.Addr {
+	return memoryAddr(l.address)
+}
+
+// Dial connects to l, waiting for it to accept the connection for timeout, or DialTimeout if zero.
+func (l *MemoryListener) Dial(timeout time.Duration) (net.Conn, error) {
+	if timeout == 0 {
+		timeout = l.DialTimeout
+	}
+
+	l.mu.Lock()
+	l.dialed++
+	n := l.dialed
+	faults := l.Faults
+	l.mu.Unlock()
+
+	client, server := net.Pipe()
+	clientConn := &memoryConn{
+		Conn:   client,
+		local:  memoryAddr(l.address + "#" + strconv.Itoa(n)),
+		remote: memoryAddr(l.address),
+		faults: faults,
+		conn:   n,
+		dir:    ClientToServer,
+	}
+	serverConn := &memoryConn{
+		Conn:   server,
+		local:  clientConn.remote,
+		remote: clientConn.local,
+		faults: faults,
+		conn:   n,
+		dir:    ServerToClient,
+		peer:   clientConn,
+	}
+	clientConn.peer = serverConn
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case l.conns <- serverConn:
+		return clientConn, nil
+	case <-l.done:
+		return nil, &net.OpError{Op: "dial", Net: MemoryNetwork, Addr: l.Addr(), Err: errMemoryListenerClosed}
+	case <-expired:
+		return nil, &net.OpError{Op: "dial", Net: MemoryNetwork, Addr: l.Addr(), Err: errors.New("i/o timeout")}
+	}
+}
+
+// dialMemory connects c to the MemoryListener at address, with the TLSConfig of c if it has one.
+func dialMemory(c *Client, address string, timeout time.Duration) (net.Conn, error) {
+	conn, err := DialMemory(address, timeout)
+	if err != nil || c == nil || c.TLSConfig == nil {
+		return conn, err
+	}
+
+	config := c.TLSConfig
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName = address
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// listen listens on network at address, on a MemoryListener for MemoryNetwork.
+func listen(network, address string) (net.Listener, error) {
+	if network == MemoryNetwork {
+		return NewMemoryListener(address)
+	}
+	return net.Listen(network, address)
+}
+
+// listenTLS is tls.Listen on listen, and like tls.Listen it fails if config has no certificate.
+func listenTLS(network, address string, config *tls.Config) (net.Listener, error) {
+	if config == nil || len(config.Certificates) == 0 && config.GetCertificate == nil && config.GetConfigForClient == nil {
+		return nil, errors.New("tls: neither Certificates, GetCertificate, nor GetConfigForClient set in Config")
+	}
+	ln, err := listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return tls.NewListener(ln, config), nil
+}
+
+type memoryAddr string
+
+func (a memoryAddr) Network() string { return MemoryNetwork }
+func (a memoryAddr) String() string  { return string(a) }
+
+// FaultDirection is the direction of the frames a Fault is injected into.
+type FaultDirection int
+
+const (
+	//AnyDirection matches the frames written by either end
+	AnyDirection FaultDirection = iota
+	//ClientToServer are the frames written by the client
+	ClientToServer
+	//ServerToClient are the frames written by the server
+	ServerToClient
+)
+
+// Fault is what happens to a frame written to a connection of a MemoryListener.
+// Frames are the buffers written to a connection, which hold a message or more
+// depending on how the codec buffers them.
+type Fault struct {
+	//Drop loses the frame, while the writer is told it was written
+	Drop bool
+	//Corrupt inverts the bits of the first byte of the frame
+	Corrupt bool
+	//Delay holds the frame, and those written after it, back for a while
+	Delay time.Duration
+	//Reset closes both ends of the connection instead of writing the frame
+	Reset bool
+}
+
+// FaultInjector decides the Fault of every frame. conn counts the connections of a
+// MemoryListener from 1 in the order they are dialed, and frame counts the frames
+// written by each end from 1 in the order they are written. The same frames are hit
+// in every run as long as an end is written by one goroutine at a time; heartbeats
+// (Client.Heartbeat), Multiplex, pushes and streams write concurrently to the calls,
+// so that their frames are numbered differently from run to run and are better
+// matched by conn and direction only.
+type FaultInjector interface {
+	Fault(conn, frame int, dir FaultDirection) Fault
+}
+
+// FaultFunc is a func which is a FaultInjector.
+type FaultFunc func(conn, frame int, dir FaultDirection) Fault
+
+// Fault returns f(conn, frame, dir).
+func (f FaultFunc) Fault(conn, frame int, dir FaultDirection) Fault {
+	return f(conn, frame, dir)
+}
+
+// FaultRule injects a Fault into the frames it matches.
+type FaultRule struct {
+	//Conn is the number of the connection, any if zero
+	Conn int
+	//Frame is the number of the frame, any if zero
+	Frame     int
+	Direction FaultDirection
+	Fault
+}
+
+// FaultRules is a FaultInjector which injects the Faults of all the rules matching a frame.
+type FaultRules []FaultRule
+
+// Fault merges the Faults of the rules which match the frame, adding up their delays.
+func (r FaultRules) Fault(conn, frame int, dir FaultDirection) Fault {
+	var f Fault
+	for _, rule := range r {
+		if (rule.Conn != 0 && rule.Conn != conn) || (rule.Frame != 0 && rule.Frame != frame) ||
+			(rule.Direction != AnyDirection && rule.Direction != dir) {
+			continue
+		}
+		f.Drop = f.Drop || rule.Drop
+		f.Corrupt = f.Corrupt || rule.Corrupt
+		f.Delay += rule.Delay
+		f.Reset = f.Reset || rule.Reset
+	}
+	return f
+}
+
+// memoryConn is an end of a connection of a MemoryListener.
+type memoryConn struct {
+	net.Conn
+	local, remote memoryAddr
+	faults        FaultInjector
+	conn          int
+	dir           FaultDirection
+	peer          *memoryConn
+
+	//mu keeps the frames in the order they are counted
+	mu     sync.Mutex
+	frames int
+}
+
+func (c *memoryConn) LocalAddr() net.Addr  { return c.local }
+func (c *memoryConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *memoryConn) Write(b []byte) (int, error) {
+	if c.faults == nil {
+		return c.Conn.Write(b)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.frames++
+	f := c.faults.Fault(c.conn, c.frames, c.dir)
+
+	if f.Delay > 0 {
+		time.Sleep(f.Delay)
+	}
+	if f.Reset {
+		c.Conn.Close()
+		c.peer.Conn.Close()
+		return 0, &net.OpError{Op: "write", Net: MemoryNetwork, Source: c.local, Addr: c.remote, Err: errMemoryConnReset}
+	}
+	if f.Drop {
+		return len(b), nil
+	}
+	if f.Corrupt && len(b) > 0 {
+		corrupted := make([]byte, len(b))
+		copy(corrupted, b)
+		corrupted[0] = ^corrupted[0]
+		b = corrupted
+	}
+	return c.Conn.Write(b)
+}
@@ -0,0 +1,154 @@
+package src
+
+import (
+	"context"
+	"net/rpc/jsonrpc"
+	"testing"
+	"time"
+)
+
+type MemoryArgs struct {
+	A, B int
+}
+
+type MemoryArith int
+
+func (t *MemoryArith) Mul(args *MemoryArgs, reply *int) error {
+	*reply = args.A * args.B
+	return nil
+}
+
+// startMemoryServer serves MemoryArith on a new MemoryListener with faults.
+func startMemoryServer(t *testing.T, faults FaultInjector) *MemoryListener {
+	ln, err := NewMemoryListener("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Faults = faults
+
+	s := NewServer()
+	s.ServerCodecFunc = jsonrpc.NewServerCodec
+	s.RegisterName("Arith", new(MemoryArith))
+	go s.ServeListener(ln)
+	t.Cleanup(func() { ln.Close() })
+	return ln
+}
+
+func newMemoryClient(ln *MemoryListener) *Client {
+	c := NewClient(NewMemoryClientSelector(ln.Addr().String()))
+	c.ClientCodecFunc = jsonrpc.NewClientCodec
+	return c
+}
+
+func callMul(c *Client, timeout time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var reply int
+	err := c.CallContext(ctx, "Arith.Mul", &MemoryArgs{6, 7}, &reply)
+	return reply, err
+}
+
+func TestMemoryTransport(t *testing.T) {
+	ln := startMemoryServer(t, nil)
+	c := newMemoryClient(ln)
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		if reply, err := callMul(c, time.Second); err != nil || reply != 42 {
+			t.Fatalf("call %d: reply %d, err %v", i, reply, err)
+		}
+	}
+	if addr := ln.Addr(); addr.Network() != MemoryNetwork {
+		t.Errorf("network of %s is %q", addr, addr.Network())
+	}
+}
+
+func TestMemoryListenerAddress(t *testing.T) {
+	ln, err := NewMemoryListener("memory-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewMemoryListener("memory-test"); err == nil {
+		t.Fatal("listening twice at an address succeeded")
+	}
+	ln.Close()
+	if _, err := DialMemory("memory-test", time.Second); err == nil {
+		t.Fatal("dialing a closed listener succeeded")
+	}
+	ln, err = NewMemoryListener("memory-test")
+	if err != nil {
+		t.Fatal("address not freed by Close:", err)
+	}
+	ln.Close()
+}
+
+func TestMemoryServerStart(t *testing.T) {
+	s := NewServer()
+	s.ServerCodecFunc = jsonrpc.NewServerCodec
+	s.RegisterName("Arith", new(MemoryArith))
+	s.Start(MemoryNetwork, "memory-test-start")
+	defer s.Close()
+
+	c := NewClient(NewMemoryClientSelector("memory-test-start"))
+	c.ClientCodecFunc = jsonrpc.NewClientCodec
+	defer c.Close()
+	if reply, err := callMul(c, time.Second); err != nil || reply != 42 {
+		t.Fatalf("reply %d, err %v", reply, err)
+	}
+}
+
+func TestMemoryFaults(t *testing.T) {
+	for name, fault := range map[string]Fault{
+		"drop":    {Drop: true},
+		"corrupt": {Corrupt: true},
+		"reset":   {Reset: true},
+	} {
+		ln := startMemoryServer(t, FaultRules{{Conn: 1, Frame: 1, Direction: ServerToClient, Fault: fault}})
+		c := newMemoryClient(ln)
+		if _, err := callMul(c, 300*time.Millisecond); err == nil {
+			t.Errorf("%s: call succeeded", name)
+		}
+		c.Close()
+	}
+}
+
+func TestMemoryFaultRulesMatch(t *testing.T) {
+	ln := startMemoryServer(t, FaultRules{{Conn: 1, Frame: 2, Direction: ServerToClient, Fault: Fault{Drop: true}}})
+	c := newMemoryClient(ln)
+	defer c.Close()
+
+	if reply, err := callMul(c, time.Second); err != nil || reply != 42 {
+		t.Fatalf("first call: reply %d, err %v", reply, err)
+	}
+	if _, err := callMul(c, 300*time.Millisecond); err == nil {
+		t.Fatal("second call succeeded although its reply was dropped")
+	}
+
+	rules := FaultRules{
+		{Direction: ClientToServer, Fault: Fault{Delay: time.Second}},
+		{Conn: 2, Fault: Fault{Delay: time.Second, Corrupt: true}},
+	}
+	if f := rules.Fault(1, 1, ServerToClient); f != (Fault{}) {
+		t.Errorf("fault %+v matched no rule", f)
+	}
+	if f := rules.Fault(2, 5, ClientToServer); f != (Fault{Delay: 2 * time.Second, Corrupt: true}) {
+		t.Errorf("faults of two rules merged into %+v", f)
+	}
+}
+
+func TestMemoryFaultDelay(t *testing.T) {
+	ln := startMemoryServer(t, FaultRules{{Direction: ServerToClient, Fault: Fault{Delay: 100 * time.Millisecond}}})
+	c := newMemoryClient(ln)
+	defer c.Close()
+
+	start := time.Now()
+	if reply, err := callMul(c, time.Second); err != nil || reply != 42 {
+		t.Fatalf("reply %d, err %v", reply, err)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("delayed reply took %v", d)
+	}
+	if _, err := callMul(c, 50*time.Millisecond); err == nil {
+		t.Error("call outlasting its timeout succeeded")
+	}
+}
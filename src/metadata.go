@@ -0,0 +1,75 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// Metadata is extra info sent along with a request, like the ids of a trace.
+type Metadata map[string]string
+
+// Copy returns a copy of md which can be changed without affecting md.
+func (md Metadata) Copy() Metadata {
+	c := make(Metadata, len(md))
+	for k, v := range md {
+		c[k] = v
+	}
+	return c
+}
+
+type outgoingMetadataKey struct{}
+
+type incomingMetadataKey struct{}
+
+// NewOutgoingContext returns a copy of ctx which makes Client.CallContext send md with the request.
+func NewOutgoingContext(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, outgoingMetadataKey{}, md)
+}
+
+// OutgoingMetadataFromContext returns the metadata which Client.CallContext sends for ctx.
+func OutgoingMetadataFromContext(ctx context.Context) (Metadata, bool) {
+	md, ok := ctx.Value(outgoingMetadataKey{}).(Metadata)
+	return md, ok
+}
+
+// MetadataFromContext returns the metadata which the client sent along with the request
+// handled with ctx.
+func MetadataFromContext(ctx context.Context) (Metadata, bool) {
+	md, ok := ctx.Value(incomingMetadataKey{}).(Metadata)
+	return md, ok
+}
+
+// withMetadata prefixes serviceMethod with md, to be sent in the request header.
+func withMetadata(serviceMethod string, md Metadata) string {
+	if len(md) == 0 {
+		return serviceMethod
+	}
+
+	values := make(url.Values, len(md))
+	for k, v := range md {
+		values.Set(k, v)
+	}
+	// the encoded values contain no '|', which ends them
+	return metadataPrefix + values.Encode() + "|" + serviceMethod
+}
+
+// splitMetadata undoes withMetadata.
+func splitMetadata(serviceMethod string) (Metadata, string, error) {
+	s := serviceMethod[len(metadataPrefix):]
+	i := strings.IndexByte(s, '|')
+	if i < 0 {
+		return nil, serviceMethod, errors.New("rpc: malformed request metadata")
+	}
+
+	values, err := url.ParseQuery(s[:i])
+	if err != nil {
+		return nil, serviceMethod, errors.New("rpc: malformed request metadata: " + err.Error())
+	}
+	md := make(Metadata, len(values))
+	for k := range values {
+		md[k] = values.Get(k)
+	}
+	return md, s[i+1:], nil
+}
@@ -0,0 +1,252 @@
+package src
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyBuckets are the upper bounds in seconds of the latency histograms.
+var DefaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// methodStats are the metrics of a service method.
+type methodStats struct {
+	requests uint64
+	errors   uint64
+	buckets  []uint64 // not cumulative
+	count    uint64   // observed latencies
+	sum      float64
+}
+
+// callMetrics records the calls seen by a metrics plugin.
+type callMetrics struct {
+	buckets []float64
+
+	mu       sync.Mutex
+	inFlight int
+	methods  map[string]*methodStats
+}
+
+// callStartKey is the key of the start time of a call in its context, one per callMetrics.
+type callStartKey struct{ m *callMetrics }
+
+func newCallMetrics(buckets []float64) *callMetrics {
+	if buckets == nil {
+		buckets = DefaultLatencyBuckets
+	}
+	return &callMetrics{
+		buckets: buckets,
+		methods: make(map[string]*methodStats),
+	}
+}
+
+// start records the start of a call and returns its context, which carries the start time to finish.
+func (m *callMetrics) start(ctx context.Context) context.Context {
+	m.mu.Lock()
+	m.inFlight++
+	m.mu.Unlock()
+	return context.WithValue(ctx, callStartKey{m}, time.Now())
+}
+
+// finish records a completed call. The latency is unknown if the start was not seen,
+// because an earlier plugin rejected the call.
+func (m *callMetrics) finish(ctx context.Context, serviceMethod string, err error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st := m.methods[serviceMethod]
+	if st == nil {
+		st = &methodStats{buckets: make([]uint64, len(m.buckets))}
+		m.methods[serviceMethod] = st
+	}
+	st.requests++
+	if err != nil {
+		st.errors++
+	}
+
+	var start time.Time
+	if ctx != nil {
+		start, _ = ctx.Value(callStartKey{m}).(time.Time)
+	}
+	if start.IsZero() {
+		return
+	}
+	m.inFlight--
+
+	d := now.Sub(start).Seconds()
+	st.count++
+	st.sum += d
+	for i, b := range m.buckets {
+		if d <= b {
+			st.buckets[i]++
+			break
+		}
+	}
+}
+
+// write writes the metrics in the Prometheus text exposition format.
+// side is "server" or "client" and conns is the number of open connections.
+func (m *callMetrics) write(w io.Writer, side string, conns int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.methods))
+	for name := range m.methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	prefix := "rpct_" + side + "_"
+
+	fmt.Fprintf(w, "# HELP %srequests_total Calls completed by service method.\n", prefix)
+	fmt.Fprintf(w, "# TYPE %srequests_total counter\n", prefix)
+	for _, name := range names {
+		fmt.Fprintf(w, "%srequests_total{method=%s} %d\n", prefix, quoteLabel(name), m.methods[name].requests)
+	}
+
+	fmt.Fprintf(w, "# HELP %serrors_total Calls completed with an error by service method.\n", prefix)
+	fmt.Fprintf(w, "# TYPE %serrors_total counter\n", prefix)
+	for _, name := range names {
+		fmt.Fprintf(w, "%serrors_total{method=%s} %d\n", prefix, quoteLabel(name), m.methods[name].errors)
+	}
+
+	fmt.Fprintf(w, "# HELP %srequest_duration_seconds Latency of calls by service method.\n", prefix)
+	fmt.Fprintf(w, "# TYPE %srequest_duration_seconds histogram\n", prefix)
+	for _, name := range names {
+		st := m.methods[name]
+		label := quoteLabel(name)
+		var cumulative uint64
+		for i, b := range m.buckets {
+			cumulative += st.buckets[i]
+			fmt.Fprintf(w, "%srequest_duration_seconds_bucket{method=%s,le=\"%s\"} %d\n",
+				prefix, label, strconv.FormatFloat(b, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "%srequest_duration_seconds_bucket{method=%s,le=\"+Inf\"} %d\n", prefix, label, st.count)
+		fmt.Fprintf(w, "%srequest_duration_seconds_sum{method=%s} %s\n", prefix, label, strconv.FormatFloat(st.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "%srequest_duration_seconds_count{method=%s} %d\n", prefix, label, st.count)
+	}
+
+	fmt.Fprintf(w, "# HELP %sin_flight Calls in flight.\n", prefix)
+	fmt.Fprintf(w, "# TYPE %sin_flight gauge\n", prefix)
+	fmt.Fprintf(w, "%sin_flight %d\n", prefix, m.inFlight)
+
+	fmt.Fprintf(w, "# HELP %sconnections Open connections.\n", prefix)
+	fmt.Fprintf(w, "# TYPE %sconnections gauge\n", prefix)
+	fmt.Fprintf(w, "%sconnections %d\n", prefix, conns)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabel(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+// metricsWriter is implemented by the plugins whose metrics MetricsServerPlugin serves along with its own.
+type metricsWriter interface {
+	writeMetrics(w io.Writer)
+}
+
+// MetricsServerPlugin counts the calls of a Server and measures their latency by service method,
+// from the call plugins before it to the return of the handler. Requests which fail before
+// the call plugins run, like those of unknown methods, are not counted.
+// It is a call plugin rather than a PostReadRequestHeader and PostWriteResponse plugin since
+// those see only the seq of a request, which is unique on its connection alone: with several
+// connections, a response cannot be told apart from the responses of other connections with its seq.
+// It is also a http.Handler which serves the metrics in the Prometheus text format,
+// with those of the AdaptiveLimiterPlugin and the CertificateProvider of the server if it has them.
+type MetricsServerPlugin struct {
+	server  *Server
+	metrics *callMetrics
+}
+
+// NewMetricsServerPlugin creates a MetricsServerPlugin for s, which must be added to s.PluginContainer.
+// buckets are the upper bounds of the latency histograms, DefaultLatencyBuckets if nil.
+func NewMetricsServerPlugin(s *Server, buckets []float64) *MetricsServerPlugin {
+	return &MetricsServerPlugin{server: s, metrics: newCallMetrics(buckets)}
+}
+
+// PreCall starts timing a call.
+func (plugin *MetricsServerPlugin) PreCall(ctx context.Context, info *CallInfo) (context.Context, error) {
+	return plugin.metrics.start(ctx), nil
+}
+
+// PostCall records a completed call.
+func (plugin *MetricsServerPlugin) PostCall(ctx context.Context, info *CallInfo, err error) {
+	plugin.metrics.finish(ctx, info.ServiceMethod, err)
+}
+
+// ServeHTTP writes the metrics.
+func (plugin *MetricsServerPlugin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	plugin.metrics.write(w, "server", len(plugin.server.Conns()))
+	for _, p := range plugin.server.PluginContainer.GetAll() {
+		if mw, ok := p.(metricsWriter); ok {
+			mw.writeMetrics(w)
+		}
+	}
+}
+
+// Name return name of this plugin.
+func (plugin *MetricsServerPlugin) Name() string {
+	return "MetricsServerPlugin"
+}
+
+// Description return description of this plugin.
+func (plugin *MetricsServerPlugin) Description() string {
+	return "a metrics plugin which serves Prometheus metrics of the server"
+}
+
+// MetricsClientPlugin counts the calls of a Client and measures their latency by service method.
+// It sees the calls which run the call plugins, made with Call and CallContext, and counts each
+// attempt of Failover and Failtry calls; Go, Send and NewStream are not counted.
+// It is also a http.Handler which serves the metrics in the Prometheus text format,
+// with those of the CertificateProvider of the client if it has one.
+type MetricsClientPlugin struct {
+	client  *Client
+	metrics *callMetrics
+}
+
+// NewMetricsClientPlugin creates a MetricsClientPlugin for c, which must be added to c.PluginContainer.
+// buckets are the upper bounds of the latency histograms, DefaultLatencyBuckets if nil.
+func NewMetricsClientPlugin(c *Client, buckets []float64) *MetricsClientPlugin {
+	return &MetricsClientPlugin{client: c, metrics: newCallMetrics(buckets)}
+}
+
+// PreCall starts timing a call.
+func (plugin *MetricsClientPlugin) PreCall(ctx context.Context, info *CallInfo) (context.Context, error) {
+	return plugin.metrics.start(ctx), nil
+}
+
+// PostCall records a completed call.
+func (plugin *MetricsClientPlugin) PostCall(ctx context.Context, info *CallInfo, err error) {
+	plugin.metrics.finish(ctx, info.ServiceMethod, err)
+}
+
+// ServeHTTP writes the metrics.
+func (plugin *MetricsClientPlugin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	plugin.metrics.write(w, "client", plugin.client.pool.len())
+	for _, p := range plugin.client.PluginContainer.GetAll() {
+		if mw, ok := p.(metricsWriter); ok {
+			mw.writeMetrics(w)
+		}
+	}
+}
+
+// Name return name of this plugin.
+func (plugin *MetricsClientPlugin) Name() string {
+	return "MetricsClientPlugin"
+}
+
+// Description return description of this plugin.
+func (plugin *MetricsClientPlugin) Description() string {
+	return "a metrics plugin which serves Prometheus metrics of the client"
+}
@@ -0,0 +1,71 @@
+package src
+
+import (
+	"net/http/httptest"
+	"net/rpc/jsonrpc"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type MetricsSleeper int
+
+// Sleep sleeps args.A milliseconds.
+func (t *MetricsSleeper) Sleep(args *MemoryArgs, reply *int) error {
+	time.Sleep(time.Duration(args.A) * time.Millisecond)
+	return nil
+}
+
+// TestMetricsServerConns times calls of two connections at once, whose requests share
+// their seqs, and which PostReadRequestHeader and PostWriteResponse could not tell apart.
+func TestMetricsServerConns(t *testing.T) {
+	ln, err := NewMemoryListener("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	s := NewServer()
+	s.ServerCodecFunc = jsonrpc.NewServerCodec
+	plugin := NewMetricsServerPlugin(s, []float64{.1, 10})
+	s.PluginContainer.Add(plugin)
+	s.RegisterName("Sleeper", new(MetricsSleeper))
+	go s.ServeListener(ln)
+
+	slow, fast := newMemoryClient(ln), newMemoryClient(ln)
+	defer slow.Close()
+	defer fast.Close()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := slow.Call("Sleeper.Sleep", &MemoryArgs{A: 300}, new(int)); err != nil {
+			t.Error(err)
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if err := fast.Call("Sleeper.Sleep", &MemoryArgs{A: 0}, new(int)); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	// the server records a call after it has replied to it
+	var rec *httptest.ResponseRecorder
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		rec = httptest.NewRecorder()
+		plugin.ServeHTTP(rec, nil)
+		if strings.Contains(rec.Body.String(), "rpct_server_in_flight 0") || time.Now().After(deadline) {
+			break
+		}
+	}
+	for _, want := range []string{
+		`rpct_server_requests_total{method="Sleeper.Sleep"} 2`,
+		`rpct_server_request_duration_seconds_bucket{method="Sleeper.Sleep",le="0.1"} 1`,
+		`rpct_server_request_duration_seconds_bucket{method="Sleeper.Sleep",le="10"} 2`,
+		`rpct_server_in_flight 0`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("no %s in\n%s", want, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package src
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"strings"
+)
+
+// Principal is the identity of a client proven by the certificate it presented over mutual TLS.
+type Principal struct {
+	//Name is the SPIFFE ID of the certificate if it has one, else the common name of its subject,
+	//unless MTLSAuthPlugin.PrincipalFunc maps it otherwise
+	Name       string
+	CommonName string
+	DNSNames   []string
+	URIs       []string
+	//SPIFFEID is the URI SAN of the certificate with the spiffe scheme, like "spiffe://example.org/ns/prod/sa/billing"
+	SPIFFEID    string
+	Certificate *x509.Certificate
+}
+
+// newPrincipal returns the principal of the verified client certificate of a TLS connection, or nil.
+func newPrincipal(cs tls.ConnectionState) *Principal {
+	if len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cert := cs.VerifiedChains[0][0]
+
+	p := &Principal{
+		CommonName:  cert.Subject.CommonName,
+		DNSNames:    cert.DNSNames,
+		Certificate: cert,
+	}
+	for _, u := range cert.URIs {
+		p.URIs = append(p.URIs, u.String())
+		if u.Scheme == "spiffe" && p.SPIFFEID == "" {
+			p.SPIFFEID = u.String()
+		}
+	}
+	p.Name = p.SPIFFEID
+	if p.Name == "" {
+		p.Name = p.CommonName
+	}
+	return p
+}
+
+// PeerPrincipal returns the principal of the client of conn, if conn is a TLS connection
+// whose client presented a certificate which the server verified.
+func PeerPrincipal(conn net.Conn) (*Principal, bool) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil, false
+	}
+	p := newPrincipal(tlsConn.ConnectionState())
+	return p, p != nil
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext returns the principal of the client of the call handled with ctx,
+// if the server uses MTLSAuthPlugin.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// MTLSRule allows or denies principals to call service methods.
+type MTLSRule struct {
+	//ServiceMethod is a service method like "Arith.Mul", all the methods of a service like "Arith.*", or "*"
+	ServiceMethod string
+	//Principals are the names of principals, or prefixes of them ending with "*" like "spiffe://example.org/*"
+	Principals []string
+	//Deny makes the rule deny instead of allow
+	Deny bool
+}
+
+// matchPattern reports whether s is matched by pattern, which matches every string
+// beginning with its prefix if it ends with "*".
+func matchPattern(pattern, s string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(s, pattern[:len(pattern)-1])
+	}
+	return pattern == s
+}
+
+func (rule *MTLSRule) matches(serviceMethod, principal string) bool {
+	if !matchPattern(rule.ServiceMethod, serviceMethod) {
+		return false
+	}
+	for _, p := range rule.Principals {
+		if matchPattern(p, principal) {
+			return true
+		}
+	}
+	return false
+}
+
+// MTLSAuthPlugin authenticates the clients of a Server by the certificates they present over mutual TLS,
+// see ServeTLS and NewMTLSServerConfig, and authorizes their calls by rules.
+// Calls over connections without a verified client certificate are rejected.
+// Handlers find the principal of the client with PrincipalFromContext.
+type MTLSAuthPlugin struct {
+	//PrincipalFunc maps a principal to the name the rules match, Principal.Name if nil
+	PrincipalFunc func(p *Principal) (string, error)
+	//Rules decide like the rules of a RBACPolicy: a call is denied if a deny rule matches it,
+	//else allowed if an allow rule matches it, and else denied. Their order does not matter.
+	//Every call is allowed if there are no rules.
+	Rules []MTLSRule
+}
+
+// NewMTLSAuthPlugin creates a MTLSAuthPlugin with rules.
+func NewMTLSAuthPlugin(rules ...MTLSRule) *MTLSAuthPlugin {
+	return &MTLSAuthPlugin{Rules: rules}
+}
+
+// PreCall authenticates the client of a call and checks that it may call the service method.
+func (plugin *MTLSAuthPlugin) PreCall(ctx context.Context, info *CallInfo) (context.Context, error) {
+	sc, ok := ServerConnFromContext(ctx)
+	if !ok {
+		return ctx, errors.New("rpc: unauthenticated: no connection")
+	}
+	cs, ok := sc.TLSConnectionState()
+	if !ok {
+		return ctx, errors.New("rpc: unauthenticated: connection is not TLS")
+	}
+	p := newPrincipal(cs)
+	if p == nil {
+		return ctx, errors.New("rpc: unauthenticated: no verified client certificate")
+	}
+
+	if plugin.PrincipalFunc != nil {
+		name, err := plugin.PrincipalFunc(p)
+		if err != nil {
+			return ctx, errors.New("rpc: unauthenticated: " + err.Error())
+		}
+		p.Name = name
+	}
+
+	if !plugin.allows(info.ServiceMethod, p.Name) {
+		return ctx, errors.New("rpc: permission denied: " + p.Name + " may not call " + info.ServiceMethod)
+	}
+	return context.WithValue(ctx, principalKey{}, p), nil
+}
+
+// allows reports whether the rules allow principal to call serviceMethod.
+func (plugin *MTLSAuthPlugin) allows(serviceMethod, principal string) bool {
+	allowed := len(plugin.Rules) == 0
+	for i := range plugin.Rules {
+		if plugin.Rules[i].matches(serviceMethod, principal) {
+			if plugin.Rules[i].Deny {
+				return false
+			}
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+// Name return name of this plugin.
+func (plugin *MTLSAuthPlugin) Name() string {
+	return "MTLSAuthPlugin"
+}
+
+// Description return description of this plugin.
+func (plugin *MTLSAuthPlugin) Description() string {
+	return "a mutual TLS plugin which authorizes clients by their certificates"
+}
+
+// loadCertPool reads the PEM encoded certificates of the file at path.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("rpc: no certificates in " + path)
+	}
+	return pool, nil
+}
+
+// NewMTLSServerConfig creates the tls.Config of a server which presents the certificate in certFile and keyFile
+// and requires clients to present a certificate signed by one of the CAs in clientCAFile.
+func NewMTLSServerConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := loadCertPool(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
+// NewMTLSClientConfig creates the tls.Config of a client which presents the certificate in certFile and keyFile
+// and verifies servers with the CAs in caFile, or with the system CAs if caFile is empty. Set it as Client.TLSConfig, and every connection
+// the selector dials presents the certificate; the server name is taken from the address dialed.
+func NewMTLSClientConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if caFile != "" {
+		if config.RootCAs, err = loadCertPool(caFile); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
@@ -0,0 +1,152 @@
+package src
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/rpc/jsonrpc"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewMTLSClientConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeTestCert(t, dir, "client", testCertTemplate(1, "client"), nil, nil)
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+
+	config, err := NewMTLSClientConfig(certFile, keyFile, "")
+	if err != nil {
+		t.Fatal("no CA file:", err)
+	}
+	if config.RootCAs != nil || len(config.Certificates) != 1 {
+		t.Fatalf("no CA file: roots %v, %d certificates", config.RootCAs, len(config.Certificates))
+	}
+	if config, err = NewMTLSClientConfig(certFile, keyFile, certFile); err != nil {
+		t.Fatal("CA file:", err)
+	}
+	if config.RootCAs == nil {
+		t.Fatal("CA file not loaded")
+	}
+	if _, err := NewMTLSClientConfig(certFile, keyFile, filepath.Join(dir, "missing.crt")); err == nil {
+		t.Fatal("missing CA file loaded")
+	}
+}
+
+func TestMTLSRules(t *testing.T) {
+	plugin := NewMTLSAuthPlugin(
+		MTLSRule{ServiceMethod: "*", Principals: []string{"spiffe://example.org/*"}},
+		MTLSRule{ServiceMethod: "Admin.*", Principals: []string{"spiffe://example.org/*"}, Deny: true},
+		MTLSRule{ServiceMethod: "Admin.Get", Principals: []string{"spiffe://example.org/ops"}},
+		MTLSRule{ServiceMethod: "Arith.Mul", Principals: []string{"billing"}},
+		MTLSRule{ServiceMethod: "Arith.*", Principals: []string{"spiffe://example.org/untrusted"}, Deny: true},
+	)
+	for _, tt := range []struct {
+		method, principal string
+		allowed           bool
+	}{
+		{"Arith.Mul", "spiffe://example.org/billing", true},
+		{"Arith.Mul", "billing", true},
+		{"Arith.Add", "billing", false},
+		{"Arith.Mul", "spiffe://other.org/billing", false},
+		// a deny rule wins over allow rules, wherever they are
+		{"Admin.Get", "spiffe://example.org/billing", false},
+		{"Admin.Get", "spiffe://example.org/ops", false},
+		{"Arith.Mul", "spiffe://example.org/untrusted", false},
+		{"Echo.Say", "spiffe://example.org/untrusted", true},
+		// patterns match whole names unless they end with "*"
+		{"Arith.Mul", "billing2", false},
+		{"Arith.Mul", "spiffe://example.org", false},
+	} {
+		if allowed := plugin.allows(tt.method, tt.principal); allowed != tt.allowed {
+			t.Errorf("%s calling %s: allowed %v, want %v", tt.principal, tt.method, allowed, tt.allowed)
+		}
+	}
+
+	if !NewMTLSAuthPlugin().allows("Arith.Mul", "anyone") {
+		t.Error("call denied without rules")
+	}
+}
+
+type MTLSWho int
+
+// Name replies the name of the principal of the client.
+func (t *MTLSWho) Name(ctx context.Context, args *MemoryArgs, reply *string) error {
+	if p, ok := PrincipalFromContext(ctx); ok {
+		*reply = p.Name
+	}
+	return nil
+}
+
+func TestMTLSAuthPlugin(t *testing.T) {
+	dir := t.TempDir()
+	caTemplate := testCertTemplate(1, "ca")
+	caTemplate.IsCA, caTemplate.BasicConstraintsValid = true, true
+	caTemplate.KeyUsage = x509.KeyUsageCertSign
+	ca, caKey := writeTestCert(t, dir, "ca", caTemplate, nil, nil)
+	serverTemplate := testCertTemplate(2, "server")
+	serverTemplate.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	serverTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	writeTestCert(t, dir, "server", serverTemplate, ca, caKey)
+	for i, id := range []string{"spiffe://example.org/billing", "spiffe://example.org/audit"} {
+		u, _ := url.Parse(id)
+		clientTemplate := testCertTemplate(int64(3+i), "client")
+		clientTemplate.URIs = []*url.URL{u}
+		clientTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+		writeTestCert(t, dir, id[strings.LastIndex(id, "/")+1:], clientTemplate, ca, caKey)
+	}
+	file := func(name string) string { return filepath.Join(dir, name) }
+
+	serverConfig, err := NewMTLSServerConfig(file("server.crt"), file("server.key"), file("ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	s := NewServer()
+	s.ServerCodecFunc = jsonrpc.NewServerCodec
+	plugin := NewMTLSAuthPlugin(MTLSRule{ServiceMethod: "Who.*", Principals: []string{"billing"}})
+	plugin.PrincipalFunc = func(p *Principal) (string, error) {
+		return strings.TrimPrefix(p.SPIFFEID, "spiffe://example.org/"), nil
+	}
+	s.PluginContainer.Add(plugin)
+	s.RegisterName("Who", new(MTLSWho))
+	go s.ServeListener(ln)
+
+	call := func(config *tls.Config) (string, error) {
+		c := NewClient(&DirectClientSelector{Network: "tcp", Address: ln.Addr().String(), DialTimeout: time.Second})
+		c.ClientCodecFunc = jsonrpc.NewClientCodec
+		c.TLSConfig = config
+		defer c.Close()
+		var name string
+		err := c.Call("Who.Name", &MemoryArgs{}, &name)
+		return name, err
+	}
+	for _, tt := range []struct {
+		client string
+		want   string
+	}{
+		{"billing", "billing"},
+		{"audit", ""},
+		{"", ""},
+	} {
+		config := &tls.Config{RootCAs: serverConfig.ClientCAs}
+		if tt.client != "" {
+			if config, err = NewMTLSClientConfig(file(tt.client+".crt"), file(tt.client+".key"), file("ca.crt")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		name, err := call(config)
+		if tt.want == "" && err == nil {
+			t.Errorf("client %q allowed as %q", tt.client, name)
+		} else if tt.want != "" && (err != nil || name != tt.want) {
+			t.Errorf("client %q: principal %q, err %v", tt.client, name, err)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package src
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// muxPreface is written first by clients which multiplex their connection.
+	muxPreface = "RPCTMUX1"
+	// muxFrameSize is the largest payload of a frame. Larger messages are split
+	// so that the frames of other messages can be sent in between.
+	muxFrameSize = 16 << 10
+	// muxLastFrame marks the last frame of a message in the length of its header.
+	muxLastFrame = 1 << 31
+	// muxCloseTimeout bounds the time Close spends sending the queued messages.
+	muxCloseTimeout = time.Second
+
+	// muxMaxMessageSize is the largest message a peer may send.
+	muxMaxMessageSize = 64 << 20
+	// muxMaxPartial is the number of messages a peer may have started without finishing them,
+	// and muxMaxPartialBytes the bytes they may hold together.
+	muxMaxPartial      = 256
+	muxMaxPartialBytes = 128 << 20
+	// muxMaxReady is the number of received messages which wait for the codec.
+	// No more frames are read while it is reached.
+	muxMaxReady = 64
+)
+
+var errMuxClosed = errors.New("rpc: multiplexed connection is closed")
+
+// muxConn interleaves the messages which a codec writes to a connection,
+// so that a large message does not hold back the messages written after it.
+//
+// Every message is split into frames of at most muxFrameSize bytes. A frame starts with
+// the id of its message and the length of its payload, both 4 bytes in big endian.
+// Messages are handed to the reading codec in the order they complete, so the codec
+// must encode every message on its own (msgpack does, gob does not)
+// and the writing side must call endMessage after each one.
+type muxConn struct {
+	net.Conn
+
+	wmu        sync.Mutex
+	wcond      *sync.Cond
+	msg        []byte        // the message being written by the codec
+	nextID     uint32        // id of the next message
+	queue      []*muxMessage // messages being sent
+	werr       error
+	closing    bool
+	writerDone chan struct{}
+
+	rmu     sync.Mutex
+	rcond   *sync.Cond // signalled when ready, rerr or rclosed change
+	ready   [][]byte   // received messages which the codec has not read yet
+	rerr    error
+	rclosed bool // Close was called, the reader stops waiting for room in ready
+}
+
+type muxMessage struct {
+	id   uint32
+	data []byte
+}
+
+func newMuxConn(conn net.Conn) *muxConn {
+	c := &muxConn{Conn: conn, writerDone: make(chan struct{})}
+	c.wcond = sync.NewCond(&c.wmu)
+	c.rcond = sync.NewCond(&c.rmu)
+	go c.writeLoop()
+	go c.readLoop()
+	return c
+}
+
+// Write appends p to the message being written.
+func (c *muxConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.werr != nil {
+		return 0, c.werr
+	}
+	if c.closing {
+		return 0, errMuxClosed
+	}
+	c.msg = append(c.msg, p...)
+	return len(p), nil
+}
+
+// endMessage queues the message written since the previous call for sending.
+// The message is dropped if the codec failed to write it.
+func (c *muxConn) endMessage(failed bool) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if !failed && len(c.msg) > 0 && c.werr == nil {
+		c.queue = append(c.queue, &muxMessage{id: c.nextID, data: c.msg})
+		c.nextID++
+		c.wcond.Signal()
+	}
+	c.msg = nil
+}
+
+// writeLoop sends one frame of every queued message in turn.
+func (c *muxConn) writeLoop() {
+	defer close(c.writerDone)
+
+	bw := bufio.NewWriterSize(c.Conn, 8+muxFrameSize)
+	var hdr [8]byte
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	for {
+		for len(c.queue) == 0 && c.werr == nil && !c.closing {
+			c.wcond.Wait()
+		}
+		if c.werr != nil || len(c.queue) == 0 {
+			return
+		}
+
+		msgs := c.queue
+		c.wmu.Unlock()
+
+		var err error
+		for _, m := range msgs {
+			n := len(m.data)
+			if n > muxFrameSize {
+				n = muxFrameSize
+			}
+			length := uint32(n)
+			if n == len(m.data) {
+				length |= muxLastFrame
+			}
+			binary.BigEndian.PutUint32(hdr[:4], m.id)
+			binary.BigEndian.PutUint32(hdr[4:], length)
+			if _, err = bw.Write(hdr[:]); err != nil {
+				break
+			}
+			if _, err = bw.Write(m.data[:n]); err != nil {
+				break
+			}
+			m.data = m.data[n:]
+		}
+
+		c.wmu.Lock()
+		if err == nil {
+			// the queue may have grown while sending, but only msgs were sent
+			queue := c.queue[:0]
+			for i, m := range c.queue {
+				if i >= len(msgs) || len(m.data) > 0 {
+					queue = append(queue, m)
+				}
+			}
+			c.queue = queue
+
+			if len(c.queue) == 0 {
+				c.wmu.Unlock()
+				err = bw.Flush()
+				c.wmu.Lock()
+			}
+		}
+		if err != nil {
+			c.werr = err
+			c.queue = nil
+			c.Conn.Close()
+		}
+	}
+}
+
+// readLoop reassembles the frames of received messages.
+// A peer which exceeds the limits of messages in progress breaks the connection.
+func (c *muxConn) readLoop() {
+	br := bufio.NewReader(c.Conn)
+	partial := make(map[uint32][]byte)
+	partialBytes := 0
+	var hdr [8]byte
+
+	var err error
+	for {
+		if _, err = io.ReadFull(br, hdr[:]); err != nil {
+			break
+		}
+		id := binary.BigEndian.Uint32(hdr[:4])
+		length := binary.BigEndian.Uint32(hdr[4:])
+		n := int(length &^ muxLastFrame)
+		if n > muxFrameSize {
+			err = errors.New("rpc: multiplexed frame is too large")
+			break
+		}
+
+		buf, ok := partial[id]
+		if !ok && len(partial) >= muxMaxPartial {
+			err = errors.New("rpc: too many multiplexed messages in progress")
+			break
+		}
+		if len(buf)+n > muxMaxMessageSize {
+			err = errors.New("rpc: multiplexed message is too large")
+			break
+		}
+		if partialBytes+n > muxMaxPartialBytes {
+			err = errors.New("rpc: multiplexed messages in progress are too large")
+			break
+		}
+		start := len(buf)
+		buf = append(buf, make([]byte, n)...)
+		if _, err = io.ReadFull(br, buf[start:]); err != nil {
+			break
+		}
+		if length&muxLastFrame == 0 {
+			partial[id] = buf
+			partialBytes += n
+			continue
+		}
+
+		delete(partial, id)
+		partialBytes -= start
+
+		c.rmu.Lock()
+		// stop reading until the codec catches up
+		for len(c.ready) >= muxMaxReady && !c.rclosed {
+			c.rcond.Wait()
+		}
+		if c.rclosed {
+			c.rmu.Unlock()
+			err = errMuxClosed
+			break
+		}
+		c.ready = append(c.ready, buf)
+		c.rcond.Broadcast()
+		c.rmu.Unlock()
+	}
+
+	c.rmu.Lock()
+	c.rerr = err
+	c.rcond.Broadcast()
+	c.rmu.Unlock()
+
+	// the peer is gone, so the writer stops once the queue is sent
+	c.wmu.Lock()
+	c.closing = true
+	c.wcond.Broadcast()
+	c.wmu.Unlock()
+}
+
+// Read reads the received messages in the order they were completed.
+func (c *muxConn) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	for len(c.ready) == 0 && c.rerr == nil {
+		c.rcond.Wait()
+	}
+	if len(c.ready) == 0 {
+		return 0, c.rerr
+	}
+
+	n := copy(p, c.ready[0])
+	c.ready[0] = c.ready[0][n:]
+	if len(c.ready[0]) == 0 {
+		c.ready = c.ready[1:]
+		c.rcond.Broadcast()
+	}
+	return n, nil
+}
+
+// Close sends the messages which are still queued and closes the connection.
+func (c *muxConn) Close() error {
+	c.rmu.Lock()
+	c.rclosed = true
+	c.rcond.Broadcast()
+	c.rmu.Unlock()
+
+	c.wmu.Lock()
+	c.closing = true
+	c.wcond.Broadcast()
+	c.wmu.Unlock()
+
+	// a peer which does not read must not block Close for long
+	c.Conn.SetWriteDeadline(time.Now().Add(muxCloseTimeout))
+	<-c.writerDone
+	return c.Conn.Close()
+}
+
+// bufferedConn is a net.Conn which reads through r, so that bytes peeked from r are not lost.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,167 @@
+package src
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// muxFrame returns a frame of the message id with payload, its last frame if last.
+func muxFrame(id uint32, payload []byte, last bool) []byte {
+	length := uint32(len(payload))
+	if last {
+		length |= muxLastFrame
+	}
+	frame := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(frame[:4], id)
+	binary.BigEndian.PutUint32(frame[4:], length)
+	return append(frame, payload...)
+}
+
+// readMuxMessage reads the next message of c, which is at most max bytes long.
+func readMuxMessage(c *muxConn, max int) ([]byte, error) {
+	buf := make([]byte, max)
+	n, err := c.Read(buf)
+	return buf[:n], err
+}
+
+// TestMuxConnReassembles reads messages whose frames are interleaved in the order they complete.
+func TestMuxConnReassembles(t *testing.T) {
+	peer, conn := net.Pipe()
+	defer peer.Close()
+	c := newMuxConn(conn)
+	defer c.Close()
+
+	large := bytes.Repeat([]byte("a"), muxFrameSize+10)
+	go func() {
+		for _, frame := range [][]byte{
+			muxFrame(1, large[:muxFrameSize], false),
+			muxFrame(2, []byte("small"), true),
+			muxFrame(1, large[muxFrameSize:], true),
+		} {
+			if _, err := peer.Write(frame); err != nil {
+				return
+			}
+		}
+	}()
+	for _, want := range [][]byte{[]byte("small"), large} {
+		msg, err := readMuxMessage(c, 2*muxFrameSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(msg, want) {
+			t.Fatalf("message of %d bytes, want %d", len(msg), len(want))
+		}
+	}
+}
+
+// TestMuxConnSplits writes messages through a muxConn and reads them through another.
+func TestMuxConnSplits(t *testing.T) {
+	a, b := net.Pipe()
+	writer, reader := newMuxConn(a), newMuxConn(b)
+	defer writer.Close()
+	defer reader.Close()
+
+	messages := [][]byte{bytes.Repeat([]byte("x"), 3*muxFrameSize+1), []byte("y"), bytes.Repeat([]byte("z"), muxFrameSize)}
+	for _, m := range messages {
+		writer.Write(m)
+		writer.endMessage(false)
+	}
+	// a message the codec failed to write is dropped
+	writer.Write([]byte("failed"))
+	writer.endMessage(true)
+
+	got := map[string]bool{}
+	for range messages {
+		msg, err := readMuxMessage(reader, 4*muxFrameSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[string(msg)] = true
+	}
+	for _, m := range messages {
+		if !got[string(m)] {
+			t.Errorf("message of %d bytes not received", len(m))
+		}
+	}
+}
+
+func TestMuxConnLimits(t *testing.T) {
+	tooMany := make([][]byte, 0, muxMaxPartial+1)
+	for id := uint32(0); id <= muxMaxPartial; id++ {
+		tooMany = append(tooMany, muxFrame(id, []byte{1}, false))
+	}
+	tooLarge := make([][]byte, 0, muxMaxMessageSize/muxFrameSize+1)
+	chunk := make([]byte, muxFrameSize)
+	for i := 0; i <= muxMaxMessageSize/muxFrameSize; i++ {
+		tooLarge = append(tooLarge, muxFrame(7, chunk, false))
+	}
+
+	for _, tt := range []struct {
+		name   string
+		frames [][]byte
+		err    string
+	}{
+		{"frame too large", [][]byte{muxFrame(1, make([]byte, muxFrameSize+1), true)}, "frame is too large"},
+		{"too many messages in progress", tooMany, "too many multiplexed messages"},
+		{"message too large", tooLarge, "message is too large"},
+		{"truncated frame", [][]byte{muxFrame(1, []byte("hello"), true)[:10]}, io.ErrUnexpectedEOF.Error()},
+	} {
+		peer, conn := net.Pipe()
+		c := newMuxConn(conn)
+		go func() {
+			for _, frame := range tt.frames {
+				if _, err := peer.Write(frame); err != nil {
+					return
+				}
+			}
+			peer.Close()
+		}()
+
+		_, err := readMuxMessage(c, 1)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: err %v", tt.name, err)
+		}
+		peer.Close()
+		c.Close()
+	}
+}
+
+// TestMuxConnBackpressure stops reading frames while the codec does not read the messages,
+// and still closes.
+func TestMuxConnBackpressure(t *testing.T) {
+	peer, conn := net.Pipe()
+	defer peer.Close()
+	c := newMuxConn(conn)
+
+	sent := make(chan int, 1)
+	go func() {
+		n := 0
+		for id := uint32(0); ; id++ {
+			peer.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+			if _, err := peer.Write(muxFrame(id, []byte("m"), true)); err != nil {
+				break
+			}
+			n++
+		}
+		sent <- n
+	}()
+	if n := <-sent; n > muxMaxReady+1 {
+		t.Errorf("%d messages read, at most %d wait for the codec", n, muxMaxReady)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		c.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close blocked by a full connection")
+	}
+}
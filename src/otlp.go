@@ -0,0 +1,127 @@
+package src
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// OTLPFileExporter appends spans to a file in the OTLP/JSON encoding, one ExportTraceServiceRequest
+// per line. It is the format of the file exporter of the OpenTelemetry collector,
+// so the file can be loaded later by its otlpjsonfile receiver.
+type OTLPFileExporter struct {
+	//ServiceName is reported as the service.name of the spans
+	ServiceName string
+
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// NewOTLPFileExporter creates an OTLPFileExporter which appends to the file at path.
+func NewOTLPFileExporter(path, serviceName string) (*OTLPFileExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &OTLPFileExporter{ServiceName: serviceName, file: f, enc: json.NewEncoder(f)}, nil
+}
+
+type otlpKeyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func otlpAttributes(attrs map[string]string) []otlpKeyValue {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	kvs := make([]otlpKeyValue, len(keys))
+	for i, k := range keys {
+		kvs[i].Key = k
+		kvs[i].Value.StringValue = attrs[k]
+	}
+	return kvs
+}
+
+// ExportSpan appends span to the file.
+func (e *OTLPFileExporter) ExportSpan(span *Span) error {
+	span.mu.Lock()
+	s := otlpSpan{
+		TraceID:           span.TraceID.String(),
+		SpanID:            span.SpanID.String(),
+		Name:              span.Name,
+		Kind:              int(span.Kind),
+		StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+		Attributes:        otlpAttributes(span.Attributes),
+		Status:            otlpStatus{Code: 1}, // STATUS_CODE_OK
+	}
+	if span.ParentSpanID.IsValid() {
+		s.ParentSpanID = span.ParentSpanID.String()
+	}
+	if span.Error != "" {
+		s.Status = otlpStatus{Code: 2, Message: span.Error} // STATUS_CODE_ERROR
+	}
+	span.mu.Unlock()
+
+	var rs otlpResourceSpans
+	rs.Resource.Attributes = otlpAttributes(map[string]string{"service.name": e.ServiceName})
+	var ss otlpScopeSpans
+	ss.Scope.Name = "rpct"
+	ss.Spans = []otlpSpan{s}
+	rs.ScopeSpans = []otlpScopeSpans{ss}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.enc.Encode(otlpTraces{ResourceSpans: []otlpResourceSpans{rs}})
+}
+
+// Close closes the file.
+func (e *OTLPFileExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file.Close()
+}
@@ -1,7 +1,89 @@
 package src
 
+import "context"
+
 //IPlugin represents a plugin.
 type IPlugin interface {
 	Name() string
 	Description() string
 }
+
+// CallInfo describes a call to the call plugins of Client and Server.
+type CallInfo struct {
+	ServiceMethod string
+	Args          interface{}
+	Reply         interface{}
+	//Peer is the address of the server for clients and of the client for servers
+	Peer string
+	//SelectMode is the SelectMode the client selected the server with, empty on servers
+	//and for selectors which have none
+	SelectMode string
+}
+
+//IPreCallPlugin is invoked before a client sends a call and before a server runs the handler of a call.
+//The returned context is passed on to the following plugins, to the handler on servers,
+//and its outgoing metadata is sent with the request on clients.
+//The call fails with the error if one is returned.
+type IPreCallPlugin interface {
+	PreCall(ctx context.Context, info *CallInfo) (context.Context, error)
+}
+
+//IPostCallPlugin is invoked once a call is done, with the error it ended with:
+//on servers once its response is written, on clients once its response is read.
+//It also runs when an IPreCallPlugin failed the call.
+type IPostCallPlugin interface {
+	PostCall(ctx context.Context, info *CallInfo, err error)
+}
+
+//ICallPluginContainer is implemented by the plugin containers which invoke the call plugins themselves,
+//like ServerPluginContainer and ClientPluginContainer.
+//The IPreCallPlugins and IPostCallPlugins returned by GetAll are invoked for other containers.
+type ICallPluginContainer interface {
+	DoPreCall(ctx context.Context, info *CallInfo) (context.Context, error)
+	DoPostCall(ctx context.Context, info *CallInfo, err error)
+}
+
+// pluginLister is what both IServerPluginContainer and IClientPluginContainer implement.
+type pluginLister interface {
+	GetAll() []IPlugin
+}
+
+// containerPreCall invokes the IPreCallPlugins of pc.
+func containerPreCall(pc pluginLister, ctx context.Context, info *CallInfo) (context.Context, error) {
+	if c, ok := pc.(ICallPluginContainer); ok {
+		return c.DoPreCall(ctx, info)
+	}
+	return doPreCall(pc.GetAll(), ctx, info)
+}
+
+// containerPostCall invokes the IPostCallPlugins of pc.
+func containerPostCall(pc pluginLister, ctx context.Context, info *CallInfo, err error) {
+	if c, ok := pc.(ICallPluginContainer); ok {
+		c.DoPostCall(ctx, info, err)
+		return
+	}
+	doPostCall(pc.GetAll(), ctx, info, err)
+}
+
+// doPreCall invokes the IPreCallPlugins among plugins.
+func doPreCall(plugins []IPlugin, ctx context.Context, info *CallInfo) (context.Context, error) {
+	for i := range plugins {
+		if plugin, ok := plugins[i].(IPreCallPlugin); ok {
+			var err error
+			ctx, err = plugin.PreCall(ctx, info)
+			if err != nil {
+				return ctx, err
+			}
+		}
+	}
+	return ctx, nil
+}
+
+// doPostCall invokes the IPostCallPlugins among plugins.
+func doPostCall(plugins []IPlugin, ctx context.Context, info *CallInfo, err error) {
+	for i := range plugins {
+		if plugin, ok := plugins[i].(IPostCallPlugin); ok {
+			plugin.PostCall(ctx, info, err)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package src
+
+import (
+	"net/rpc"
+	"sync"
+)
+
+// connPool keeps the connections of a Client to every server,
+// so that calls share them instead of dialing a new one each time.
+type connPool struct {
+	mu    sync.Mutex
+	conns map[string][]*pooledConn // by network@address
+}
+
+type pooledConn struct {
+	rpcClient *rpc.Client
+	codec     *clientCodecWrapper
+}
+
+// get returns the least busy open connection to address which has fewer than max calls
+// in flight, or nil if there is none. max <= 0 means no limit.
+func (p *connPool) get(network, address string, max int) *rpc.Client {
+	key := network + "@" + address
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var best *pooledConn
+	bestActive := 0
+	open := p.conns[key][:0]
+	for _, pc := range p.conns[key] {
+		active, closed := pc.codec.load()
+		if closed {
+			continue
+		}
+		open = append(open, pc)
+		if (max <= 0 || active < max) && (best == nil || active < bestActive) {
+			best, bestActive = pc, active
+		}
+	}
+	if len(open) == 0 {
+		delete(p.conns, key)
+	} else {
+		p.conns[key] = open
+	}
+
+	if best == nil {
+		return nil
+	}
+	return best.rpcClient
+}
+
+// put adds a new connection to address.
+func (p *connPool) put(network, address string, rpcClient *rpc.Client, codec *clientCodecWrapper) {
+	key := network + "@" + address
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conns == nil {
+		p.conns = make(map[string][]*pooledConn)
+	}
+	p.conns[key] = append(p.conns[key], &pooledConn{rpcClient: rpcClient, codec: codec})
+}
+
+// peer returns the network@address which rpcClient is connected to, or "" if it is not pooled.
+func (p *connPool) peer(rpcClient *rpc.Client) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, conns := range p.conns {
+		for _, pc := range conns {
+			if pc.rpcClient == rpcClient {
+				return key
+			}
+		}
+	}
+	return ""
+}
+
+// len returns the number of open connections.
+func (p *connPool) len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := 0
+	for _, conns := range p.conns {
+		for _, pc := range conns {
+			if _, closed := pc.codec.load(); !closed {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// close closes all connections.
+func (p *connPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, conns := range p.conns {
+		for _, pc := range conns {
+			pc.rpcClient.Close()
+		}
+	}
+	p.conns = nil
+}
@@ -0,0 +1,327 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// qualifiers of the principals of subjects, by where they come from, which keep apart
+// an mTLS principal and a JWT subject of the same name
+const (
+	rbacMTLSQualifier = "mtls:"
+	rbacJWTQualifier  = "jwt:"
+)
+
+// RBACRule allows, or denies, subjects to call service methods.
+// A rule matches a call if one of its Methods matches the service method and one of its
+// Principals, Roles or Tags matches the subject of the call.
+type RBACRule struct {
+	//Methods are service methods like "Arith.Mul", all the methods of a service like "Arith.*", or "*"
+	Methods []string `json:"methods" yaml:"methods"`
+	//Principals are the names of principals qualified by where they come from, "mtls:" for the
+	//mTLS principal and "jwt:" for the subject of the JWT, like "jwt:alice", or prefixes of them
+	//ending with "*" like "mtls:spiffe://example.org/*"
+	Principals []string `json:"principals,omitempty" yaml:"principals,omitempty"`
+	Roles      []string `json:"roles,omitempty" yaml:"roles,omitempty"`
+	//Tags are the tags of the authorization of clients, see Client.Auth. Clients choose their tag
+	//unauthenticated, so a tag should only allow what any client may do.
+	Tags []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Deny bool     `json:"deny,omitempty" yaml:"deny,omitempty"`
+}
+
+// RBACPolicy decides which subjects may call which service methods.
+// A call is denied if a deny rule matches it, else allowed if an allow rule matches it, and else denied,
+// whatever the order of the rules, as with the rules of MTLSAuthPlugin.
+type RBACPolicy struct {
+	//Roles grants roles to principals, qualified like the Principals of rules, by role name.
+	//Tags are not authenticated and are granted no roles.
+	Roles map[string][]string `json:"roles,omitempty" yaml:"roles,omitempty"`
+	Rules []RBACRule          `json:"rules" yaml:"rules"`
+}
+
+// RBACSubject is who makes a call.
+type RBACSubject struct {
+	//Principals are the mTLS principal and the subject of the JWT of the client, those it has,
+	//qualified with "mtls:" and "jwt:"
+	Principals []string `json:"principals,omitempty"`
+	Roles      []string `json:"roles,omitempty"`
+	Tag        string   `json:"tag,omitempty"`
+}
+
+// ParseRBACPolicy parses a policy in JSON, or YAML unless it is JSON.
+func ParseRBACPolicy(data []byte, isJSON bool) (*RBACPolicy, error) {
+	policy := &RBACPolicy{}
+	var err error
+	if isJSON {
+		err = json.Unmarshal(data, policy)
+	} else {
+		err = yaml.Unmarshal(data, policy)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for role, members := range policy.Roles {
+		if err := checkQualified(members); err != nil {
+			return nil, errors.New("rpc: RBAC role " + role + ": " + err.Error())
+		}
+	}
+	for i, rule := range policy.Rules {
+		if len(rule.Methods) == 0 {
+			return nil, errors.New("rpc: RBAC rule " + strconv.Itoa(i) + " has no methods")
+		}
+		if err := checkQualified(rule.Principals); err != nil {
+			return nil, errors.New("rpc: RBAC rule " + strconv.Itoa(i) + ": " + err.Error())
+		}
+	}
+	return policy, nil
+}
+
+// checkQualified returns an error if one of principals is not qualified with "mtls:" or "jwt:".
+func checkQualified(principals []string) error {
+	for _, p := range principals {
+		if !strings.HasPrefix(p, rbacMTLSQualifier) && !strings.HasPrefix(p, rbacJWTQualifier) {
+			return errors.New("principal " + p + " is not qualified with mtls: or jwt:")
+		}
+	}
+	return nil
+}
+
+// roles returns the roles of subject: those it has and those the policy grants to its principals.
+func (policy *RBACPolicy) roles(subject *RBACSubject) []string {
+	roles := append([]string(nil), subject.Roles...)
+	for role, members := range policy.Roles {
+		if containsMatch(members, subject.Principals) {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// containsMatch reports whether one of patterns matches one of values.
+func containsMatch(patterns []string, values []string) bool {
+	for _, p := range patterns {
+		for _, v := range values {
+			if matchPattern(p, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (rule *RBACRule) matches(serviceMethod string, subject *RBACSubject, roles []string) bool {
+	if !containsMatch(rule.Methods, []string{serviceMethod}) {
+		return false
+	}
+	if containsMatch(rule.Principals, subject.Principals) || containsMatch(rule.Roles, roles) {
+		return true
+	}
+	return subject.Tag != "" && containsMatch(rule.Tags, []string{subject.Tag})
+}
+
+// Decide returns whether subject may call serviceMethod, and the index of the rule which decided, -1 if none did.
+func (policy *RBACPolicy) Decide(serviceMethod string, subject *RBACSubject) (bool, int) {
+	roles := policy.roles(subject)
+	allowedBy := -1
+	for i := range policy.Rules {
+		if !policy.Rules[i].matches(serviceMethod, subject, roles) {
+			continue
+		}
+		if policy.Rules[i].Deny {
+			return false, i
+		}
+		if allowedBy < 0 {
+			allowedBy = i
+		}
+	}
+	return allowedBy >= 0, allowedBy
+}
+
+// rbacAuditRecord is a line of the audit log of RBACPlugin.
+type rbacAuditRecord struct {
+	Time          time.Time    `json:"time"`
+	Decision      string       `json:"decision"`
+	ServiceMethod string       `json:"method"`
+	Subject       *RBACSubject `json:"subject"`
+	Rule          int          `json:"rule"`
+	Peer          string       `json:"peer,omitempty"`
+}
+
+// RBACPlugin authorizes the calls of a Server with a RBACPolicy, which may be reloaded from a file
+// while the server runs. The subject of a call is made of the authorization tag, see AuthorizationServerPlugin,
+// the mTLS principal, see MTLSAuthPlugin, and the subject and the "roles" claim of the JWT, see JWTAuthPlugin,
+// so the plugins which decode them must come before it. The mTLS principal is the name PrincipalFunc maps it to,
+// if MTLSAuthPlugin has one. Principals are qualified by where they come from, see RBACRule.
+// Decisions are written to AuditLog as JSON lines.
+type RBACPlugin struct {
+	//AuditLog receives a JSON line per decision, if not nil
+	AuditLog io.Writer
+
+	mu     sync.RWMutex
+	policy *RBACPolicy
+	path   string
+
+	auditMu sync.Mutex
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewRBACPlugin creates a RBACPlugin with a fixed policy.
+func NewRBACPlugin(policy *RBACPolicy) *RBACPlugin {
+	return &RBACPlugin{policy: policy}
+}
+
+// NewRBACFilePlugin creates a RBACPlugin with the policy in the file at path, JSON if its name ends with ".json"
+// and YAML else, and reloads it once the file changes, checking every interval, DefaultCertificateReloadInterval if zero.
+func NewRBACFilePlugin(path string, interval time.Duration) (*RBACPlugin, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	plugin := &RBACPlugin{path: path, done: make(chan struct{})}
+	if err := plugin.Reload(); err != nil {
+		return nil, err
+	}
+	go watchFile(path, fi.ModTime(), interval, plugin.done, "RBAC policy", plugin.Reload)
+	return plugin, nil
+}
+
+// Reload reads the policy file now. The current policy is kept if reading fails.
+func (plugin *RBACPlugin) Reload() error {
+	if plugin.path == "" {
+		return errors.New("rpc: RBAC policy has no file")
+	}
+	data, err := ioutil.ReadFile(plugin.path)
+	if err != nil {
+		return err
+	}
+	policy, err := ParseRBACPolicy(data, strings.EqualFold(filepath.Ext(plugin.path), ".json"))
+	if err != nil {
+		return err
+	}
+	plugin.SetPolicy(policy)
+	return nil
+}
+
+// SetPolicy replaces the policy.
+func (plugin *RBACPlugin) SetPolicy(policy *RBACPolicy) {
+	plugin.mu.Lock()
+	plugin.policy = policy
+	plugin.mu.Unlock()
+}
+
+// Close stops checking the policy file for changes.
+func (plugin *RBACPlugin) Close() {
+	if plugin.done != nil {
+		plugin.once.Do(func() { close(plugin.done) })
+	}
+}
+
+// subjectOf returns the subject of the call handled with ctx.
+func subjectOf(ctx context.Context) *RBACSubject {
+	subject := &RBACSubject{}
+	a, authorized := AuthorizationFromContext(ctx)
+	if authorized {
+		subject.Tag = a.Tag
+	}
+	// the principal as MTLSAuthPlugin mapped it, never the certificate name it was mapped from
+	p, ok := PrincipalFromContext(ctx)
+	if !ok && authorized {
+		p, ok = a.Principal()
+	}
+	if ok {
+		subject.Principals = append(subject.Principals, rbacMTLSQualifier+p.Name)
+	}
+	if claims, ok := JWTClaimsFromContext(ctx); ok {
+		if sub := claims.Subject(); sub != "" {
+			subject.Principals = append(subject.Principals, rbacJWTQualifier+sub)
+		}
+		if roles, ok := claims["roles"].([]interface{}); ok {
+			for _, r := range roles {
+				if s, ok := r.(string); ok {
+					subject.Roles = append(subject.Roles, s)
+				}
+			}
+		}
+	}
+	return subject
+}
+
+// PreCall authorizes a call.
+func (plugin *RBACPlugin) PreCall(ctx context.Context, info *CallInfo) (context.Context, error) {
+	return ctx, plugin.decide(info, subjectOf(ctx))
+}
+
+// Authorize authorizes a call by its decoded authorization alone, with its tag and mTLS principal as the subject.
+// It is an AuthorizationFunc, for servers which use AuthorizationServerPlugin without the plugin itself.
+func (plugin *RBACPlugin) Authorize(p *AuthorizationAndServiceMethod) error {
+	subject := &RBACSubject{Tag: p.Tag}
+	if principal, ok := p.Principal(); ok {
+		subject.Principals = []string{rbacMTLSQualifier + principal.Name}
+	}
+	return plugin.decide(&CallInfo{ServiceMethod: p.ServiceMethod}, subject)
+}
+
+func (plugin *RBACPlugin) decide(info *CallInfo, subject *RBACSubject) error {
+	plugin.mu.RLock()
+	policy := plugin.policy
+	plugin.mu.RUnlock()
+
+	allowed, rule := false, -1
+	if policy != nil {
+		allowed, rule = policy.Decide(info.ServiceMethod, subject)
+	}
+	plugin.audit(info, subject, allowed, rule)
+
+	if !allowed {
+		return errors.New("rpc: permission denied: " + info.ServiceMethod)
+	}
+	return nil
+}
+
+func (plugin *RBACPlugin) audit(info *CallInfo, subject *RBACSubject, allowed bool, rule int) {
+	if plugin.AuditLog == nil {
+		return
+	}
+
+	record := rbacAuditRecord{
+		Time:          time.Now().UTC(),
+		Decision:      "deny",
+		ServiceMethod: info.ServiceMethod,
+		Subject:       subject,
+		Rule:          rule,
+		Peer:          info.Peer,
+	}
+	if allowed {
+		record.Decision = "allow"
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+
+	plugin.auditMu.Lock()
+	plugin.AuditLog.Write(append(line, '\n'))
+	plugin.auditMu.Unlock()
+}
+
+// Name return name of this plugin.
+func (plugin *RBACPlugin) Name() string {
+	return "RBACPlugin"
+}
+
+// Description return description of this plugin.
+func (plugin *RBACPlugin) Description() string {
+	return "a RBAC plugin which authorizes calls by policy"
+}
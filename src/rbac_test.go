@@ -0,0 +1,149 @@
+package src
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testRBACPolicy = `
+roles:
+  admin: ["jwt:alice", "mtls:spiffe://example.org/ops/*"]
+rules:
+  - methods: ["Arith.*"]
+    roles: [admin]
+  - methods: ["Arith.Mul"]
+    principals: ["mtls:spiffe://example.org/billing", "jwt:bob"]
+    tags: [public]
+  - methods: ["Arith.Div"]
+    principals: ["mtls:spiffe://example.org/ops/intern"]
+    deny: true
+  - methods: ["*"]
+    tags: [banned]
+    deny: true
+`
+
+func TestRBACPolicyDecide(t *testing.T) {
+	policy, err := ParseRBACPolicy([]byte(testRBACPolicy), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		method  string
+		subject RBACSubject
+		allowed bool
+		rule    int
+	}{
+		{"Arith.Div", RBACSubject{Principals: []string{"jwt:alice"}}, true, 0},
+		{"Arith.Div", RBACSubject{Roles: []string{"admin"}}, true, 0},
+		{"Arith.Mul", RBACSubject{Principals: []string{"jwt:bob"}}, true, 1},
+		{"Arith.Div", RBACSubject{Principals: []string{"jwt:bob"}}, false, -1},
+		{"Arith.Mul", RBACSubject{Tag: "public"}, true, 1},
+		// principals are qualified by where they come from
+		{"Arith.Mul", RBACSubject{Principals: []string{"mtls:bob"}}, false, -1},
+		{"Arith.Mul", RBACSubject{Principals: []string{"jwt:spiffe://example.org/billing"}}, false, -1},
+		// deny rules win over the allow rules before them
+		{"Arith.Mul", RBACSubject{Principals: []string{"mtls:spiffe://example.org/ops/intern"}}, true, 0},
+		{"Arith.Div", RBACSubject{Principals: []string{"mtls:spiffe://example.org/ops/intern"}}, false, 2},
+		{"Arith.Mul", RBACSubject{Principals: []string{"jwt:alice"}, Tag: "banned"}, false, 3},
+		{"Arith.Mul", RBACSubject{}, false, -1},
+	} {
+		allowed, rule := policy.Decide(tt.method, &tt.subject)
+		if allowed != tt.allowed || rule != tt.rule {
+			t.Errorf("%+v calling %s: allowed %v by rule %d, want %v by rule %d", tt.subject, tt.method, allowed, rule, tt.allowed, tt.rule)
+		}
+	}
+}
+
+func TestParseRBACPolicy(t *testing.T) {
+	for _, tt := range []struct {
+		policy string
+		isJSON bool
+		valid  bool
+	}{
+		{`{"rules":[{"methods":["*"],"principals":["jwt:alice"]}]}`, true, true},
+		{`{"rules":[{"principals":["jwt:alice"]}]}`, true, false},
+		{`{"rules":[{"methods":["*"],"principals":["alice"]}]}`, true, false},
+		{`{"roles":{"admin":["alice"]},"rules":[]}`, true, false},
+		{"rules: [", false, false},
+	} {
+		if _, err := ParseRBACPolicy([]byte(tt.policy), tt.isJSON); (err == nil) != tt.valid {
+			t.Errorf("%s: err %v", tt.policy, err)
+		}
+	}
+}
+
+func TestRBACSubjectOf(t *testing.T) {
+	raw := &Principal{Name: "spiffe://example.org/billing"}
+	authorized := context.WithValue(context.Background(), authorizationKey{},
+		&AuthorizationAndServiceMethod{Tag: "public", principal: raw})
+	mapped := context.WithValue(authorized, principalKey{}, &Principal{Name: "billing"})
+	withJWT := context.WithValue(mapped, jwtClaimsKey{}, JWTClaims{"sub": "alice", "roles": []interface{}{"admin", 7}})
+
+	for _, tt := range []struct {
+		name string
+		ctx  context.Context
+		want RBACSubject
+	}{
+		{"none", context.Background(), RBACSubject{}},
+		{"authorization", authorized, RBACSubject{Principals: []string{"mtls:spiffe://example.org/billing"}, Tag: "public"}},
+		// the name PrincipalFunc mapped the certificate to replaces the one of the certificate
+		{"mapped", mapped, RBACSubject{Principals: []string{"mtls:billing"}, Tag: "public"}},
+		{"jwt", withJWT, RBACSubject{Principals: []string{"mtls:billing", "jwt:alice"}, Roles: []string{"admin"}, Tag: "public"}},
+	} {
+		if got := subjectOf(tt.ctx); !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("%s: subject %+v, want %+v", tt.name, *got, tt.want)
+		}
+	}
+}
+
+func TestRBACFilePluginReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	writePolicy := func(policy string, modTime time.Time) {
+		if err := os.WriteFile(path, []byte(policy), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writePolicy(testRBACPolicy, time.Now())
+	plugin, err := NewRBACFilePlugin(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plugin.Close()
+	var audit bytes.Buffer
+	plugin.AuditLog = &audit
+
+	info := &CallInfo{ServiceMethod: "Arith.Mul", Peer: "10.0.0.1:4000"}
+	bob := &RBACSubject{Principals: []string{"jwt:bob"}}
+	if err := plugin.decide(info, bob); err != nil {
+		t.Fatal(err)
+	}
+	var record rbacAuditRecord
+	if err := json.Unmarshal(audit.Bytes(), &record); err != nil || record.Decision != "allow" || record.Rule != 1 {
+		t.Fatalf("audit record %s, err %v", audit.String(), err)
+	}
+
+	// a policy which fails to parse keeps the current one
+	modTime := time.Now().Add(time.Minute)
+	writePolicy("rules: [", modTime)
+	time.Sleep(50 * time.Millisecond)
+	if err := plugin.decide(info, bob); err != nil {
+		t.Fatal("policy lost by a failed reload:", err)
+	}
+
+	// and is reloaded once it parses, even if it was written again within the same modification time
+	writePolicy(`{"rules":[{"methods":["*"],"principals":["jwt:bob"],"deny":true}]}`, modTime)
+	for deadline := time.Now().Add(time.Second); plugin.decide(info, bob) == nil; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("policy not reloaded")
+		}
+	}
+}
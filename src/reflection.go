@@ -0,0 +1,191 @@
+package src
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ReflectionServiceName is the name which a Server publishes its reflection service under,
+// once Server.EnableReflection is called.
+// Clients call "rpct.Reflection.ListServices" and "rpct.Reflection.Describe" with ReflectionArgs.
+const ReflectionServiceName = "rpct.Reflection"
+
+// ReflectionArgs are the args of the reflection service.
+type ReflectionArgs struct {
+	//Service is the name of the service to describe, all of them if empty
+	Service string
+}
+
+// ServiceList is the reply of ListServices.
+type ServiceList struct {
+	Services []string
+}
+
+// ServiceDescriptors is the reply of Describe.
+type ServiceDescriptors struct {
+	Services []ServiceDescriptor
+}
+
+// ServiceDescriptor describes a service published with RegisterName.
+type ServiceDescriptor struct {
+	Name    string
+	Methods []MethodDescriptor
+	//Types are the struct types which the args and the replies of the methods are made of
+	Types []TypeDescriptor
+}
+
+// MethodDescriptor describes a method of a service.
+// ArgType and ReplyType are Go type expressions like "*main.Args", empty where a Stream is taken instead.
+type MethodDescriptor struct {
+	Name      string
+	ArgType   string
+	ReplyType string
+	//Stream is "client", "server" or "bidi" for streaming methods, see Stream
+	Stream string
+}
+
+// TypeDescriptor describes a struct type by its exported fields.
+type TypeDescriptor struct {
+	Name   string
+	Fields []FieldDescriptor
+}
+
+// FieldDescriptor describes a field of a struct.
+type FieldDescriptor struct {
+	Name string
+	//Key is the name of the field in messages, from its msg, codec, msgpack or json tag, else Name
+	Key  string
+	Type string
+	//Tag is the whole struct tag
+	Tag      string
+	Embedded bool
+}
+
+// Reflection is the reflection service of a Server.
+type Reflection struct {
+	services *serviceMap
+}
+
+// ListServices returns the names of the services of the server.
+func (r *Reflection) ListServices(args *ReflectionArgs, reply *ServiceList) error {
+	r.services.mu.RLock()
+	defer r.services.mu.RUnlock()
+
+	reply.Services = reply.Services[:0]
+	for name := range r.services.services {
+		if name != ReflectionServiceName {
+			reply.Services = append(reply.Services, name)
+		}
+	}
+	sort.Strings(reply.Services)
+	return nil
+}
+
+// Describe returns the methods of the service args.Service, or of all of them,
+// with the struct types of their args and replies.
+func (r *Reflection) Describe(args *ReflectionArgs, reply *ServiceDescriptors) error {
+	r.services.mu.RLock()
+	defer r.services.mu.RUnlock()
+
+	reply.Services = reply.Services[:0]
+	if args.Service != "" {
+		svc := r.services.services[args.Service]
+		if svc == nil {
+			return errors.New("rpc: can't find service " + args.Service)
+		}
+		reply.Services = append(reply.Services, describeService(svc))
+		return nil
+	}
+
+	for name, svc := range r.services.services {
+		if name != ReflectionServiceName {
+			reply.Services = append(reply.Services, describeService(svc))
+		}
+	}
+	sort.Slice(reply.Services, func(i, j int) bool { return reply.Services[i].Name < reply.Services[j].Name })
+	return nil
+}
+
+func describeService(svc *service) ServiceDescriptor {
+	d := ServiceDescriptor{Name: svc.name}
+	types := make(map[reflect.Type]bool)
+	for name, mtype := range svc.method {
+		m := MethodDescriptor{Name: name}
+		switch mtype.stream {
+		case clientStream:
+			m.Stream = "client"
+		case serverStream:
+			m.Stream = "server"
+		case bidiStream:
+			m.Stream = "bidi"
+		}
+		if mtype.ArgType != nil && mtype.ArgType != typeOfStream {
+			m.ArgType = mtype.ArgType.String()
+			d.Types = describeTypes(mtype.ArgType, types, d.Types)
+		}
+		if mtype.ReplyType != nil && mtype.ReplyType != typeOfStream {
+			m.ReplyType = mtype.ReplyType.String()
+			d.Types = describeTypes(mtype.ReplyType, types, d.Types)
+		}
+		d.Methods = append(d.Methods, m)
+	}
+	sort.Slice(d.Methods, func(i, j int) bool { return d.Methods[i].Name < d.Methods[j].Name })
+	sort.Slice(d.Types, func(i, j int) bool { return d.Types[i].Name < d.Types[j].Name })
+	return d
+}
+
+// describeTypes appends the descriptors of the struct types which t is made of to descs,
+// skipping those in seen.
+func describeTypes(t reflect.Type, seen map[reflect.Type]bool, descs []TypeDescriptor) []TypeDescriptor {
+	for {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Array:
+			t = t.Elem()
+			continue
+		case reflect.Map:
+			descs = describeTypes(t.Key(), seen, descs)
+			t = t.Elem()
+			continue
+		}
+		break
+	}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return descs
+	}
+	seen[t] = true
+
+	d := TypeDescriptor{Name: t.String()}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		d.Fields = append(d.Fields, FieldDescriptor{
+			Name:     f.Name,
+			Key:      fieldKey(f),
+			Type:     f.Type.String(),
+			Tag:      string(f.Tag),
+			Embedded: f.Anonymous,
+		})
+	}
+	descs = append(descs, d)
+
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.PkgPath == "" {
+			descs = describeTypes(f.Type, seen, descs)
+		}
+	}
+	return descs
+}
+
+// fieldKey returns the name of f in messages.
+func fieldKey(f reflect.StructField) string {
+	for _, key := range []string{"msg", "codec", "msgpack", "json"} {
+		if name := strings.Split(f.Tag.Get(key), ",")[0]; name != "" && name != "-" {
+			return name
+		}
+	}
+	return f.Name
+}
@@ -0,0 +1,46 @@
+package src
+
+import (
+	"net/rpc/jsonrpc"
+	"testing"
+	"time"
+)
+
+func TestReflectionOptIn(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		ln, err := NewMemoryListener("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := NewServer()
+		s.ServerCodecFunc = jsonrpc.NewServerCodec
+		s.RegisterName("Arith", new(MemoryArith))
+		if enabled {
+			if err := s.EnableReflection(); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.EnableReflection(); err == nil {
+				t.Fatal("reflection published twice")
+			}
+		}
+		go s.ServeListener(ln)
+
+		c := newMemoryClient(ln)
+		var list ServiceList
+		err = c.Call(ReflectionServiceName+".ListServices", &ReflectionArgs{}, &list)
+		switch {
+		case !enabled && err == nil:
+			t.Error("reflection published by default")
+		case enabled && err != nil:
+			t.Error(err)
+		case enabled && (len(list.Services) != 1 || list.Services[0] != "Arith"):
+			t.Errorf("services %v", list.Services)
+		}
+		// the server still serves its own services
+		if reply, err := callMul(c, time.Second); err != nil || reply != 42 {
+			t.Errorf("reply %d, err %v", reply, err)
+		}
+		c.Close()
+		ln.Close()
+	}
+}
@@ -1,12 +1,16 @@
 package src
 
 import (
+	"bufio"
 	"crypto/tls"
+	"errors"
 	"io"
-	"log"
 	"net"
 	"net/http"
 	"net/rpc"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	msgpackrpc "github.com/hashicorp/net-rpc-msgpackrpc"
@@ -24,21 +28,69 @@ type serverCodecWrapper struct {
 	Timeout         time.Duration
 	ReadTimeout     time.Duration
 	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+
+	mu      sync.Mutex
+	oneWays map[uint64]bool // seqs of one-way requests which must not be answered
+
+	mux *muxConn // the multiplexed connection, if any
+
+	codecFunc ServerCodecFunc // decodes the args of signed requests
+
+	reading *requestState // the request whose header was read last, only used by the reading goroutine
+	counter *countingConn // counts the bytes of the requests and the responses, if not nil
+}
+
+// countingConn counts the bytes read from and written to a connection.
+type countingConn struct {
+	net.Conn
+	read, written int64
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(&c.read, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddInt64(&c.written, int64(n))
+	return n, err
+}
+
+// counts returns the bytes read from and written to c so far, zero if c is nil.
+func (c *countingConn) counts() (read, written int64) {
+	if c == nil {
+		return 0, 0
+	}
+	return atomic.LoadInt64(&c.read), atomic.LoadInt64(&c.written)
 }
 
 // newServerCodecWrapper wraps a rpc.ServerCodec.
 func newServerCodecWrapper(pc IServerPluginContainer, c rpc.ServerCodec, Conn net.Conn) *serverCodecWrapper {
-	return &serverCodecWrapper{ServerCodec: c, PluginContainer: pc, Conn: Conn}
+	return &serverCodecWrapper{
+		ServerCodec:     c,
+		PluginContainer: pc,
+		Conn:            Conn,
+		oneWays:         make(map[uint64]bool),
+	}
 }
 
 func (w *serverCodecWrapper) ReadRequestHeader(r *rpc.Request) error {
 	if w.Timeout > 0 {
 		w.Conn.SetDeadline(time.Now().Add(w.Timeout))
 	}
-	if w.ReadTimeout > 0 {
+	if w.IdleTimeout > 0 && (w.ReadTimeout <= 0 || w.IdleTimeout < w.ReadTimeout) {
+		// a client which sends neither requests nor heartbeats is dropped
+		w.Conn.SetReadDeadline(time.Now().Add(w.IdleTimeout))
+	} else if w.ReadTimeout > 0 {
 		w.Conn.SetReadDeadline(time.Now().Add(w.ReadTimeout))
 	}
 
+	w.reading = nil
+	start, _ := w.counter.counts()
+
 	//pre
 	err := w.PluginContainer.DoPreReadRequestHeader(r)
 	if err != nil {
@@ -50,9 +102,34 @@ func (w *serverCodecWrapper) ReadRequestHeader(r *rpc.Request) error {
 		return err
 	}
 
+	if strings.HasPrefix(r.ServiceMethod, oneWayPrefix) {
+		r.ServiceMethod = r.ServiceMethod[len(oneWayPrefix):]
+		w.mu.Lock()
+		w.oneWays[r.Seq] = true
+		w.mu.Unlock()
+	}
+	var md Metadata
+	if strings.HasPrefix(r.ServiceMethod, metadataPrefix) {
+		md, r.ServiceMethod, err = splitMetadata(r.ServiceMethod)
+		if err != nil {
+			return err
+		}
+	} else if strings.HasPrefix(r.ServiceMethod, controlPrefix) {
+		// control frames are not requests and are not seen by plugins
+		return nil
+	}
+	if err = w.verifyRequest(r, md); err != nil {
+		return err
+	}
+	// ServerConn takes the state of the request once its header is read
+	st := &requestState{conn: w.Conn, metadata: md, readStart: start}
+	w.reading = st
+
 	//post
-	err = w.PluginContainer.DoPostReadRequestHeader(r)
-	return err
+	if pc, ok := w.PluginContainer.(*ServerPluginContainer); ok {
+		return pc.doPostReadRequestHeader(r, st)
+	}
+	return w.PluginContainer.DoPostReadRequestHeader(r)
 }
 
 func (w *serverCodecWrapper) ReadRequestBody(body interface{}) error {
@@ -62,7 +139,18 @@ func (w *serverCodecWrapper) ReadRequestBody(body interface{}) error {
 		return err
 	}
 
-	err = w.ServerCodec.ReadRequestBody(body)
+	// the body follows the header just read
+	st := w.reading
+	w.reading = nil
+	if st != nil && st.metadata[signatureDigestKey] != "" {
+		err = w.readSignedBody(body, st.metadata[signatureDigestKey])
+	} else {
+		err = w.ServerCodec.ReadRequestBody(body)
+	}
+	if st != nil {
+		read, _ := w.counter.counts()
+		st.size = read - st.readStart
+	}
 	if err != nil {
 		return err
 	}
@@ -72,7 +160,52 @@ func (w *serverCodecWrapper) ReadRequestBody(body interface{}) error {
 	return err
 }
 
+// verifyRequest invokes the request verifiers among the plugins with the request as it was read.
+func (w *serverCodecWrapper) verifyRequest(r *rpc.Request, md Metadata) error {
+	serviceMethod := r.ServiceMethod
+	w.mu.Lock()
+	if w.oneWays[r.Seq] {
+		serviceMethod = oneWayPrefix + serviceMethod
+	}
+	w.mu.Unlock()
+
+	for _, p := range w.PluginContainer.GetAll() {
+		if verifier, ok := p.(requestVerifier); ok {
+			if err := verifier.verifyRequest(serviceMethod, md); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readSignedBody reads the args of a signed request, sent as the bytes of their encoding,
+// checks them against the signed digest and decodes them into body. Args sent as no bytes,
+// which were nil on the client, leave body as it is.
+func (w *serverCodecWrapper) readSignedBody(body interface{}, digest string) error {
+	var data []byte
+	if err := w.ServerCodec.ReadRequestBody(&data); err != nil {
+		return err
+	}
+	if bodyDigest(data) != digest {
+		return errors.New("rpc: request args do not match their signature")
+	}
+	if body == nil || len(data) == 0 {
+		return nil
+	}
+	return decodeBody(w.codecFunc, data, body)
+}
+
 func (w *serverCodecWrapper) WriteResponse(resp *rpc.Response, body interface{}) error {
+	w.mu.Lock()
+	oneWay := w.oneWays[resp.Seq]
+	delete(w.oneWays, resp.Seq)
+	w.mu.Unlock()
+	if oneWay {
+		// nothing is written, but post plugins still learn that the request is done
+		return w.PluginContainer.DoPostWriteResponse(resp, body)
+	}
+
 	if w.Timeout > 0 {
 		w.Conn.SetDeadline(time.Now().Add(w.Timeout))
 	}
@@ -86,7 +219,7 @@ func (w *serverCodecWrapper) WriteResponse(resp *rpc.Response, body interface{})
 		return err
 	}
 
-	err = w.ServerCodec.WriteResponse(resp, body)
+	err = w.writeMessage(resp, body)
 	if err != nil {
 		return err
 	}
@@ -97,6 +230,28 @@ func (w *serverCodecWrapper) WriteResponse(resp *rpc.Response, body interface{})
 	return err
 }
 
+// readControlBody reads the body of a control frame, bypassing plugins.
+func (w *serverCodecWrapper) readControlBody(body interface{}) error {
+	return w.ServerCodec.ReadRequestBody(body)
+}
+
+// writeControl writes a control frame, bypassing plugins.
+func (w *serverCodecWrapper) writeControl(resp *rpc.Response, body interface{}) error {
+	if w.WriteTimeout > 0 {
+		w.Conn.SetWriteDeadline(time.Now().Add(w.WriteTimeout))
+	}
+	return w.writeMessage(resp, body)
+}
+
+// writeMessage writes a response with the codec as one message.
+func (w *serverCodecWrapper) writeMessage(resp *rpc.Response, body interface{}) error {
+	err := w.ServerCodec.WriteResponse(resp, body)
+	if w.mux != nil {
+		w.mux.endMessage(err != nil)
+	}
+	return err
+}
+
 func (w *serverCodecWrapper) Close() error {
 	//pre
 	err := w.ServerCodec.Close()
@@ -113,22 +268,36 @@ type Server struct {
 	ServerCodecFunc ServerCodecFunc
 	//PluginContainer must be configured before starting and Register plugins must be configured before invoking RegisterName method
 	PluginContainer IServerPluginContainer
+	//Interceptors wrap the handling of every call which is not streaming, the first one outermost.
+	//They run inside the call plugins.
+	Interceptors []UnaryServerInterceptor
 	//Metadata describes extra info about this service, for example, weight, active status
 	Metadata     string
-	rpcServer    *rpc.Server
+	services     *serviceMap
 	listener     net.Listener
 	Timeout      time.Duration
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
+	//IdleTimeout closes connections which send nothing, not even heartbeats, for this long
+	IdleTimeout time.Duration
+	//StreamWindow is the number of items a streaming call buffers for its service method
+	StreamWindow int
+	//Logger logs the errors of accepting and serving connections, DefaultLogger if nil
+	Logger Logger
+
+	connsMu sync.Mutex
+	conns   map[*ServerConn]struct{}
 }
 
 // NewServer returns a new Server.
 func NewServer() *Server {
-	return &Server{
-		rpcServer:       rpc.NewServer(),
+	s := &Server{
+		services:        newServiceMap(),
 		PluginContainer: &ServerPluginContainer{plugins: make([]IPlugin, 0)},
 		ServerCodecFunc: msgpackrpc.NewServerCodec,
+		conns:           make(map[*ServerConn]struct{}),
 	}
+	return s
 }
 
 // DefaultServer is the default instance of *Server.
@@ -200,70 +369,76 @@ func Auth(fn AuthorizationFunc) error {
 // Serve starts and listens RCP requests.
 //It is blocked until receiving connectings from clients.
 func (s *Server) Serve(network, address string) {
-	ln, err := net.Listen(network, address)
+	ln, err := listen(network, address)
 	if err != nil {
+		s.logger().Error("listening", "network", network, "address", address, "err", err)
 		return
 	}
 
 	s.listener = ln
-	for {
-		c, err := ln.Accept()
-		if err != nil {
-			continue
-		}
-		wrapper := newServerCodecWrapper(s.PluginContainer, s.ServerCodecFunc(c), c)
-		wrapper.Timeout = s.Timeout
-		wrapper.ReadTimeout = s.ReadTimeout
-		wrapper.WriteTimeout = s.WriteTimeout
-		go s.rpcServer.ServeCodec(wrapper)
-	}
+	s.acceptLoop(ln)
 }
 
 // ServeTLS starts and listens RCP requests.
 //It is blocked until receiving connectings from clients.
 func (s *Server) ServeTLS(network, address string, config *tls.Config) {
-	ln, err := tls.Listen(network, address, config)
+	ln, err := listenTLS(network, address, config)
 	if err != nil {
+		s.logger().Error("listening", "network", network, "address", address, "err", err)
 		return
 	}
 
 	s.listener = ln
-	for {
-		c, err := ln.Accept()
-		if err != nil {
-			continue
-		}
-
-		wrapper := newServerCodecWrapper(s.PluginContainer, s.ServerCodecFunc(c), c)
-		wrapper.Timeout = s.Timeout
-		wrapper.ReadTimeout = s.ReadTimeout
-		wrapper.WriteTimeout = s.WriteTimeout
-		go s.rpcServer.ServeCodec(wrapper)
-	}
+	s.acceptLoop(ln)
 }
 
 // ServeListener starts
 func (s *Server) ServeListener(ln net.Listener) {
 	s.listener = ln
+	s.acceptLoop(ln)
+}
+
+// acceptLoop serves the connections accepted by ln until it is closed.
+// Temporary errors, like running out of file descriptors, are retried with a backoff.
+func (s *Server) acceptLoop(ln net.Listener) {
+	var delay time.Duration
 	for {
 		c, err := ln.Accept()
 		if err != nil {
-			continue
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				s.logger().Warn("accepting connection", "address", ln.Addr().String(), "err", err, "retry_in", delay)
+				time.Sleep(delay)
+				continue
+			}
+			s.logger().Info("stopped accepting connections", "address", ln.Addr().String(), "err", err)
+			return
 		}
-		wrapper := newServerCodecWrapper(s.PluginContainer, s.ServerCodecFunc(c), c)
-		wrapper.Timeout = s.Timeout
-		wrapper.ReadTimeout = s.ReadTimeout
-		wrapper.WriteTimeout = s.WriteTimeout
+		delay = 0
 
-		go s.rpcServer.ServeCodec(wrapper)
+		if !s.PluginContainer.DoPostConnAccept(c) {
+			continue
+		}
+		go s.serveConn(c)
 	}
 }
 
+// logger returns the Logger of s.
+func (s *Server) logger() Logger {
+	return LoggerOr(s.Logger)
+}
+
 // ServeByHTTP starts
 func (s *Server) ServeByHTTP(ln net.Listener, rpcPath string) {
 	http.Handle(rpcPath, s)
 	srv := &http.Server{Handler: nil}
-	srv.Serve(ln)
+	if err := srv.Serve(ln); err != nil {
+		s.logger().Error("serving HTTP", "address", ln.Addr().String(), "err", err)
+	}
 }
 
 var connected = "200 Connected to Go RPC"
@@ -278,75 +453,108 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 	conn, _, err := w.(http.Hijacker).Hijack()
 	if err != nil {
-		log.Print("rpc hijacking ", req.RemoteAddr, ": ", err.Error())
+		s.logger().Error("hijacking HTTP connection", "remote", req.RemoteAddr, "err", err)
+		return
+	}
+	if !s.PluginContainer.DoPostConnAccept(conn) {
 		return
 	}
 	io.WriteString(conn, "HTTP/1.0 "+connected+"\n\n")
 
-	wrapper := newServerCodecWrapper(s.PluginContainer, s.ServerCodecFunc(conn), conn)
+	s.serveConn(conn)
+}
+
+// serveConn serves a connection, which the IPostConnAcceptPlugins accepted, until the client hangs up.
+func (s *Server) serveConn(conn net.Conn) {
+	// clients which multiplex their connection say so first
+	br := bufio.NewReader(conn)
+	var codecConn net.Conn = &bufferedConn{Conn: conn, r: br}
+	var mux *muxConn
+	// a client which sends nothing must not hold the connection forever
+	timeout := s.ReadTimeout
+	if timeout <= 0 {
+		timeout = s.IdleTimeout
+	}
+	if timeout <= 0 {
+		timeout = s.Timeout
+	}
+	if timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+	b, err := br.Peek(len(muxPreface))
+	if err != nil && len(b) == 0 {
+		s.logger().Debug("closing connection which sent no request", "remote", conn.RemoteAddr().String(), "err", err)
+		conn.Close()
+		doPostConnClose(s.PluginContainer, conn)
+		return
+	}
+	if timeout > 0 {
+		conn.SetReadDeadline(time.Time{})
+	}
+	if err == nil && string(b) == muxPreface {
+		br.Discard(len(muxPreface))
+		mux = newMuxConn(codecConn)
+		codecConn = mux
+	}
+	counter := &countingConn{Conn: codecConn}
+
+	wrapper := newServerCodecWrapper(s.PluginContainer, s.ServerCodecFunc(counter), conn)
+	wrapper.mux = mux
+	wrapper.counter = counter
+	wrapper.codecFunc = s.ServerCodecFunc
 	wrapper.Timeout = s.Timeout
 	wrapper.ReadTimeout = s.ReadTimeout
 	wrapper.WriteTimeout = s.WriteTimeout
+	wrapper.IdleTimeout = s.IdleTimeout
+
+	sc := newServerConn(s, wrapper)
+	s.connsMu.Lock()
+	s.conns[sc] = struct{}{}
+	s.connsMu.Unlock()
+
+	sc.serve()
+
+	s.connsMu.Lock()
+	delete(s.conns, sc)
+	s.connsMu.Unlock()
+
+	doPostConnClose(s.PluginContainer, conn)
+}
 
-	s.rpcServer.ServeCodec(wrapper)
+// Conns returns the client connections being served.
+func (s *Server) Conns() []*ServerConn {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+
+	conns := make([]*ServerConn, 0, len(s.conns))
+	for sc := range s.conns {
+		conns = append(conns, sc)
+	}
+	return conns
 }
 
 // Start starts and listens RCP requests without blocking.
 func (s *Server) Start(network, address string) {
-	ln, err := net.Listen(network, address)
+	ln, err := listen(network, address)
 	if err != nil {
+		s.logger().Error("listening", "network", network, "address", address, "err", err)
 		return
 	}
 
 	s.listener = ln
-
-	go func() {
-		for {
-			c, err := ln.Accept()
-			if err != nil {
-				continue
-			}
-
-			if !s.PluginContainer.DoPostConnAccept(c) {
-				continue
-			}
-			wrapper := newServerCodecWrapper(s.PluginContainer, s.ServerCodecFunc(c), c)
-			wrapper.Timeout = s.Timeout
-			wrapper.ReadTimeout = s.ReadTimeout
-			wrapper.WriteTimeout = s.WriteTimeout
-
-			go s.rpcServer.ServeCodec(wrapper)
-		}
-	}()
+	go s.acceptLoop(ln)
 }
 
 // StartTLS starts and listens RCP requests without blocking.
 func (s *Server) StartTLS(network, address string, config *tls.Config) {
-	ln, err := tls.Listen(network, address, config)
+	ln, err := listenTLS(network, address, config)
 	if err != nil {
+		s.logger().Error("listening", "network", network, "address", address, "err", err)
 		return
 	}
 
 	s.listener = ln
-
-	go func() {
-		for {
-			c, err := ln.Accept()
-			if err != nil {
-				continue
-			}
-
-			if !s.PluginContainer.DoPostConnAccept(c) {
-				continue
-			}
-			wrapper := newServerCodecWrapper(s.PluginContainer, s.ServerCodecFunc(c), c)
-			wrapper.Timeout = s.Timeout
-			wrapper.ReadTimeout = s.ReadTimeout
-			wrapper.WriteTimeout = s.WriteTimeout
-
-			go s.rpcServer.ServeCodec(wrapper)
-		}
-	}()
+	go s.acceptLoop(ln)
 }
 
 // Close closes RPC server.
@@ -365,15 +573,27 @@ func (s *Server) Address() string {
 //	- two arguments, both of exported type
 //	- the second argument is a pointer
 //	- one return value, of type error
-// It returns an error if the receiver is not an exported type or has
-// no suitable methods. It also logs the error using package log.
+// A method may also take a context.Context before its arguments,
+// which carries the ServerConn the request arrived on.
+// Streaming methods take a *Stream in place of args, reply or both, see Stream.
+// It logs the error using package log if the receiver has no suitable methods.
 // The client accesses each method using a string of the form "Type.Method",
-// where Type is the receiver's concrete type.
+// where Type is the name.
 func (s *Server) RegisterName(name string, service interface{}, metadata ...string) {
-	s.rpcServer.RegisterName(name, service)
+	if err := s.services.register(name, service); err != nil {
+		s.logger().Error("registering service", "name", name, "err", err)
+	}
 	s.PluginContainer.DoRegister(name, service, metadata...)
 }
 
+// EnableReflection publishes the reflection service, which describes the services of s
+// and the signatures of their methods, see ReflectionServiceName. It is off by default since
+// it describes them to every client which can connect. Its calls run the call plugins
+// like those of other services, so RBACPlugin policies and MTLSAuthPlugin rules cover it.
+func (s *Server) EnableReflection() error {
+	return s.services.register(ReflectionServiceName, &Reflection{services: s.services})
+}
+
 //Auth sets authorization function
 func (s *Server) Auth(fn AuthorizationFunc) error {
 	p := &AuthorizationServerPlugin{AuthorizationFunc: fn}
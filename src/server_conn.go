@@ -0,0 +1,393 @@
+package src
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// A value sent as a placeholder for the response value when the request failed.
+// It is never decoded by the peer since the frame contains an error when it is used.
+var invalidRequest = struct{}{}
+
+type serverConnKey struct{}
+
+// ServerConnFromContext returns the connection which a request arrived on.
+// The context passed to service methods of the form
+//	func (t *T) M(ctx context.Context, args *A, reply *R) error
+// always carries it. The context also carries the metadata sent by the client,
+// see MetadataFromContext, and the authorization of the request, see AuthorizationFromContext.
+// It is cancelled when the client disconnects or the deadline of the call passes.
+func ServerConnFromContext(ctx context.Context) (*ServerConn, bool) {
+	sc, ok := ctx.Value(serverConnKey{}).(*ServerConn)
+	return sc, ok
+}
+
+// ServerConn is a client connection accepted by Server.
+// Besides answering requests it can push notifications to the client and call
+// the services the client published with Client.RegisterName.
+// Pushed frames carry their method in the response header, so the codec must
+// round-trip rpc.Response.ServiceMethod, as the default msgpack codec does.
+type ServerConn struct {
+	server  *Server
+	codec   *serverCodecWrapper
+	sending sync.Mutex // serializes writes to codec
+
+	mu       sync.Mutex // protects following
+	seq      uint64
+	pending  map[uint64]*rpc.Call
+	streams  map[uint64]*Stream // streaming calls in progress by request seq
+	shutdown bool
+
+	ctx    context.Context // parent of the contexts of handlers, cancelled when the connection ends
+	cancel context.CancelFunc
+}
+
+// serverRequest is a request read by ServerConn.readRequest.
+type serverRequest struct {
+	req          *rpc.Request
+	state        *requestState
+	svc          *service
+	mtype        *methodType
+	argv, replyv reflect.Value
+	stream       *Stream
+}
+
+func newServerConn(s *Server, codec *serverCodecWrapper) *ServerConn {
+	sc := &ServerConn{
+		server:  s,
+		codec:   codec,
+		pending: make(map[uint64]*rpc.Call),
+		streams: make(map[uint64]*Stream),
+	}
+	sc.ctx, sc.cancel = context.WithCancel(context.Background())
+	return sc
+}
+
+// RemoteAddr returns the address of the client.
+func (sc *ServerConn) RemoteAddr() net.Addr {
+	return sc.codec.Conn.RemoteAddr()
+}
+
+// TLSConnectionState returns the state of the TLS connection, if the client connected with TLS.
+func (sc *ServerConn) TLSConnectionState() (tls.ConnectionState, bool) {
+	if conn, ok := sc.codec.Conn.(*tls.Conn); ok {
+		return conn.ConnectionState(), true
+	}
+	return tls.ConnectionState{}, false
+}
+
+// Close closes the connection.
+func (sc *ServerConn) Close() error {
+	return sc.codec.Conn.Close()
+}
+
+// Push sends a notification to the client, which runs its handler for serviceMethod
+// without answering.
+func (sc *ServerConn) Push(serviceMethod string, args interface{}) error {
+	if !carriesControlFrames(sc.codec.ServerCodec) {
+		return ErrNoControlFrames
+	}
+	sc.sending.Lock()
+	defer sc.sending.Unlock()
+	return sc.codec.writeControl(&rpc.Response{ServiceMethod: pushPrefix + serviceMethod}, args)
+}
+
+// Go invokes serviceMethod on the client asynchronously. See Client.Go for the meaning of done.
+func (sc *ServerConn) Go(serviceMethod string, args interface{}, reply interface{}, done chan *rpc.Call) *rpc.Call {
+	if done == nil {
+		done = make(chan *rpc.Call, 1)
+	} else if cap(done) == 0 {
+		panic("rpc: done channel is unbuffered")
+	}
+	call := &rpc.Call{ServiceMethod: serviceMethod, Args: args, Reply: reply, Done: done}
+	if !carriesControlFrames(sc.codec.ServerCodec) {
+		call.Error = ErrNoControlFrames
+		callDone(call)
+		return call
+	}
+
+	sc.mu.Lock()
+	if sc.shutdown {
+		sc.mu.Unlock()
+		call.Error = rpc.ErrShutdown
+		callDone(call)
+		return call
+	}
+	sc.seq++
+	seq := sc.seq
+	sc.pending[seq] = call
+	sc.mu.Unlock()
+
+	sc.sending.Lock()
+	err := sc.codec.writeControl(&rpc.Response{ServiceMethod: callPrefix + serviceMethod, Seq: seq}, args)
+	sc.sending.Unlock()
+	if err != nil {
+		sc.mu.Lock()
+		call = sc.pending[seq]
+		delete(sc.pending, seq)
+		sc.mu.Unlock()
+		if call != nil {
+			call.Error = err
+			callDone(call)
+		}
+	}
+	return call
+}
+
+// Call invokes serviceMethod on the client, waits for it to complete, and returns its error status.
+func (sc *ServerConn) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	call := <-sc.Go(serviceMethod, args, reply, make(chan *rpc.Call, 1)).Done
+	return call.Error
+}
+
+func callDone(call *rpc.Call) {
+	select {
+	case call.Done <- call:
+	default:
+		// the caller must make sure the channel has enough buffer space.
+	}
+}
+
+// serve reads requests until the connection is closed.
+func (sc *ServerConn) serve() {
+	wg := new(sync.WaitGroup)
+	for {
+		sreq, keepReading, err := sc.readRequest()
+		if err != nil {
+			if !keepReading {
+				if err != io.EOF && err != io.ErrUnexpectedEOF {
+					sc.server.logger().Warn("closing connection", "remote", sc.RemoteAddr().String(), "err", err)
+				}
+				break
+			}
+			// send a response if we actually managed to read a header.
+			if sreq.req != nil {
+				sc.sendResponse(sreq.req, invalidRequest, err.Error())
+			}
+			continue
+		}
+		wg.Add(1)
+		go sc.call(wg, sreq)
+	}
+
+	// the client is gone, handlers still running should give up
+	sc.cancel()
+
+	sc.mu.Lock()
+	sc.shutdown = true
+	for _, call := range sc.pending {
+		call.Error = rpc.ErrShutdown
+		callDone(call)
+	}
+	sc.pending = nil
+	for _, st := range sc.streams {
+		st.endSend(rpc.ErrShutdown)
+		st.endRecv(rpc.ErrShutdown)
+	}
+	sc.mu.Unlock()
+
+	// We've seen that there are no more requests.
+	// Wait for responses to be sent before closing codec.
+	wg.Wait()
+	sc.codec.Close()
+}
+
+func (sc *ServerConn) readRequest() (sreq serverRequest, keepReading bool, err error) {
+	for {
+		req := new(rpc.Request)
+		err = sc.codec.ReadRequestHeader(req)
+		sreq.state = sc.codec.reading
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return
+			}
+			err = errors.New("rpc: server cannot decode request: " + err.Error())
+			return
+		}
+		if !strings.HasPrefix(req.ServiceMethod, controlPrefix) {
+			sreq.req = req
+			break
+		}
+
+		if err = sc.readControl(req); err != nil {
+			return
+		}
+	}
+
+	// We read the header successfully. If we see an error now,
+	// we can still recover and move on to the next request.
+	keepReading = true
+
+	sreq.svc, sreq.mtype, err = sc.server.services.lookup(sreq.req.ServiceMethod)
+	if err != nil {
+		// discard body
+		sc.codec.ReadRequestBody(nil)
+		return
+	}
+	mtype := sreq.mtype
+	if mtype.stream != noStream && !carriesControlFrames(sc.codec.ServerCodec) {
+		sc.codec.ReadRequestBody(nil)
+		err = ErrNoControlFrames
+		return
+	}
+
+	if mtype.stream == noStream || mtype.stream == serverStream {
+		argv, argIsValue := mtype.newArgs()
+		if err = sc.codec.ReadRequestBody(argv.Interface()); err != nil {
+			return
+		}
+		if argIsValue {
+			argv = argv.Elem()
+		}
+		sreq.argv = argv
+	} else if err = sc.codec.ReadRequestBody(nil); err != nil {
+		return
+	}
+
+	if mtype.stream == noStream || mtype.stream == clientStream {
+		sreq.replyv = mtype.newReply()
+	}
+	if mtype.stream != noStream {
+		sreq.stream = sc.openStream(sreq.req.Seq)
+	}
+	return
+}
+
+// openStream registers the stream of a streaming call before its items arrive.
+func (sc *ServerConn) openStream(seq uint64) *Stream {
+	st := newStream(sc.server.StreamWindow)
+	st.write = func(serviceMethod string, body interface{}) error {
+		sc.sending.Lock()
+		defer sc.sending.Unlock()
+		return sc.codec.writeControl(&rpc.Response{ServiceMethod: serviceMethod, Seq: seq}, body)
+	}
+
+	sc.mu.Lock()
+	sc.streams[seq] = st
+	sc.mu.Unlock()
+	return st
+}
+
+// readControl handles a control frame sent by the client.
+func (sc *ServerConn) readControl(req *rpc.Request) error {
+	if req.ServiceMethod == pingMethod {
+		if err := sc.codec.readControlBody(nil); err != nil {
+			return err
+		}
+		sc.sending.Lock()
+		defer sc.sending.Unlock()
+		return sc.codec.writeControl(&rpc.Response{ServiceMethod: pingMethod, Seq: req.Seq}, invalidRequest)
+	}
+
+	if !strings.HasPrefix(req.ServiceMethod, replyPrefix) {
+		sc.mu.Lock()
+		st := sc.streams[req.Seq]
+		sc.mu.Unlock()
+		return readStreamControl(st, req.ServiceMethod, sc.codec.readControlBody)
+	}
+
+	errmsg := req.ServiceMethod[len(replyPrefix):]
+	sc.mu.Lock()
+	call := sc.pending[req.Seq]
+	delete(sc.pending, req.Seq)
+	sc.mu.Unlock()
+
+	var err error
+	switch {
+	case call == nil:
+		err = sc.codec.readControlBody(nil)
+	case errmsg != "":
+		call.Error = rpc.ServerError(errmsg)
+		err = sc.codec.readControlBody(nil)
+		callDone(call)
+	default:
+		err = sc.codec.readControlBody(call.Reply)
+		if err != nil {
+			call.Error = errors.New("reading body " + err.Error())
+		}
+		callDone(call)
+	}
+	return err
+}
+
+func (sc *ServerConn) call(wg *sync.WaitGroup, sreq serverRequest) {
+	defer wg.Done()
+
+	ctx, cancel := sreq.state.context(sc)
+	defer cancel()
+
+	info := &CallInfo{ServiceMethod: sreq.req.ServiceMethod, Peer: sc.RemoteAddr().String()}
+	if sreq.argv.IsValid() {
+		info.Args = sreq.argv.Interface()
+	}
+	if sreq.replyv.IsValid() {
+		info.Reply = sreq.replyv.Interface()
+	}
+
+	size := &wireSize{}
+	if sreq.state != nil {
+		size.request = sreq.state.size
+	}
+	ctx = context.WithValue(ctx, wireSizeKey{}, size)
+
+	pc := sc.server.PluginContainer
+	ctx, err := containerPreCall(pc, ctx, info)
+	if err == nil {
+		if sreq.stream != nil || len(sc.server.Interceptors) == 0 {
+			err = sreq.svc.call(ctx, sreq.mtype, sreq.argv, sreq.replyv, sreq.stream)
+		} else {
+			// the handler invokes the method with the args and the reply read for the call,
+			// which interceptors may change but not replace
+			handler := func(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+				return sreq.svc.call(ctx, sreq.mtype, sreq.argv, sreq.replyv, nil)
+			}
+			err = chainServerInterceptors(sc.server.Interceptors, handler)(ctx, info.ServiceMethod, info.Args, info.Reply)
+		}
+	}
+	if sreq.stream != nil {
+		sc.mu.Lock()
+		delete(sc.streams, sreq.req.Seq)
+		sc.mu.Unlock()
+		sreq.stream.endSend(ErrStreamClosed)
+		sreq.stream.endRecv(ErrStreamClosed)
+	}
+
+	var reply interface{} = invalidRequest
+	if sreq.replyv.IsValid() {
+		reply = sreq.replyv.Interface()
+	}
+	errmsg := ""
+	if err != nil {
+		errmsg = err.Error()
+	}
+	size.response = sc.sendResponse(sreq.req, reply, errmsg)
+
+	// the call is done once its response is written
+	containerPostCall(pc, ctx, info, err)
+}
+
+// sendResponse writes the response to req, and returns how many bytes it took.
+func (sc *ServerConn) sendResponse(req *rpc.Request, reply interface{}, errmsg string) int64 {
+	resp := &rpc.Response{ServiceMethod: req.ServiceMethod, Seq: req.Seq}
+	if errmsg != "" {
+		resp.Error = errmsg
+		reply = invalidRequest
+	}
+
+	sc.sending.Lock()
+	_, start := sc.codec.counter.counts()
+	err := sc.codec.WriteResponse(resp, reply)
+	_, end := sc.codec.counter.counts()
+	sc.sending.Unlock()
+	if err != nil {
+		sc.server.logger().Debug("writing response", "remote", sc.RemoteAddr().String(), "method", req.ServiceMethod, "err", err)
+	}
+	return end - start
+}
@@ -1,6 +1,7 @@
 package src
 
 import (
+	"context"
 	"net"
 	"net/rpc"
 )
@@ -107,6 +108,15 @@ func (p *ServerPluginContainer) DoPostConnAccept(conn net.Conn) bool {
 			flag := plugin.HandleConnAccept(conn)
 			if !flag { //interrupt
 				conn.Close()
+				// the plugins which accepted the conn see it closed
+				for j := 0; j < i; j++ {
+					if _, ok := p.plugins[j].(IPostConnAcceptPlugin); !ok {
+						continue
+					}
+					if plugin, ok := p.plugins[j].(IPostConnClosePlugin); ok {
+						plugin.HandleConnClose(conn)
+					}
+				}
 				return false
 			}
 		}
@@ -114,6 +124,28 @@ func (p *ServerPluginContainer) DoPostConnAccept(conn net.Conn) bool {
 	return true
 }
 
+//DoPostConnClose handle closed conn
+func (p *ServerPluginContainer) DoPostConnClose(conn net.Conn) {
+	for i := range p.plugins {
+		if plugin, ok := p.plugins[i].(IPostConnClosePlugin); ok {
+			plugin.HandleConnClose(conn)
+		}
+	}
+}
+
+// doPostConnClose invokes the IPostConnClosePlugins of pc.
+func doPostConnClose(pc IServerPluginContainer, conn net.Conn) {
+	if c, ok := pc.(IConnClosePluginContainer); ok {
+		c.DoPostConnClose(conn)
+		return
+	}
+	for _, p := range pc.GetAll() {
+		if plugin, ok := p.(IPostConnClosePlugin); ok {
+			plugin.HandleConnClose(conn)
+		}
+	}
+}
+
 // DoPreReadRequestHeader invokes DoPreReadRequestHeader plugin.
 func (p *ServerPluginContainer) DoPreReadRequestHeader(r *rpc.Request) error {
 	for i := range p.plugins {
@@ -130,12 +162,21 @@ func (p *ServerPluginContainer) DoPreReadRequestHeader(r *rpc.Request) error {
 
 // DoPostReadRequestHeader invokes DoPostReadRequestHeader plugin.
 func (p *ServerPluginContainer) DoPostReadRequestHeader(r *rpc.Request) error {
+	return p.doPostReadRequestHeader(r, nil)
+}
+
+// doPostReadRequestHeader invokes DoPostReadRequestHeader plugin, with st the state of the request
+// for the plugins which keep what they read in it.
+func (p *ServerPluginContainer) doPostReadRequestHeader(r *rpc.Request, st *requestState) error {
 	for i := range p.plugins {
-		if plugin, ok := p.plugins[i].(IPostReadRequestHeaderPlugin); ok {
-			err := plugin.PostReadRequestHeader(r)
-			if err != nil {
-				return err
-			}
+		var err error
+		if plugin, ok := p.plugins[i].(requestHeaderPlugin); ok && st != nil {
+			err = plugin.postReadRequestHeader(r, st)
+		} else if plugin, ok := p.plugins[i].(IPostReadRequestHeaderPlugin); ok {
+			err = plugin.PostReadRequestHeader(r)
+		}
+		if err != nil {
+			return err
 		}
 	}
 
@@ -198,6 +239,16 @@ func (p *ServerPluginContainer) DoPostWriteResponse(resp *rpc.Response, body int
 	return nil
 }
 
+// DoPreCall invokes IPreCallPlugin plugins.
+func (p *ServerPluginContainer) DoPreCall(ctx context.Context, info *CallInfo) (context.Context, error) {
+	return doPreCall(p.plugins, ctx, info)
+}
+
+// DoPostCall invokes IPostCallPlugin plugins.
+func (p *ServerPluginContainer) DoPostCall(ctx context.Context, info *CallInfo, err error) {
+	doPostCall(p.plugins, ctx, info, err)
+}
+
 type (
 	//IRegisterPlugin represents register plugin.
 	IRegisterPlugin interface {
@@ -211,6 +262,19 @@ type (
 		HandleConnAccept(net.Conn) bool
 	}
 
+	//IPostConnClosePlugin represents connection close plugin.
+	// It is invoked once the server is done with a conn which the IPostConnAcceptPlugins accepted.
+	IPostConnClosePlugin interface {
+		HandleConnClose(net.Conn)
+	}
+
+	//IConnClosePluginContainer is implemented by the plugin containers which invoke the
+	//IPostConnClosePlugins themselves, like ServerPluginContainer.
+	//The IPostConnClosePlugins returned by GetAll are invoked for other containers.
+	IConnClosePluginContainer interface {
+		DoPostConnClose(net.Conn)
+	}
+
 	//IServerCodecPlugin represents .
 	IServerCodecPlugin interface {
 		IPreReadRequestHeaderPlugin
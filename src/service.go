@@ -0,0 +1,214 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"go/token"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Precompute the reflect types for error and context.Context.
+var (
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+type methodType struct {
+	method    reflect.Method
+	ArgType   reflect.Type
+	ReplyType reflect.Type
+	//WithContext is true if the method takes a context.Context as its first argument.
+	WithContext bool
+	stream      streamType
+}
+
+type service struct {
+	name   string                 // name of service
+	rcvr   reflect.Value          // receiver of methods for the service
+	typ    reflect.Type           // type of the receiver
+	method map[string]*methodType // registered methods
+}
+
+// serviceMap holds the services published by RegisterName.
+// It is used by Server for requests and by Client for calls pushed from servers.
+type serviceMap struct {
+	mu       sync.RWMutex
+	services map[string]*service
+}
+
+func newServiceMap() *serviceMap {
+	return &serviceMap{services: make(map[string]*service)}
+}
+
+// isExportedOrBuiltinType reports whether t is an exported or builtin type.
+func isExportedOrBuiltinType(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	// PkgPath will be non-empty even for an exported type,
+	// so we need to check the type name as well.
+	return token.IsExported(t.Name()) || t.PkgPath() == ""
+}
+
+func (m *serviceMap) register(name string, rcvr interface{}) error {
+	s := &service{
+		name: name,
+		rcvr: reflect.ValueOf(rcvr),
+		typ:  reflect.TypeOf(rcvr),
+	}
+	if name == "" {
+		return errors.New("rpc.Register: no service name for type " + s.typ.String())
+	}
+
+	s.method = suitableMethods(s.typ)
+	if len(s.method) == 0 {
+		return errors.New("rpc.Register: type " + name + " has no exported methods of suitable type")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, dup := m.services[name]; dup {
+		return errors.New("rpc: service already defined: " + name)
+	}
+	m.services[name] = s
+	return nil
+}
+
+// suitableMethods returns the methods of typ which look like
+//	func (t *T) M(args *A, reply *R) error
+// or like one of the streaming forms
+//	func (t *T) M(args *A, stream *Stream) error
+//	func (t *T) M(stream *Stream, reply *R) error
+//	func (t *T) M(stream *Stream) error
+// Each of them may take a context.Context before its other arguments.
+func suitableMethods(typ reflect.Type) map[string]*methodType {
+	methods := make(map[string]*methodType)
+	for m := 0; m < typ.NumMethod(); m++ {
+		method := typ.Method(m)
+		mtype := method.Type
+		// Method must be exported.
+		if method.PkgPath != "" {
+			continue
+		}
+		// Method needs one out of type error.
+		if mtype.NumOut() != 1 || mtype.Out(0) != typeOfError {
+			continue
+		}
+
+		// Skip the receiver and an optional leading context.
+		first := 1
+		withContext := mtype.NumIn() > 1 && mtype.In(1) == typeOfContext
+		if withContext {
+			first = 2
+		}
+
+		mt := &methodType{method: method, WithContext: withContext}
+		switch mtype.NumIn() - first {
+		case 1:
+			if mtype.In(first) != typeOfStream {
+				continue
+			}
+			mt.stream = bidiStream
+		case 2:
+			mt.ArgType = mtype.In(first)
+			mt.ReplyType = mtype.In(first + 1)
+			if mt.ArgType == typeOfStream && mt.ReplyType == typeOfStream {
+				continue
+			}
+			if mt.ArgType == typeOfStream {
+				mt.stream = clientStream
+			} else if mt.ReplyType == typeOfStream {
+				mt.stream = serverStream
+			}
+			// First arg need not be a pointer.
+			if mt.stream != clientStream && !isExportedOrBuiltinType(mt.ArgType) {
+				continue
+			}
+			// Second arg must be a pointer and must be exported.
+			if mt.stream != serverStream && (mt.ReplyType.Kind() != reflect.Ptr || !isExportedOrBuiltinType(mt.ReplyType)) {
+				continue
+			}
+		default:
+			continue
+		}
+		methods[method.Name] = mt
+	}
+	return methods
+}
+
+// lookup finds the service and method for a "Service.Method" name.
+func (m *serviceMap) lookup(serviceMethod string) (svc *service, mtype *methodType, err error) {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot < 0 {
+		err = errors.New("rpc: service/method request ill-formed: " + serviceMethod)
+		return
+	}
+	serviceName := serviceMethod[:dot]
+	methodName := serviceMethod[dot+1:]
+
+	m.mu.RLock()
+	svc = m.services[serviceName]
+	m.mu.RUnlock()
+	if svc == nil {
+		err = errors.New("rpc: can't find service " + serviceMethod)
+		return
+	}
+	mtype = svc.method[methodName]
+	if mtype == nil {
+		err = errors.New("rpc: can't find method " + serviceMethod)
+	}
+	return
+}
+
+// newArgs allocates a value to decode the arguments of mtype into.
+// argIsValue reports whether the method takes its arguments by value.
+func (mtype *methodType) newArgs() (argv reflect.Value, argIsValue bool) {
+	if mtype.ArgType.Kind() == reflect.Ptr {
+		argv = reflect.New(mtype.ArgType.Elem())
+	} else {
+		argv = reflect.New(mtype.ArgType)
+		argIsValue = true
+	}
+	return
+}
+
+// newReply allocates the reply of mtype.
+func (mtype *methodType) newReply() reflect.Value {
+	replyv := reflect.New(mtype.ReplyType.Elem())
+
+	switch mtype.ReplyType.Elem().Kind() {
+	case reflect.Map:
+		replyv.Elem().Set(reflect.MakeMap(mtype.ReplyType.Elem()))
+	case reflect.Slice:
+		replyv.Elem().Set(reflect.MakeSlice(mtype.ReplyType.Elem(), 0, 0))
+	}
+	return replyv
+}
+
+// call invokes the method and returns the error it returned.
+// stream is only used by streaming methods, argv and replyv only where the method takes them.
+func (s *service) call(ctx context.Context, mtype *methodType, argv, replyv reflect.Value, stream *Stream) error {
+	in := []reflect.Value{s.rcvr}
+	if mtype.WithContext {
+		in = append(in, reflect.ValueOf(ctx))
+	}
+	switch mtype.stream {
+	case noStream:
+		in = append(in, argv, replyv)
+	case serverStream:
+		in = append(in, argv, reflect.ValueOf(stream))
+	case clientStream:
+		in = append(in, reflect.ValueOf(stream), replyv)
+	case bidiStream:
+		in = append(in, reflect.ValueOf(stream))
+	}
+
+	returnValues := mtype.method.Func.Call(in)
+	// The return value for the method is an error.
+	if errInter := returnValues[0].Interface(); errInter != nil {
+		return errInter.(error)
+	}
+	return nil
+}
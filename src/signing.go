@@ -0,0 +1,238 @@
+package src
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/rpc"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// metadata keys of the signature of a request
+const (
+	signatureKeyIDKey     = "rpct-sig-key"
+	signatureDigestKey    = "rpct-sig-digest"
+	signatureTimestampKey = "rpct-sig-ts"
+	signatureNonceKey     = "rpct-sig-nonce"
+	signatureKey          = "rpct-sig"
+)
+
+//DefaultSignatureMaxSkew is how far the timestamp of a signed request may be from the clock of the server
+const DefaultSignatureMaxSkew = 5 * time.Minute
+
+// requestSigner is implemented by the plugins which sign the requests of a Client.
+// They are invoked by the codec wrapper once every IPreWriteRequestPlugin has run, with the service
+// method and the metadata as they are sent, and add their signature to the metadata.
+type requestSigner interface {
+	signRequest(serviceMethod string, md Metadata) error
+}
+
+// requestVerifier is implemented by the plugins which verify the signatures of requests on a Server.
+// They are invoked by the codec wrapper as soon as the header of a request is read,
+// before any IPostReadRequestHeaderPlugin changes it.
+type requestVerifier interface {
+	verifyRequest(serviceMethod string, md Metadata) error
+}
+
+// bodyBuffer is the connection of the codecs which encode and decode the args of signed requests.
+type bodyBuffer struct {
+	bytes.Buffer
+}
+
+func (b *bodyBuffer) Close() error { return nil }
+
+// encodeBody returns the encoding of the args body of a request by the codecs of codecFunc.
+// Signed requests send these bytes as their body, so that the server checks the very bytes it decodes.
+func encodeBody(codecFunc ClientCodecFunc, body interface{}) ([]byte, error) {
+	var buf bodyBuffer
+	if err := codecFunc(&buf).WriteRequest(&rpc.Request{}, body); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeBody decodes the args encoded by encodeBody into body with the codecs of codecFunc.
+func decodeBody(codecFunc ServerCodecFunc, data []byte, body interface{}) error {
+	buf := &bodyBuffer{}
+	buf.Write(data)
+	codec := codecFunc(buf)
+	if err := codec.ReadRequestHeader(&rpc.Request{}); err != nil {
+		return err
+	}
+	return codec.ReadRequestBody(body)
+}
+
+// bodyDigest returns the hex SHA-256 of the encoded args of a request.
+func bodyDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// requestSignature returns the HMAC-SHA256 with key of the service method of a request
+// and of all of its metadata but the signature itself.
+func requestSignature(key []byte, serviceMethod string, md Metadata) string {
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		if k != signatureKey {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	mac := hmac.New(sha256.New, key)
+	// every field is prefixed with its length, so that no two requests are signed the same
+	write := func(s string) {
+		mac.Write([]byte(strconv.Itoa(len(s)) + ":" + s))
+	}
+	write(serviceMethod)
+	for _, k := range keys {
+		write(k)
+		write(md[k])
+	}
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SigningClientPlugin signs the requests of a Client with a key shared with the servers.
+// The signature covers the service method and the whole metadata of the request as they are sent,
+// with the digest of its encoded args, a timestamp and a nonce, and is sent in the metadata.
+// Requests with nil args are signed with the digest of no bytes, so every request binds its args.
+// The args of a signed request are sent as the bytes their codec encoded them to, which the server
+// checks against the digest before decoding them. The items of streams are not signed.
+//
+// Requests are signed after every IPreWriteRequestPlugin has run, whatever the order the plugins
+// were added in, so the signature also covers the authorization which AuthorizationClientPlugin
+// puts in the service method.
+type SigningClientPlugin struct {
+	KeyID string
+	Key   []byte
+}
+
+// NewSigningClientPlugin creates a SigningClientPlugin which signs with key, known to servers as keyID.
+func NewSigningClientPlugin(keyID string, key []byte) *SigningClientPlugin {
+	return &SigningClientPlugin{KeyID: keyID, Key: key}
+}
+
+// signRequest signs a request.
+func (plugin *SigningClientPlugin) signRequest(serviceMethod string, md Metadata) error {
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+
+	md[signatureKeyIDKey] = plugin.KeyID
+	md[signatureTimestampKey] = strconv.FormatInt(time.Now().UnixNano(), 10)
+	md[signatureNonceKey] = hex.EncodeToString(nonce[:])
+	md[signatureKey] = requestSignature(plugin.Key, serviceMethod, md)
+	return nil
+}
+
+// Name return name of this plugin.
+func (plugin *SigningClientPlugin) Name() string {
+	return "SigningClientPlugin"
+}
+
+// Description return description of this plugin.
+func (plugin *SigningClientPlugin) Description() string {
+	return "a signing plugin which signs requests with a shared key"
+}
+
+// SigningServerPlugin verifies the signatures of SigningClientPlugin. It rejects requests whose
+// timestamp is more than MaxSkew away and requests whose nonce was seen before within that window.
+// Like AuthorizationServerPlugin, a request with a bad signature closes its connection.
+//
+// Signatures are verified as soon as the header of a request is read, before every
+// IPostReadRequestHeaderPlugin, so AuthorizationServerPlugin only sees authorizations which
+// were signed, whatever the order the plugins were added in. The args are checked against
+// the signed digest when they are read.
+type SigningServerPlugin struct {
+	//Keys are the shared keys by key ID
+	Keys map[string][]byte
+	//MaxSkew is DefaultSignatureMaxSkew if zero
+	MaxSkew time.Duration
+
+	mu        sync.Mutex
+	nonces    map[string]time.Time // until when nonces are remembered
+	lastSweep time.Time
+}
+
+// NewSigningServerPlugin creates a SigningServerPlugin which verifies signatures with keys by key ID.
+func NewSigningServerPlugin(keys map[string][]byte) *SigningServerPlugin {
+	return &SigningServerPlugin{Keys: keys}
+}
+
+func (plugin *SigningServerPlugin) maxSkew() time.Duration {
+	if plugin.MaxSkew > 0 {
+		return plugin.MaxSkew
+	}
+	return DefaultSignatureMaxSkew
+}
+
+// verifyRequest verifies the signature of a request, its timestamp and its nonce.
+func (plugin *SigningServerPlugin) verifyRequest(serviceMethod string, md Metadata) error {
+	if md[signatureKey] == "" {
+		return errors.New("rpc: request is not signed")
+	}
+	if md[signatureDigestKey] == "" {
+		return errors.New("rpc: request args are not signed")
+	}
+
+	key, ok := plugin.Keys[md[signatureKeyIDKey]]
+	if !ok {
+		return errors.New("rpc: unknown signing key " + md[signatureKeyIDKey])
+	}
+	expected := requestSignature(key, serviceMethod, md)
+	if !hmac.Equal([]byte(expected), []byte(md[signatureKey])) {
+		return errors.New("rpc: invalid request signature")
+	}
+
+	ns, err := strconv.ParseInt(md[signatureTimestampKey], 10, 64)
+	if err != nil {
+		return errors.New("rpc: invalid request timestamp")
+	}
+	now := time.Now()
+	skew := now.Sub(time.Unix(0, ns))
+	if skew > plugin.maxSkew() || skew < -plugin.maxSkew() {
+		return errors.New("rpc: stale request timestamp")
+	}
+
+	plugin.mu.Lock()
+	defer plugin.mu.Unlock()
+
+	if plugin.nonces == nil {
+		plugin.nonces = make(map[string]time.Time)
+		plugin.lastSweep = now
+	}
+	if now.Sub(plugin.lastSweep) > plugin.maxSkew() {
+		for nonce, until := range plugin.nonces {
+			if now.After(until) {
+				delete(plugin.nonces, nonce)
+			}
+		}
+		plugin.lastSweep = now
+	}
+
+	nonce := md[signatureNonceKey]
+	if until, seen := plugin.nonces[nonce]; seen && now.Before(until) {
+		return errors.New("rpc: replayed request")
+	}
+	// a replay is stale once its timestamp is MaxSkew in the past
+	plugin.nonces[nonce] = time.Unix(0, ns).Add(plugin.maxSkew())
+	return nil
+}
+
+// Name return name of this plugin.
+func (plugin *SigningServerPlugin) Name() string {
+	return "SigningServerPlugin"
+}
+
+// Description return description of this plugin.
+func (plugin *SigningServerPlugin) Description() string {
+	return "a signing plugin which verifies the signatures of requests"
+}
@@ -0,0 +1,163 @@
+package src
+
+import (
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var testSigningKeys = map[string][]byte{"k1": []byte("secret")}
+
+// startSigningServer serves MemoryArith on a new MemoryListener, verifying signatures with plugin.
+func startSigningServer(t *testing.T, plugin *SigningServerPlugin) *MemoryListener {
+	ln, err := NewMemoryListener("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer()
+	s.ServerCodecFunc = jsonrpc.NewServerCodec
+	s.PluginContainer.Add(plugin)
+	s.RegisterName("Arith", new(MemoryArith))
+	go s.ServeListener(ln)
+	t.Cleanup(func() { ln.Close() })
+	return ln
+}
+
+// signedMetadata returns the metadata of a request signed with the key k1 at ts.
+func signedMetadata(serviceMethod, digest string, ts time.Time, nonce string) Metadata {
+	md := Metadata{
+		signatureKeyIDKey:     "k1",
+		signatureTimestampKey: strconv.FormatInt(ts.UnixNano(), 10),
+		signatureNonceKey:     nonce,
+	}
+	if digest != "" {
+		md[signatureDigestKey] = digest
+	}
+	md[signatureKey] = requestSignature(testSigningKeys["k1"], serviceMethod, md)
+	return md
+}
+
+func TestSigningNilArgs(t *testing.T) {
+	ln := startSigningServer(t, NewSigningServerPlugin(testSigningKeys))
+	c := newMemoryClient(ln)
+	c.PluginContainer.Add(NewSigningClientPlugin("k1", testSigningKeys["k1"]))
+	defer c.Close()
+
+	reply := -1
+	if err := c.Call("Arith.Mul", nil, &reply); err != nil || reply != 0 {
+		t.Fatalf("nil args: reply %d, err %v", reply, err)
+	}
+	if reply, err := callMul(c, time.Second); err != nil || reply != 42 {
+		t.Fatalf("reply %d, err %v", reply, err)
+	}
+}
+
+func TestSigningDigestRequired(t *testing.T) {
+	plugin := NewSigningServerPlugin(testSigningKeys)
+	md := signedMetadata("Arith.Mul", "", time.Now(), "n1")
+	if err := plugin.verifyRequest("Arith.Mul", md); err == nil {
+		t.Fatal("request without a digest accepted")
+	}
+	md = signedMetadata("Arith.Mul", bodyDigest(nil), time.Now(), "n1")
+	if err := plugin.verifyRequest("Arith.Mul", md); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSigningVerifyRequest(t *testing.T) {
+	plugin := NewSigningServerPlugin(testSigningKeys)
+	now := time.Now()
+	digest := bodyDigest([]byte("args"))
+	replayed := signedMetadata("Arith.Mul", digest, now, "replayed")
+	tampered := func(key, value string) Metadata {
+		md := signedMetadata("Arith.Mul", digest, now, "tampered-"+key)
+		md[key] = value
+		return md
+	}
+
+	for _, tt := range []struct {
+		name   string
+		method string
+		md     Metadata
+		valid  bool
+	}{
+		{"signed", "Arith.Mul", signedMetadata("Arith.Mul", digest, now, "n1"), true},
+		{"replayed first", "Arith.Mul", replayed, true},
+		{"replayed", "Arith.Mul", replayed, false},
+		{"other method", "Arith.Div", signedMetadata("Arith.Mul", digest, now, "n2"), false},
+		{"digest changed", "Arith.Mul", tampered(signatureDigestKey, bodyDigest([]byte("other args"))), false},
+		{"metadata added", "Arith.Mul", tampered("tenant", "other"), false},
+		{"nonce changed", "Arith.Mul", tampered(signatureNonceKey, "fresh"), false},
+		{"unknown key", "Arith.Mul", tampered(signatureKeyIDKey, "k2"), false},
+		{"unsigned", "Arith.Mul", tampered(signatureKey, ""), false},
+		{"stale", "Arith.Mul", signedMetadata("Arith.Mul", digest, now.Add(-10*time.Minute), "n3"), false},
+		{"from the future", "Arith.Mul", signedMetadata("Arith.Mul", digest, now.Add(10*time.Minute), "n4"), false},
+		{"within skew", "Arith.Mul", signedMetadata("Arith.Mul", digest, now.Add(-time.Minute), "n5"), true},
+	} {
+		if err := plugin.verifyRequest(tt.method, tt.md); (err == nil) != tt.valid {
+			t.Errorf("%s: err %v", tt.name, err)
+		}
+	}
+}
+
+func TestSigningNonceSweep(t *testing.T) {
+	plugin := NewSigningServerPlugin(testSigningKeys)
+	plugin.MaxSkew = 50 * time.Millisecond
+	for _, nonce := range []string{"n1", "n2"} {
+		if err := plugin.verifyRequest("Arith.Mul", signedMetadata("Arith.Mul", bodyDigest(nil), time.Now(), nonce)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(120 * time.Millisecond)
+	if err := plugin.verifyRequest("Arith.Mul", signedMetadata("Arith.Mul", bodyDigest(nil), time.Now(), "n3")); err != nil {
+		t.Fatal(err)
+	}
+	plugin.mu.Lock()
+	defer plugin.mu.Unlock()
+	if len(plugin.nonces) != 1 {
+		t.Fatalf("nonces %v kept after their requests went stale", plugin.nonces)
+	}
+}
+
+func TestSigningArgsTampered(t *testing.T) {
+	ln := startSigningServer(t, NewSigningServerPlugin(testSigningKeys))
+	good, err := encodeBody(jsonrpc.NewClientCodec, &MemoryArgs{6, 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad, err := encodeBody(jsonrpc.NewClientCodec, &MemoryArgs{600, 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the args sent are signed with the digest of good
+	for _, tt := range []struct {
+		args  []byte
+		valid bool
+	}{{good, true}, {bad, false}} {
+		conn, err := DialMemory(ln.Addr().String(), time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		md := Metadata{signatureDigestKey: bodyDigest(good)}
+		if err := NewSigningClientPlugin("k1", testSigningKeys["k1"]).signRequest("Arith.Mul", md); err != nil {
+			t.Fatal(err)
+		}
+		codec := jsonrpc.NewClientCodec(conn)
+		if err := codec.WriteRequest(&rpc.Request{ServiceMethod: withMetadata("Arith.Mul", md), Seq: 1}, tt.args); err != nil {
+			t.Fatal(err)
+		}
+		var resp rpc.Response
+		var reply int
+		err = codec.ReadResponseHeader(&resp)
+		if err == nil && resp.Error == "" {
+			err = codec.ReadResponseBody(&reply)
+		}
+		conn.Close()
+		if accepted := err == nil && resp.Error == ""; accepted != tt.valid || tt.valid && reply != 42 {
+			t.Errorf("args %s: reply %d, error %q, err %v", tt.args, reply, resp.Error, err)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package src
+
+import (
+	"errors"
+	"io"
+	"net/rpc"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultStreamWindow is the number of items a stream buffers for its reader
+// unless Server.StreamWindow or Client.StreamWindow is set.
+const DefaultStreamWindow = 64
+
+// ErrStreamClosed is returned by Stream.Send once the call has finished or CloseSend has been called.
+var ErrStreamClosed = errors.New("rpc: stream is closed")
+
+// ErrStreamProtocol fails a stream whose peer sent items beyond the credits it was granted,
+// or a malformed stream frame. The items received before it are still returned by Recv.
+var ErrStreamProtocol = errors.New("rpc: stream peer violated flow control")
+
+var typeOfStream = reflect.TypeOf((*Stream)(nil))
+
+type streamType int
+
+const (
+	noStream streamType = iota
+	//serverStream methods look like func (t *T) M(args *A, stream *Stream) error
+	serverStream
+	//clientStream methods look like func (t *T) M(stream *Stream, reply *R) error
+	clientStream
+	//bidiStream methods look like func (t *T) M(stream *Stream) error
+	bidiStream
+)
+
+// Stream carries the items of a streaming call in both directions.
+// A service method gets it as an argument and a client gets it from Client.NewStream.
+//
+// Streams are flow controlled: the sender may only have as many items in flight
+// as the receiver has room for, so Send blocks while the receiver is slow.
+// The receiver announces its room when it calls Recv for the first time, which
+// also fixes the type which all items are decoded into.
+type Stream struct {
+	window int
+	// write sends a control frame of this stream to the peer.
+	write func(serviceMethod string, body interface{}) error
+
+	mu       sync.Mutex
+	cond     *sync.Cond   // signalled when credits or sendErr change
+	credits  int          // items which the peer has room for
+	sendErr  error        // set once no more items can be sent
+	itemType reflect.Type // learned from the first Recv
+	consumed int          // items received since credits were last granted
+
+	items      chan reflect.Value
+	recvClosed chan struct{}
+	recvErr    error // returned by Recv once recvClosed is closed and items is empty
+	recvOnce   sync.Once
+}
+
+func newStream(window int) *Stream {
+	if window <= 0 {
+		window = DefaultStreamWindow
+	}
+	st := &Stream{
+		window:     window,
+		items:      make(chan reflect.Value, window),
+		recvClosed: make(chan struct{}),
+	}
+	st.cond = sync.NewCond(&st.mu)
+	return st
+}
+
+// Send sends an item to the peer. It blocks until the peer has room for it.
+func (st *Stream) Send(item interface{}) error {
+	st.mu.Lock()
+	for st.credits == 0 && st.sendErr == nil {
+		st.cond.Wait()
+	}
+	if st.sendErr != nil {
+		err := st.sendErr
+		st.mu.Unlock()
+		return err
+	}
+	st.credits--
+	st.mu.Unlock()
+
+	return st.writeFrame(itemPrefix, item)
+}
+
+// Recv receives the next item into item, which must be a pointer.
+// It returns io.EOF once the peer has finished sending.
+func (st *Stream) Recv(item interface{}) error {
+	st.mu.Lock()
+	first := st.itemType == nil
+	if first {
+		st.itemType = reflect.TypeOf(item).Elem()
+	}
+	st.mu.Unlock()
+	if first {
+		if err := st.grant(st.window); err != nil {
+			return err
+		}
+	}
+
+	var v reflect.Value
+	select {
+	case v = <-st.items:
+	case <-st.recvClosed:
+		select {
+		case v = <-st.items:
+		default:
+			return st.recvErr
+		}
+	}
+	reflect.ValueOf(item).Elem().Set(v.Elem())
+
+	// give the room back to the peer in batches
+	st.mu.Lock()
+	st.consumed++
+	n := st.consumed
+	if n >= (st.window+1)/2 {
+		st.consumed = 0
+	} else {
+		n = 0
+	}
+	st.mu.Unlock()
+	if n > 0 {
+		return st.grant(n)
+	}
+	return nil
+}
+
+// CloseSend tells the peer that no more items will be sent.
+func (st *Stream) CloseSend() error {
+	st.endSend(ErrStreamClosed)
+	return st.writeFrame(closePrefix, invalidRequest)
+}
+
+func (st *Stream) grant(n int) error {
+	return st.writeFrame(creditPrefix+strconv.Itoa(n), invalidRequest)
+}
+
+func (st *Stream) writeFrame(serviceMethod string, body interface{}) error {
+	if st.write == nil {
+		// the call failed before its request was written
+		return ErrStreamClosed
+	}
+	return st.write(serviceMethod, body)
+}
+
+func (st *Stream) addCredits(n int) {
+	st.mu.Lock()
+	st.credits += n
+	st.cond.Broadcast()
+	st.mu.Unlock()
+}
+
+// readItem is invoked by the connection reader for an item frame of this stream.
+func (st *Stream) readItem(read func(body interface{}) error) error {
+	st.mu.Lock()
+	typ := st.itemType
+	st.mu.Unlock()
+
+	// the peer must not send more than the credits it was granted
+	if typ == nil || len(st.items) == cap(st.items) {
+		err := read(nil)
+		st.fail(ErrStreamProtocol)
+		return err
+	}
+
+	v := reflect.New(typ)
+	if err := read(v.Interface()); err != nil {
+		return err
+	}
+	st.items <- v
+	return nil
+}
+
+// fail ends both directions of the stream with err.
+func (st *Stream) fail(err error) {
+	st.endSend(err)
+	st.endRecv(err)
+}
+
+func (st *Stream) endSend(err error) {
+	st.mu.Lock()
+	if st.sendErr == nil {
+		st.sendErr = err
+	}
+	st.cond.Broadcast()
+	st.mu.Unlock()
+}
+
+func (st *Stream) endRecv(err error) {
+	st.recvOnce.Do(func() {
+		st.recvErr = err
+		close(st.recvClosed)
+	})
+}
+
+// readStreamControl handles a stream control frame read by a connection.
+func readStreamControl(st *Stream, serviceMethod string, read func(body interface{}) error) error {
+	if st == nil {
+		return read(nil)
+	}
+
+	switch {
+	case serviceMethod == itemPrefix:
+		return st.readItem(read)
+	case serviceMethod == closePrefix:
+		st.endRecv(io.EOF)
+	case strings.HasPrefix(serviceMethod, creditPrefix):
+		if n, err := strconv.Atoi(serviceMethod[len(creditPrefix):]); err == nil && n > 0 {
+			st.addCredits(n)
+		} else {
+			st.fail(ErrStreamProtocol)
+		}
+	default:
+		st.fail(ErrStreamProtocol)
+	}
+	return read(nil)
+}
+
+// ClientStream is the client side of a streaming call.
+type ClientStream struct {
+	*Stream
+	finished chan struct{}
+	err      error // error of the call, valid once finished is closed
+	next     error // error returned by Recv in Next
+}
+
+func newClientStream(st *Stream, call *rpc.Call) *ClientStream {
+	cs := &ClientStream{Stream: st, finished: make(chan struct{})}
+	go func() {
+		<-call.Done
+		cs.err = call.Error
+		st.endSend(ErrStreamClosed)
+		if call.Error != nil {
+			st.endRecv(call.Error)
+		} else {
+			st.endRecv(io.EOF)
+		}
+		close(cs.finished)
+	}()
+	return cs
+}
+
+// Wait waits for the service method to return and returns its error.
+// The reply passed to Client.NewStream is filled once it returns.
+func (cs *ClientStream) Wait() error {
+	<-cs.finished
+	return cs.err
+}
+
+// Next receives the next item into item and reports whether there was one, so that
+// items can be iterated with
+//	for cs.Next(&item) { ... }
+//	if err := cs.Err(); err != nil { ... }
+func (cs *ClientStream) Next(item interface{}) bool {
+	if cs.next != nil {
+		return false
+	}
+	cs.next = cs.Recv(item)
+	return cs.next == nil
+}
+
+// Err returns the error which stopped Next, or nil if the stream ended normally.
+func (cs *ClientStream) Err() error {
+	if cs.next == io.EOF {
+		return nil
+	}
+	return cs.next
+}
@@ -0,0 +1,169 @@
+package src
+
+import (
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testStream is a Stream whose control frames are recorded instead of sent.
+type testStream struct {
+	*Stream
+	mu     sync.Mutex
+	frames []string
+}
+
+func newTestStream(window int) *testStream {
+	ts := &testStream{Stream: newStream(window)}
+	ts.write = func(serviceMethod string, body interface{}) error {
+		ts.mu.Lock()
+		ts.frames = append(ts.frames, serviceMethod)
+		ts.mu.Unlock()
+		return nil
+	}
+	return ts
+}
+
+func (ts *testStream) sent() []string {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return append([]string(nil), ts.frames...)
+}
+
+// receive hands the stream a frame from its peer, with item as the body of item frames.
+func (ts *testStream) receive(serviceMethod string, item int) error {
+	return readStreamControl(ts.Stream, serviceMethod, func(body interface{}) error {
+		if p, ok := body.(*int); ok {
+			*p = item
+		}
+		return nil
+	})
+}
+
+func TestStreamSendCredits(t *testing.T) {
+	ts := newTestStream(4)
+	sent := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() { sent <- ts.Send(1) }()
+	}
+	select {
+	case err := <-sent:
+		t.Fatalf("item sent without credits, err %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	ts.receive(creditPrefix+"2", 0)
+	for i := 0; i < 2; i++ {
+		if err := <-sent; err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case err := <-sent:
+		t.Fatalf("item sent beyond credits, err %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	ts.receive(creditPrefix+"1", 0)
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+	if frames := ts.sent(); !reflect.DeepEqual(frames, []string{itemPrefix, itemPrefix, itemPrefix}) {
+		t.Fatalf("frames %v", frames)
+	}
+
+	if err := ts.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	ts.receive(creditPrefix+"1", 0)
+	if err := ts.Send(1); err != ErrStreamClosed {
+		t.Fatalf("send after CloseSend: err %v", err)
+	}
+}
+
+func TestStreamRecvGrantsCredits(t *testing.T) {
+	ts := newTestStream(4)
+	received := make(chan int, 8)
+	go func() {
+		var item int
+		for ts.Recv(&item) == nil {
+			received <- item
+		}
+		close(received)
+	}()
+	// the first Recv grants the whole window
+	for deadline := time.Now().Add(time.Second); len(ts.sent()) == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("no credits granted")
+		}
+	}
+
+	for i := 1; i <= 6; i++ {
+		if err := ts.receive(itemPrefix, i); err != nil {
+			t.Fatal(err)
+		}
+		if item := <-received; item != i {
+			t.Fatalf("item %d, want %d", item, i)
+		}
+	}
+	ts.receive(closePrefix, 0)
+	if _, ok := <-received; ok {
+		t.Fatal("item received after the peer closed")
+	}
+	// then gives the room back in batches of half of it
+	want := []string{creditPrefix + "4", creditPrefix + "2", creditPrefix + "2", creditPrefix + "2"}
+	if frames := ts.sent(); !reflect.DeepEqual(frames, want) {
+		t.Fatalf("frames %v, want %v", frames, want)
+	}
+}
+
+func TestStreamProtocolErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		frames []string
+	}{
+		{"item before the first Recv", []string{itemPrefix}},
+		{"items beyond the credits", []string{"", itemPrefix, itemPrefix, itemPrefix}},
+		{"no credits", []string{creditPrefix + "0"}},
+		{"negative credits", []string{creditPrefix + "-1"}},
+		{"malformed credits", []string{creditPrefix + "x"}},
+		{"unknown frame", []string{controlPrefix + "unknown"}},
+	} {
+		ts := newTestStream(2)
+		for i, frame := range tt.frames {
+			if frame == "" {
+				// as if Recv had been called, without it taking any item
+				ts.itemType = reflect.TypeOf(0)
+				continue
+			}
+			ts.receive(frame, i)
+		}
+		if err := ts.Send(1); err != ErrStreamProtocol {
+			t.Errorf("%s: send err %v", tt.name, err)
+		}
+
+		// the items received within the credits are still returned
+		var items []int
+		var err error
+		for {
+			var item int
+			if err = ts.Recv(&item); err != nil {
+				break
+			}
+			items = append(items, item)
+		}
+		if err != ErrStreamProtocol {
+			t.Errorf("%s: recv err %v", tt.name, err)
+		}
+		if tt.name == "items beyond the credits" && !reflect.DeepEqual(items, []int{1, 2}) {
+			t.Errorf("%s: items %v received", tt.name, items)
+		}
+	}
+
+	ts := newTestStream(2)
+	ts.receive(closePrefix, 0)
+	if err := ts.Recv(new(int)); err != io.EOF {
+		t.Fatalf("recv after the peer closed: err %v", err)
+	}
+}
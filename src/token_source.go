@@ -0,0 +1,59 @@
+package src
+
+import (
+	"sync"
+	"time"
+)
+
+// TokenSource supplies the authorization tokens of a Client, see Client.AuthTokenSource.
+type TokenSource interface {
+	Token() (string, error)
+}
+
+//DefaultTokenRefreshBefore is how long before their expiry RefreshingTokenSource replaces tokens
+const DefaultTokenRefreshBefore = time.Minute
+
+// RefreshingTokenSource caches the token returned by Fetch and fetches a new one
+// when the cached one is about to expire.
+type RefreshingTokenSource struct {
+	//Fetch gets a new token and the time it expires
+	Fetch func() (token string, expiry time.Time, err error)
+	//RefreshBefore is how long before the expiry a new token is fetched, DefaultTokenRefreshBefore if zero
+	RefreshBefore time.Duration
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// NewRefreshingTokenSource creates a RefreshingTokenSource of fetch.
+func NewRefreshingTokenSource(fetch func() (string, time.Time, error)) *RefreshingTokenSource {
+	return &RefreshingTokenSource{Fetch: fetch}
+}
+
+// Token returns the cached token, or a new one if it is about to expire.
+// The cached token is returned while it is still valid if fetching fails.
+func (ts *RefreshingTokenSource) Token() (string, error) {
+	before := ts.RefreshBefore
+	if before <= 0 {
+		before = DefaultTokenRefreshBefore
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	now := time.Now()
+	if ts.token != "" && now.Add(before).Before(ts.expiry) {
+		return ts.token, nil
+	}
+
+	token, expiry, err := ts.Fetch()
+	if err != nil {
+		if ts.token != "" && now.Before(ts.expiry) {
+			return ts.token, nil
+		}
+		return "", err
+	}
+	ts.token, ts.expiry = token, expiry
+	return token, nil
+}
@@ -0,0 +1,239 @@
+package src
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// SpanID identifies a span of a trace.
+type SpanID [8]byte
+
+// IsValid reports whether id is not zero.
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+
+// IsValid reports whether id is not zero.
+func (id SpanID) IsValid() bool { return id != SpanID{} }
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// SpanKind tells whether a span is a call made by a client or handled by a server.
+// The values are those of OTLP.
+type SpanKind int
+
+const (
+	//SpanKindServer is a span of a call handled by a server
+	SpanKindServer SpanKind = 2
+	//SpanKindClient is a span of a call made by a client
+	SpanKindClient SpanKind = 3
+)
+
+// Span is a timed step of a trace.
+type Span struct {
+	TraceID TraceID
+	SpanID  SpanID
+	//ParentSpanID is zero for the first span of a trace
+	ParentSpanID SpanID
+	Name         string
+	Kind         SpanKind
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]string
+	//Error is the error the call failed with, empty on success
+	Error string
+
+	mu sync.Mutex
+}
+
+// SetAttribute sets an attribute of the span, which handlers may do on the span of their call.
+func (s *Span) SetAttribute(key, value string) {
+	s.mu.Lock()
+	s.Attributes[key] = value
+	s.mu.Unlock()
+}
+
+// SpanExporter sends finished spans to a tracing backend.
+type SpanExporter interface {
+	ExportSpan(span *Span) error
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of ctx which carries span, so that calls made with it
+// by Client.CallContext continue the trace of span.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span carried by ctx. Handlers find the span of their call in their context.
+func SpanFromContext(ctx context.Context) (*Span, bool) {
+	span, ok := ctx.Value(spanKey{}).(*Span)
+	return span, ok
+}
+
+// traceparentKey is the metadata key of the trace context, in the W3C traceparent format.
+const traceparentKey = "traceparent"
+
+func formatTraceparent(traceID TraceID, spanID SpanID) string {
+	return "00-" + traceID.String() + "-" + spanID.String() + "-01"
+}
+
+func parseTraceparent(s string) (traceID TraceID, spanID SpanID, ok bool) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil {
+		return
+	}
+	if _, err := hex.Decode(spanID[:], []byte(parts[2])); err != nil {
+		return
+	}
+	return traceID, spanID, traceID.IsValid() && spanID.IsValid()
+}
+
+// startSpan starts a span of the trace traceID, or of a new trace if traceID is zero.
+func startSpan(name string, kind SpanKind, traceID TraceID, parent SpanID) *Span {
+	span := &Span{
+		TraceID:      traceID,
+		ParentSpanID: parent,
+		Name:         name,
+		Kind:         kind,
+		Start:        time.Now(),
+		Attributes:   make(map[string]string),
+	}
+	if !span.TraceID.IsValid() {
+		rand.Read(span.TraceID[:])
+	}
+	rand.Read(span.SpanID[:])
+	return span
+}
+
+// endSpan ends span with the error of its call and exports it.
+func endSpan(exporter SpanExporter, span *Span, err error) {
+	span.mu.Lock()
+	span.End = time.Now()
+	if err != nil {
+		span.Error = err.Error()
+	}
+	span.mu.Unlock()
+
+	if exporter == nil {
+		return
+	}
+	if err := exporter.ExportSpan(span); err != nil {
+		DefaultLogger.Warn("exporting span", "trace_id", span.TraceID.String(), "err", err)
+	}
+}
+
+// TracingClientPlugin traces the calls of a Client. Every call gets a span which continues
+// the trace carried by the context passed to Client.CallContext, and the server receives
+// the ids of the span in the metadata of the request.
+type TracingClientPlugin struct {
+	Exporter SpanExporter
+}
+
+// NewTracingClientPlugin creates a TracingClientPlugin which exports spans to exporter.
+func NewTracingClientPlugin(exporter SpanExporter) *TracingClientPlugin {
+	return &TracingClientPlugin{Exporter: exporter}
+}
+
+// PreCall starts the span of a call and adds its ids to the outgoing metadata.
+func (plugin *TracingClientPlugin) PreCall(ctx context.Context, info *CallInfo) (context.Context, error) {
+	var traceID TraceID
+	var parent SpanID
+	if p, ok := SpanFromContext(ctx); ok {
+		traceID, parent = p.TraceID, p.SpanID
+	}
+
+	span := startSpan(info.ServiceMethod, SpanKindClient, traceID, parent)
+	span.Attributes["rpc.system"] = "rpct"
+	span.Attributes["rpc.method"] = info.ServiceMethod
+	if info.Peer != "" {
+		span.Attributes["rpc.peer"] = info.Peer
+	}
+	if info.SelectMode != "" {
+		span.Attributes["rpc.select_mode"] = info.SelectMode
+	}
+
+	md := Metadata{}
+	if outgoing, ok := OutgoingMetadataFromContext(ctx); ok {
+		md = outgoing.Copy()
+	}
+	md[traceparentKey] = formatTraceparent(span.TraceID, span.SpanID)
+
+	ctx = ContextWithSpan(ctx, span)
+	return NewOutgoingContext(ctx, md), nil
+}
+
+// PostCall ends the span of a call.
+func (plugin *TracingClientPlugin) PostCall(ctx context.Context, info *CallInfo, err error) {
+	if span, ok := SpanFromContext(ctx); ok && span.Kind == SpanKindClient {
+		endSpan(plugin.Exporter, span, err)
+	}
+}
+
+// Name return name of this plugin.
+func (plugin *TracingClientPlugin) Name() string {
+	return "TracingClientPlugin"
+}
+
+// Description return description of this plugin.
+func (plugin *TracingClientPlugin) Description() string {
+	return "a tracing plugin which starts a span per call"
+}
+
+// TracingServerPlugin traces the calls handled by a Server. Every call gets a span
+// which is a child of the span of the client, if the client sent one.
+// Handlers find the span in their context with SpanFromContext.
+type TracingServerPlugin struct {
+	Exporter SpanExporter
+}
+
+// NewTracingServerPlugin creates a TracingServerPlugin which exports spans to exporter.
+func NewTracingServerPlugin(exporter SpanExporter) *TracingServerPlugin {
+	return &TracingServerPlugin{Exporter: exporter}
+}
+
+// PreCall starts the span of a call.
+func (plugin *TracingServerPlugin) PreCall(ctx context.Context, info *CallInfo) (context.Context, error) {
+	var traceID TraceID
+	var parent SpanID
+	if md, ok := MetadataFromContext(ctx); ok {
+		// a malformed traceparent may have a valid trace id but no parent, it starts a new trace
+		if traceID, parent, ok = parseTraceparent(md[traceparentKey]); !ok {
+			traceID, parent = TraceID{}, SpanID{}
+		}
+	}
+
+	span := startSpan(info.ServiceMethod, SpanKindServer, traceID, parent)
+	span.Attributes["rpc.system"] = "rpct"
+	span.Attributes["rpc.method"] = info.ServiceMethod
+	span.Attributes["rpc.peer"] = info.Peer
+	return ContextWithSpan(ctx, span), nil
+}
+
+// PostCall ends the span of a call.
+func (plugin *TracingServerPlugin) PostCall(ctx context.Context, info *CallInfo, err error) {
+	if span, ok := SpanFromContext(ctx); ok && span.Kind == SpanKindServer {
+		endSpan(plugin.Exporter, span, err)
+	}
+}
+
+// Name return name of this plugin.
+func (plugin *TracingServerPlugin) Name() string {
+	return "TracingServerPlugin"
+}
+
+// Description return description of this plugin.
+func (plugin *TracingServerPlugin) Description() string {
+	return "a tracing plugin which continues the traces of clients"
+}